@@ -1,13 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	sitemap "github.com/dinofizz/sitemapper/sitemapper/internal"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -22,6 +25,11 @@ func (a *API) initRoutes() {
 	a.router.HandleFunc("/ready", a.health).Methods("GET")
 	a.router.HandleFunc("/sitemap", a.createSitemap).Methods("POST")
 	a.router.HandleFunc("/sitemap/{id}", a.getSitemapResults).Methods("GET")
+	a.router.HandleFunc("/sitemap/{id}/broken", a.getBrokenLinks).Methods("GET")
+	a.router.HandleFunc("/sitemap/{id}/external", a.getExternalLinks).Methods("GET")
+	a.router.HandleFunc("/sitemap/{id}/redirects", a.getRedirects).Methods("GET")
+	a.router.HandleFunc("/sitemap/{id}/stats", a.getStats).Methods("GET")
+	a.router.HandleFunc("/sitemap/{id}/diff/{otherId}", a.getDiff).Methods("GET")
 }
 
 func (a *API) health(w http.ResponseWriter, r *http.Request) {
@@ -32,8 +40,21 @@ func (a *API) health(w http.ResponseWriter, r *http.Request) {
 }
 
 type SitemapCreateRequest struct {
-	URL      string
-	MaxDepth int
+	URL                string
+	MaxDepth           int
+	RequestsPerSecond  float64
+	MinDelayMs         int
+	MaxInFlightPerHost *int `json:",omitempty"`
+	QueryMode          string
+	QueryParams        []string
+	MaxParamValues     int
+	Include            []string
+	Exclude            []string
+	HostMode           string
+	Hosts              []string
+	Client             *sitemap.ClientConfig `json:",omitempty"`
+	Retry              *sitemap.RetryPolicy  `json:",omitempty"`
+	RespectRobots      bool
 }
 type SitemapCreateResponse struct {
 	SitemapCreateRequest
@@ -48,6 +69,17 @@ func (a *API) createSitemap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// An omitted per-host limit uses the default, while zero removes the limit
+	maxPerHost := sitemap.DefaultMaxInFlightPerHost
+	if scr.MaxInFlightPerHost != nil {
+		maxPerHost = *scr.MaxInFlightPerHost
+	}
+	scr.MaxInFlightPerHost = &maxPerHost
+	if scr.RequestsPerSecond < 0 || scr.MinDelayMs < 0 || maxPerHost < 0 {
+		respondWithError(w, http.StatusBadRequest, "Politeness settings must not be negative")
+		return
+	}
+
 	sitemapID, err := uuid.NewUUID()
 	if err != nil {
 		log.Print(err)
@@ -55,40 +87,175 @@ func (a *API) createSitemap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.nats.SendStartMessage(sitemapID, scr.URL, scr.MaxDepth)
+	opts := sitemap.CrawlOptions{
+		RequestsPerSecond:  scr.RequestsPerSecond,
+		MinDelayMs:         scr.MinDelayMs,
+		MaxInFlightPerHost: maxPerHost,
+		QueryMode:          scr.QueryMode,
+		QueryParams:        scr.QueryParams,
+		MaxParamValues:     scr.MaxParamValues,
+		Include:            scr.Include,
+		Exclude:            scr.Exclude,
+		HostMode:           scr.HostMode,
+		Hosts:              scr.Hosts,
+		Client:             scr.Client,
+		Retry:              scr.Retry,
+		RespectRobots:      scr.RespectRobots,
+	}
+	if err := opts.QueryPolicy().Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := opts.Scope(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := opts.HostScope().Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Client != nil {
+		if err := opts.Client.Validate(); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if opts.Retry != nil {
+		if err := opts.Retry.Validate(); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	err = a.nats.SendStartMessage(sitemapID, scr.URL, scr.MaxDepth, opts)
 	if err != nil {
 		log.Print(err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to send start message")
 		return
 	}
 
+	// The credentials are not echoed back
+	if scr.Client != nil {
+		c := scr.Client.WithoutCredentials()
+		scr.Client = &c
+	}
 	response := SitemapCreateResponse{SitemapID: sitemapID.String(), SitemapCreateRequest: scr}
 	respondWithJSON(w, 200, response)
 }
 
-func (a *API) getSitemapResults(w http.ResponseWriter, r *http.Request) {
+// sitemapIDFromVar returns the sitemap ID held by the named path variable, writing an error response and returning
+// false if it is missing or invalid.
+func sitemapIDFromVar(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
 	vars := mux.Vars(r)
-	sid := vars["id"]
+	sid := vars[name]
 	if sid == "" {
 		respondWithError(w, http.StatusBadRequest, "No sitemap ID in path")
-		return
+		return uuid.Nil, false
 	}
 
 	sitemapID, err := uuid.Parse(sid)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Sitemap ID invalid")
-		return
+		return uuid.Nil, false
+	}
+	return sitemapID, true
+}
+
+// requestFormat returns the format query parameter of the request, which must be empty or one of the given formats.
+// If it is not an error response is written and false is returned.
+func requestFormat(w http.ResponseWriter, r *http.Request, formats ...string) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return format, true
+	}
+	for _, f := range formats {
+		if f == format {
+			return format, true
+		}
+	}
+	respondWithError(w, http.StatusBadRequest, "Unsupported format")
+	return "", false
+}
+
+// loadSitemap returns the details and results of the sitemap with the ID held by the named path variable. If the ID is
+// invalid or the sitemap cannot be loaded an error response is written and false is returned.
+func (a *API) loadSitemap(w http.ResponseWriter, r *http.Request, name string) (*sitemap.Details, []sitemap.Result, bool) {
+	sitemapID, ok := sitemapIDFromVar(w, r, name)
+	if !ok {
+		return nil, nil, false
 	}
 
 	smDetails, err := a.CassDB.GetSitemapDetails(sitemapID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, nil, false
 	}
 
 	results, err := a.CassDB.GetSitemapResults(sitemapID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+	return smDetails, *results, true
+}
+
+// A report is the response of one of the report endpoints, written as CSV, as a plain text table or by default as JSON.
+type report struct {
+	csv   func(b *bytes.Buffer) error
+	table func(b *bytes.Buffer) error
+	json  interface{}
+}
+
+// respondWithReport loads the sitemap in the request path and writes the report built from its results in the
+// requested format.
+func (a *API) respondWithReport(w http.ResponseWriter, r *http.Request, build func(smDetails *sitemap.Details, results []sitemap.Result) report) {
+	format, ok := requestFormat(w, r, "json", "csv", "table")
+	if !ok {
+		return
+	}
+	smDetails, results, ok := a.loadSitemap(w, r, "id")
+	if !ok {
+		return
+	}
+
+	rep := build(smDetails, results)
+	switch format {
+	case "csv":
+		respondWithText(w, "text/csv", rep.csv)
+	case "table":
+		respondWithText(w, "text/plain", rep.table)
+	default:
+		respondWithJSON(w, http.StatusOK, rep.json)
+	}
+}
+
+func (a *API) getSitemapResults(w http.ResponseWriter, r *http.Request) {
+	format, ok := requestFormat(w, r, append([]string{"json", "xml"}, sitemap.GraphFormats...)...)
+	if !ok {
+		return
+	}
+	ge := sitemap.NewGraphEncoder(format)
+	if c := r.URL.Query().Get("cluster"); c != "" {
+		depth, err := strconv.Atoi(c)
+		if err != nil || depth < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid cluster depth")
+			return
+		}
+		ge.ClusterDepth = depth
+	}
+
+	smDetails, results, ok := a.loadSitemap(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if format == "xml" {
+		respondWithXML(w, r, sitemap.ResultSitemapURLs(results))
+		return
+	}
+
+	if sitemap.IsGraphFormat(format) {
+		contentType := graphContentTypes[format]
+		respondWithText(w, contentType, func(b *bytes.Buffer) error { return ge.Encode(b, sitemap.NewGraph(smDetails.URL, results)) })
 		return
 	}
 
@@ -97,21 +264,143 @@ func (a *API) getSitemapResults(w http.ResponseWriter, r *http.Request) {
 		SitemapID string
 		MaxDepth  int
 		URL       string
-		Results   *[]sitemap.Result
+		Results   []sitemap.Result
 	}{
-		Count:     len(*results),
+		Count:     len(results),
 		SitemapID: smDetails.SitemapID,
 		URL:       smDetails.URL,
 		MaxDepth:  smDetails.MaxDepth,
 		Results:   results,
 	}
 
-	response.SitemapID = smDetails.SitemapID
-
 	respondWithJSON(w, http.StatusOK, response)
 
 }
 
+func (a *API) getBrokenLinks(w http.ResponseWriter, r *http.Request) {
+	a.respondWithReport(w, r, func(smDetails *sitemap.Details, results []sitemap.Result) report {
+		broken := sitemap.FindBrokenLinks(results)
+		return report{
+			csv:   func(b *bytes.Buffer) error { return sitemap.WriteBrokenLinksCSV(b, broken) },
+			table: func(b *bytes.Buffer) error { return sitemap.WriteBrokenLinksTable(b, broken) },
+			json: struct {
+				Count       int
+				SitemapID   string
+				URL         string
+				BrokenLinks []sitemap.BrokenLink
+			}{
+				Count:       len(broken),
+				SitemapID:   smDetails.SitemapID,
+				URL:         smDetails.URL,
+				BrokenLinks: broken,
+			},
+		}
+	})
+}
+
+func (a *API) getExternalLinks(w http.ResponseWriter, r *http.Request) {
+	a.respondWithReport(w, r, func(smDetails *sitemap.Details, results []sitemap.Result) report {
+		domains := sitemap.FindExternalDomains(results)
+		return report{
+			csv:   func(b *bytes.Buffer) error { return sitemap.WriteExternalDomainsCSV(b, domains) },
+			table: func(b *bytes.Buffer) error { return sitemap.WriteExternalDomainsTable(b, domains) },
+			json: struct {
+				Count     int
+				SitemapID string
+				URL       string
+				Domains   []sitemap.ExternalDomain
+			}{
+				Count:     len(domains),
+				SitemapID: smDetails.SitemapID,
+				URL:       smDetails.URL,
+				Domains:   domains,
+			},
+		}
+	})
+}
+
+func (a *API) getRedirects(w http.ResponseWriter, r *http.Request) {
+	a.respondWithReport(w, r, func(smDetails *sitemap.Details, results []sitemap.Result) report {
+		chains := sitemap.FindRedirectChains(results)
+		return report{
+			csv:   func(b *bytes.Buffer) error { return sitemap.WriteRedirectChainsCSV(b, chains) },
+			table: func(b *bytes.Buffer) error { return sitemap.WriteRedirectChainsTable(b, chains) },
+			json: struct {
+				Count     int
+				SitemapID string
+				URL       string
+				Redirects []sitemap.RedirectChain
+			}{
+				Count:     len(chains),
+				SitemapID: smDetails.SitemapID,
+				URL:       smDetails.URL,
+				Redirects: chains,
+			},
+		}
+	})
+}
+
+func (a *API) getStats(w http.ResponseWriter, r *http.Request) {
+	a.respondWithReport(w, r, func(smDetails *sitemap.Details, results []sitemap.Result) report {
+		stats := sitemap.Analyze(smDetails.URL, results)
+		return report{
+			csv:   func(b *bytes.Buffer) error { return sitemap.WriteGraphStatsCSV(b, stats) },
+			table: func(b *bytes.Buffer) error { return sitemap.WriteGraphStatsTable(b, stats) },
+			json: struct {
+				SitemapID string
+				URL       string
+				sitemap.GraphStats
+			}{
+				SitemapID:  smDetails.SitemapID,
+				URL:        smDetails.URL,
+				GraphStats: stats,
+			},
+		}
+	})
+}
+
+func (a *API) getDiff(w http.ResponseWriter, r *http.Request) {
+	format, ok := requestFormat(w, r, "json", "text", "markdown")
+	if !ok {
+		return
+	}
+	smDetails, results, ok := a.loadSitemap(w, r, "id")
+	if !ok {
+		return
+	}
+	otherDetails, otherResults, ok := a.loadSitemap(w, r, "otherId")
+	if !ok {
+		return
+	}
+
+	// The sitemap in the path is the old crawl, and the other sitemap the new one
+	d := sitemap.DiffResults(results, otherResults)
+	switch format {
+	case "text":
+		respondWithText(w, "text/plain", func(b *bytes.Buffer) error { return sitemap.WriteCrawlDiffText(b, d) })
+		return
+	case "markdown":
+		respondWithText(w, "text/markdown", func(b *bytes.Buffer) error { return sitemap.WriteCrawlDiffMarkdown(b, d) })
+		return
+	}
+
+	response := struct {
+		SitemapID      string
+		URL            string
+		OtherSitemapID string
+		OtherURL       string
+		sitemap.CrawlDiff
+	}{
+		SitemapID:      smDetails.SitemapID,
+		URL:            smDetails.URL,
+		OtherSitemapID: otherDetails.SitemapID,
+		OtherURL:       otherDetails.URL,
+		CrawlDiff:      d,
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
 func main() {
 	router := mux.NewRouter()
 	nm := sitemap.NewNATSManager()
@@ -134,6 +423,82 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
 
+// respondWithXML writes the URLs as an XML sitemap. If they do not fit into a single sitemap file a sitemap index is
+// written instead, referencing each file by its page number in the page query parameter of the request URL.
+func respondWithXML(w http.ResponseWriter, r *http.Request, urls []sitemap.SitemapURL) {
+	var b bytes.Buffer
+	xe := sitemap.NewXMLEncoder()
+	pages, err := xe.Pages(urls)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch p := r.URL.Query().Get("page"); {
+	case p != "":
+		page, perr := strconv.Atoi(p)
+		if perr != nil || page < 1 || page > pages {
+			respondWithError(w, http.StatusNotFound, "Sitemap page not found")
+			return
+		}
+		err = xe.EncodePage(&b, urls, page)
+	case pages > 1:
+		err = xe.EncodeIndex(&b, urls, func(page int) string { return pageURL(r, page) })
+	default:
+		err = xe.Encode(&b, urls)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, err = b.WriteTo(w)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// pageURL returns the absolute URL of the request with the page query parameter set to the given page. The scheme is
+// taken from the X-Forwarded-Proto header when the API is behind a proxy.
+func pageURL(r *http.Request, page int) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	}
+	q := r.URL.Query()
+	q.Set("page", strconv.Itoa(page))
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// graphContentTypes are the content types of the link graph formats returned by the results endpoint.
+var graphContentTypes = map[string]string{
+	sitemap.GraphFormatDOT:     "text/vnd.graphviz",
+	sitemap.GraphFormatGraphML: "application/graphml+xml",
+	sitemap.GraphFormatGEXF:    "application/gexf+xml",
+	sitemap.GraphFormatCSV:     "text/csv",
+}
+
+func respondWithText(w http.ResponseWriter, contentType string, write func(b *bytes.Buffer) error) {
+	var b bytes.Buffer
+	if err := write(&b); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, err := b.WriteTo(w)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
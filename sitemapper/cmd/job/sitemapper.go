@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/dinofizz/sitemapper/sitemapper/internal"
@@ -9,16 +10,67 @@ import (
 	"github.com/spf13/cobra"
 	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 var site string
+var depth int
+var parent string
 var id string
+var respectRobots bool
+var rps float64
+var minDelay time.Duration
+var maxPerHost int
+var queryMode string
+var queryParams []string
+var maxParamValues int
+var include []string
+var exclude []string
+var hostMode string
+var hosts []string
+var clientFlags sitemap.ClientFlags
+var maxAttempts int
+var retryDelay time.Duration
+var retryMaxDelay time.Duration
+var requeue bool
 
 func init() {
 	rootCmd.Flags().StringVarP(&site, "site", "s", "", "Site to crawl, including http scheme")
+	rootCmd.Flags().IntVar(&depth, "depth", 0, "Depth of the site within the sitemap, recorded with the results")
+	rootCmd.Flags().StringVar(&parent, "parent", "", "Page on which the site was found, recorded with the results")
 	rootCmd.Flags().StringVar(&id, "id", "", "Crawl job identifier")
+	rootCmd.Flags().BoolVar(&respectRobots, "respect-robots", false, "Skip URLs disallowed by robots.txt, and wait for each host's Crawl-delay")
+	rootCmd.Flags().Float64Var(&rps, "rps", 0, "Maximum requests per second to each host (0 for no limit)")
+	rootCmd.Flags().DurationVar(&minDelay, "min-delay", 0, "Minimum delay between requests to the same host")
+	rootCmd.Flags().StringVar(&queryMode, "query", sitemap.QueryKeepAll, "Specify which query parameters are kept on links: all, keep, drop, none")
+	rootCmd.Flags().StringSliceVar(&queryParams, "query-params", nil, "Query parameters to keep or drop in the keep and drop query modes, e.g. page,id")
+	rootCmd.Flags().IntVar(&maxParamValues, "max-param-values", 0, "Maximum distinct values followed for each query parameter on a path (0 for no limit)")
+	rootCmd.Flags().StringArrayVar(&include, "include", nil, "Only crawl URLs matching this scope rule, may be repeated")
+	rootCmd.Flags().StringArrayVar(&exclude, "exclude", nil, "Do not crawl URLs matching this scope rule, may be repeated")
+	rootCmd.Flags().StringVar(&hostMode, "host-scope", sitemap.HostScopeExact, "Specify which hosts are crawled: host, domain, hosts")
+	rootCmd.Flags().StringSliceVar(&hosts, "hosts", nil, "Additional hosts crawled in the hosts scope mode")
+	rootCmd.Flags().IntVar(&maxPerHost, "max-per-host", sitemap.DefaultMaxInFlightPerHost, "Maximum concurrent requests to each host (0 for no limit)")
+	rootCmd.Flags().StringVar(&clientFlags.ConfigFile, "config", "", "Read HTTP client settings from this JSON file, flags override the file")
+	rootCmd.Flags().StringVar(&clientFlags.UserAgent, "user-agent", sitemap.DefaultUserAgent, "User agent sent with each request and used to select robots.txt rules")
+	rootCmd.Flags().StringArrayVar(&clientFlags.Headers, "header", nil, "Extra request header in the form \"Name: value\" (may be repeated)")
+	rootCmd.Flags().StringArrayVar(&clientFlags.Cookies, "cookie", nil, "Cookie sent with each request in the form name=value (may be repeated)")
+	rootCmd.Flags().StringVar(&clientFlags.BasicAuth, "basic-auth", "", "Credentials for HTTP basic authentication in the form user:password")
+	rootCmd.Flags().StringVar(&clientFlags.BearerToken, "bearer-token", "", "Token sent in an Authorization: Bearer header")
+	rootCmd.Flags().StringSliceVar(&clientFlags.CredentialHosts, "credential-hosts", nil, "Hosts sent the headers, cookies and credentials, e.g. example.com,*.example.com (default is the --site host)")
+	rootCmd.Flags().StringVar(&clientFlags.Proxy, "proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL")
+	rootCmd.Flags().StringVar(&clientFlags.CAFile, "ca-file", "", "PEM file of certificate authorities to trust in addition to the system roots")
+	rootCmd.Flags().BoolVar(&clientFlags.Insecure, "insecure", false, "Skip verification of server TLS certificates")
+	rootCmd.Flags().DurationVar(&clientFlags.Timeout, "request-timeout", sitemap.DefaultTimeout, "Time allowed for each request")
+	rootCmd.Flags().Int64Var(&clientFlags.MaxBodyBytes, "max-body-size", sitemap.DefaultMaxBodyBytes, "Maximum number of bytes read from each response")
+	rootCmd.Flags().StringSliceVar(&clientFlags.ContentTypes, "content-types", sitemap.DefaultContentTypes, "Media types of the pages downloaded and parsed for links, e.g. text/html,text/*")
+	rootCmd.Flags().BoolVar(&clientFlags.HeadCheck, "head-check", false, "Send a HEAD request before each page and skip pages with other content types without downloading them")
+	rootCmd.Flags().IntVar(&clientFlags.MaxRedirects, "max-redirects", sitemap.DefaultMaxRedirects, "Maximum redirects followed for each request, longer chains are reported as errors")
+	rootCmd.Flags().IntVar(&maxAttempts, "max-attempts", 1, "Maximum attempts for each URL failing with a timeout, connection error, 429 or 5xx response")
+	rootCmd.Flags().DurationVar(&retryDelay, "retry-delay", sitemap.DefaultRetryBaseDelay, "Delay before the first retry, doubling for each further retry")
+	rootCmd.Flags().DurationVar(&retryMaxDelay, "retry-max-delay", sitemap.DefaultRetryMaxDelay, "Maximum delay between retries, including delays requested by Retry-After")
+	rootCmd.Flags().BoolVar(&requeue, "requeue", false, "Crawl URLs which still fail after their retries once more at the end of the crawl")
 	err := rootCmd.MarkFlagRequired("site")
 	if err != nil {
 		log.Fatalf(err.Error())
@@ -33,20 +85,57 @@ var rootCmd = &cobra.Command{
 	Use:   "sitemapper",
 	Short: "Crawls from a start URL and writes a JSON based sitemap to a NATS topic",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		startUrl := strings.ToLower(site)
+		qp := sitemap.QueryPolicy{Mode: queryMode, Params: queryParams, MaxValues: maxParamValues}
+		if err := qp.Validate(); err != nil {
+			return err
+		}
+		scope, err := sitemap.NewScope(include, exclude)
+		if err != nil {
+			return err
+		}
+		hs := sitemap.HostScope{Mode: hostMode, Hosts: hosts}
+		if err := hs.Validate(); err != nil {
+			return err
+		}
+		rp := sitemap.RetryPolicy{MaxAttempts: maxAttempts, BaseDelay: retryDelay, MaxDelay: retryMaxDelay, Requeue: requeue}
+		if err := rp.Validate(); err != nil {
+			return err
+		}
+		cfg, err := clientConfig(cmd)
+		if err != nil {
+			return err
+		}
+		client, err := sitemap.NewHTTPClient(cfg)
+		if err != nil {
+			return err
+		}
+		startUrl := site
 		ns := sitemap.NewNATSManager()
 		defer ns.Stop()
 		sm := sitemap.NewSiteMap()
-		c := sitemap.NewConcurrentCrawlEngine(sm, 1, startUrl)
+		var opts []sitemap.Option
+		robots := sitemap.NewRobotsCheckerWithClient(client)
+		p := sitemap.Politeness{RequestsPerSecond: rps, MinDelay: minDelay, MaxInFlight: maxPerHost}
+		if respectRobots {
+			opts = append(opts, sitemap.WithRobots(robots), sitemap.WithHostLimiter(sitemap.NewHostLimiter(p, robots)))
+		} else {
+			opts = append(opts, sitemap.WithHostLimiter(sitemap.NewHostLimiter(p, nil)))
+		}
+		opts = append(opts, sitemap.WithQueryPolicy(qp), sitemap.WithScope(scope), sitemap.WithHostScope(hs), sitemap.WithRetryPolicy(rp))
+		// External links are always recorded, so that they can be reported by the API
+		opts = append(opts, sitemap.WithExternalLinks(), sitemap.WithHTTPClient(client))
+		c := sitemap.NewConcurrentCrawlEngine(sm, 1, startUrl, opts...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 		log.Printf("Crawling %s", site)
 		start := time.Now()
-		c.Run()
+		c.Run(ctx)
 		end := time.Now()
 		elapsed := end.Sub(start)
 		log.Println("Elapsed milliseconds: ", elapsed.Milliseconds())
 		var b bytes.Buffer
 		enc := json.NewEncoder(&b)
-		err := enc.Encode(sm)
+		err = enc.Encode(sm)
 		//_, err := sm.WriteTo(&b)
 		if err != nil {
 			return err
@@ -57,6 +146,13 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		// The job crawls a single URL of the sitemap, so depths are relative to it and it has no parent of its own
+		for i := range rc.Results {
+			rc.Results[i].Depth += depth
+			if rc.Results[i].Parent == "" {
+				rc.Results[i].Parent = parent
+			}
+		}
 
 		crawlID := uuid.MustParse(id)
 		err = ns.SendResultsMessage(crawlID, &rc.Results)
@@ -67,6 +163,20 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// clientConfig returns the HTTP client settings from the config file, if any, overridden by any client flags which
+// have been set on the command.
+func clientConfig(cmd *cobra.Command) (sitemap.ClientConfig, error) {
+	flags := cmd.Flags()
+	// The crawl manager passes credentials through the environment, from the sitemap's Secret
+	if !flags.Changed("basic-auth") {
+		clientFlags.BasicAuth = os.Getenv(sitemap.EnvBasicAuth)
+	}
+	if !flags.Changed("bearer-token") {
+		clientFlags.BearerToken = os.Getenv(sitemap.EnvBearerToken)
+	}
+	return clientFlags.Config(flags.Changed)
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
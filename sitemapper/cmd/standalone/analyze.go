@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dinofizz/sitemapper/sitemapper/internal"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var analyzeFormat string
+
+func init() {
+	analyzeCmd.Flags().StringVarP(&analyzeFormat, "output-format", "o", "table", "Specify output format: table, json, csv")
+	rootCmd.AddCommand(analyzeCmd)
+}
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze [file]",
+	Short: "Reports link graph statistics for a crawl saved as JSON by sm",
+	Long: `Reads a crawl saved as JSON or NDJSON by sm, from the file given or from stdin, and reports the link graph statistics of
+each page: the number of crawled pages linking to and linked from it, its minimum click depth from the start URL given
+by --site, its PageRank and its strongly connected component. Orphan pages with no links to them, dead-end pages with no
+links to other crawled pages, and pages linked to from only a single page are flagged.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch analyzeFormat {
+		case "table", "json", "csv":
+		default:
+			return errors.New("unsupported output format")
+		}
+
+		var r io.Reader = os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		results, err := sitemap.ReadResults(r)
+		if err != nil {
+			return err
+		}
+
+		stats := sitemap.Analyze(site, results)
+		switch analyzeFormat {
+		case "json":
+			return json.NewEncoder(os.Stdout).Encode(stats)
+		case "csv":
+			return sitemap.WriteGraphStatsCSV(os.Stdout, stats)
+		default:
+			return sitemap.WriteGraphStatsTable(os.Stdout, stats)
+		}
+	},
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dinofizz/sitemapper/sitemapper/internal"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var checkFormat string
+
+func init() {
+	checkCmd.Flags().StringVarP(&checkFormat, "output-format", "o", "table", "Specify output format: table, json, csv")
+	rootCmd.AddCommand(checkCmd)
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Crawls from a start URL and reports broken links, exiting with a non-zero status if any are found",
+	Long: `Crawls from a start URL and reports every link which resolved to a 4xx or 5xx response, or which could not be
+reached because of a DNS, connection, TLS or timeout failure, a redirect loop or too many redirects, along with the
+pages which link to it.
+The links found on every page up to the crawl depth are checked, so the crawl visits one level deeper than sm would.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch checkFormat {
+		case "table", "json", "csv":
+		default:
+			return errors.New("unsupported output format")
+		}
+
+		startUrl := site
+		sm := sitemap.NewSiteMap()
+		c, err := newCrawlEngine(cmd, sm, depth+1, startUrl)
+		if err != nil {
+			return err
+		}
+		crawl(c, depth+1)
+
+		broken := sm.BrokenLinks()
+		switch checkFormat {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(broken)
+		case "csv":
+			err = sitemap.WriteBrokenLinksCSV(os.Stdout, broken)
+		default:
+			err = sitemap.WriteBrokenLinksTable(os.Stdout, broken)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(broken) > 0 {
+			// The report has already been written, so only the summary is printed by main
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			return fmt.Errorf("found %d broken links", len(broken))
+		}
+		return nil
+	},
+}
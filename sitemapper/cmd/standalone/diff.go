@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dinofizz/sitemapper/sitemapper/internal"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var diffFormat string
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffFormat, "output-format", "o", "text", "Specify output format: text, json, markdown")
+	rootCmd.AddCommand(diffCmd)
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff old new",
+	Short: "Reports the changes between two crawls saved as JSON by sm",
+	Long: `Reads two crawls of a site saved as JSON or NDJSON by sm and reports what changed from the old crawl to the new
+one: the URLs which were added and removed, the URLs whose links to other pages changed, and the URLs whose status code
+or error class changed. Either file may be given as - to read it from stdin.`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{siteOptional: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch diffFormat {
+		case "text", "json", "markdown":
+		default:
+			return errors.New("unsupported output format")
+		}
+		if args[0] == "-" && args[1] == "-" {
+			return errors.New("only one crawl can be read from stdin")
+		}
+
+		older, err := readResultsFile(args[0])
+		if err != nil {
+			return err
+		}
+		newer, err := readResultsFile(args[1])
+		if err != nil {
+			return err
+		}
+
+		d := sitemap.DiffResults(older, newer)
+		switch diffFormat {
+		case "json":
+			return json.NewEncoder(os.Stdout).Encode(d)
+		case "markdown":
+			return sitemap.WriteCrawlDiffMarkdown(os.Stdout, d)
+		default:
+			return sitemap.WriteCrawlDiffText(os.Stdout, d)
+		}
+	},
+}
+
+// readResultsFile reads the crawl results saved in a file, or from stdin if the name is -.
+func readResultsFile(name string) ([]sitemap.Result, error) {
+	if name == "-" {
+		return sitemap.ReadResults(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sitemap.ReadResults(f)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dinofizz/sitemapper/sitemapper/internal"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var redirectsFormat string
+
+func init() {
+	redirectsCmd.Flags().StringVarP(&redirectsFormat, "output-format", "o", "table", "Specify output format: table, json, csv")
+	rootCmd.AddCommand(redirectsCmd)
+}
+
+var redirectsCmd = &cobra.Command{
+	Use:   "redirects",
+	Short: "Crawls from a start URL and reports the redirect chains found",
+	Long: `Crawls from a start URL and reports every URL which redirected, with each hop of its redirect chain, the final
+URL and the pages which link to it. Redirect loops, and chains of more than three hops or exceeding --max-redirects,
+are flagged. The links found on every page up to the crawl depth are checked, so the crawl visits one level deeper than
+sm would.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch redirectsFormat {
+		case "table", "json", "csv":
+		default:
+			return errors.New("unsupported output format")
+		}
+
+		startUrl := site
+		sm := sitemap.NewSiteMap()
+		c, err := newCrawlEngine(cmd, sm, depth+1, startUrl)
+		if err != nil {
+			return err
+		}
+		crawl(c, depth+1)
+
+		chains := sm.RedirectChains()
+		switch redirectsFormat {
+		case "json":
+			return json.NewEncoder(os.Stdout).Encode(chains)
+		case "csv":
+			return sitemap.WriteRedirectChainsCSV(os.Stdout, chains)
+		default:
+			return sitemap.WriteRedirectChainsTable(os.Stdout, chains)
+		}
+	},
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/dinofizz/sitemapper/sitemapper/internal"
 	"github.com/spf13/cobra"
 	"log"
+	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -16,55 +19,311 @@ var depth int
 var site string
 var mode string
 var limit int
+var respectRobots bool
+var outputFormat string
+var outputDir string
+var baseURL string
+var changeFreq string
+var priority float64
+var gzipOutput bool
+var clusterDepth int
+var sortOrder string
+var sitemapSeeds bool
+var rps float64
+var minDelay time.Duration
+var maxPerHost int
+var queryMode string
+var queryParams []string
+var maxParamValues int
+var include []string
+var exclude []string
+var hostMode string
+var hosts []string
+var external bool
+var timeout time.Duration
+var assets bool
+var respectNoFollow bool
+var trailingSlash string
+var keepFragments bool
+var clientFlags sitemap.ClientFlags
+var finalURLs bool
+var maxAttempts int
+var retryDelay time.Duration
+var retryMaxDelay time.Duration
+var requeue bool
 
 func init() {
-	rootCmd.Flags().IntVarP(&depth, "depth", "d", 1, "Specify crawl depth")
-	rootCmd.Flags().StringVarP(&site, "site", "s", "", "Site to crawl, including http scheme")
-	rootCmd.Flags().StringVarP(&mode, "mode", "m", "concurrent", "Specify mode: synchronous, concurrent, limited")
-	rootCmd.Flags().IntVarP(&limit, "limit", "l", 10, "Specify max concurrent crawl tasks for limited mode")
-	err := rootCmd.MarkFlagRequired("site")
-	if err != nil {
-		log.Fatalf(err.Error())
+	// Crawl flags are shared by the root command and its subcommands
+	rootCmd.PersistentFlags().IntVarP(&depth, "depth", "d", 1, "Specify crawl depth")
+	rootCmd.PersistentFlags().StringVarP(&site, "site", "s", "", "Site to crawl, including http scheme")
+	rootCmd.PersistentFlags().StringVarP(&mode, "mode", "m", "concurrent", "Specify mode: synchronous, concurrent, limited")
+	rootCmd.PersistentFlags().IntVarP(&limit, "limit", "l", 10, "Specify max concurrent crawl tasks for limited mode")
+	rootCmd.PersistentFlags().BoolVar(&respectRobots, "respect-robots", false, "Skip URLs disallowed by robots.txt, and wait for each host's Crawl-delay")
+	rootCmd.PersistentFlags().Float64Var(&rps, "rps", 0, "Maximum requests per second to each host (0 for no limit)")
+	rootCmd.PersistentFlags().DurationVar(&minDelay, "min-delay", 0, "Minimum delay between requests to the same host")
+	rootCmd.PersistentFlags().StringVar(&queryMode, "query", sitemap.QueryKeepAll, "Specify which query parameters are kept on links: all, keep, drop, none")
+	rootCmd.PersistentFlags().StringSliceVar(&queryParams, "query-params", nil, "Query parameters to keep or drop in the keep and drop query modes, e.g. page,id")
+	rootCmd.PersistentFlags().IntVar(&maxParamValues, "max-param-values", 0, "Maximum distinct values followed for each query parameter on a path (0 for no limit)")
+	rootCmd.PersistentFlags().StringArrayVar(&include, "include", nil, "Only crawl URLs matching this scope rule: a path glob, or regex:, prefix: or ext: rule (may be repeated)")
+	rootCmd.PersistentFlags().StringArrayVar(&exclude, "exclude", nil, "Do not crawl URLs matching this scope rule: a path glob, or regex:, prefix: or ext: rule (may be repeated)")
+	rootCmd.PersistentFlags().StringVar(&hostMode, "host-scope", sitemap.HostScopeExact, "Specify which hosts are crawled: host, domain (including all subdomains), hosts (the start host and --hosts)")
+	rootCmd.PersistentFlags().StringSliceVar(&hosts, "hosts", nil, "Additional hosts crawled in the hosts scope mode, e.g. blog.example.com,*.example.org")
+	rootCmd.PersistentFlags().BoolVar(&external, "external", false, "Record links to hosts outside the host scope, without crawling them")
+	rootCmd.PersistentFlags().IntVar(&maxPerHost, "max-per-host", sitemap.DefaultMaxInFlightPerHost, "Maximum concurrent requests to each host (0 for no limit)")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "Stop crawling after this duration and output the partial results (0 for no timeout)")
+	rootCmd.PersistentFlags().BoolVar(&respectNoFollow, "respect-nofollow", false, "Do not follow rel=nofollow links, or links on pages with a nofollow robots directive")
+	rootCmd.PersistentFlags().StringVar(&trailingSlash, "trailing-slash", sitemap.TrailingSlashKeep, "Specify trailing slash policy for URLs: keep, add, remove")
+	rootCmd.PersistentFlags().BoolVar(&keepFragments, "keep-fragments", false, "Treat URLs which differ only by #fragment as separate pages")
+	rootCmd.PersistentFlags().BoolVar(&assets, "assets", false, "Also record the images, scripts and stylesheets referenced by each page")
+	rootCmd.PersistentFlags().BoolVar(&sitemapSeeds, "sitemap-seeds", false, "Also crawl the URLs listed in the site's sitemap.xml files and report sitemap-only and link-only URLs")
+	rootCmd.PersistentFlags().StringVar(&clientFlags.ConfigFile, "config", "", "Read HTTP client settings from this JSON file, flags override the file")
+	rootCmd.PersistentFlags().StringVar(&clientFlags.UserAgent, "user-agent", sitemap.DefaultUserAgent, "User agent sent with each request and used to select robots.txt rules")
+	rootCmd.PersistentFlags().StringArrayVar(&clientFlags.Headers, "header", nil, "Extra request header in the form \"Name: value\" (may be repeated)")
+	rootCmd.PersistentFlags().StringArrayVar(&clientFlags.Cookies, "cookie", nil, "Cookie sent with each request in the form name=value (may be repeated)")
+	rootCmd.PersistentFlags().StringVar(&clientFlags.BasicAuth, "basic-auth", "", "Credentials for HTTP basic authentication in the form user:password")
+	rootCmd.PersistentFlags().StringVar(&clientFlags.BearerToken, "bearer-token", "", "Token sent in an Authorization: Bearer header")
+	rootCmd.PersistentFlags().StringSliceVar(&clientFlags.CredentialHosts, "credential-hosts", nil, "Hosts sent the headers, cookies and credentials, e.g. example.com,*.example.com (default is the --site host)")
+	rootCmd.PersistentFlags().StringVar(&clientFlags.Proxy, "proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL (default from the HTTP_PROXY and HTTPS_PROXY environment variables)")
+	rootCmd.PersistentFlags().StringVar(&clientFlags.CAFile, "ca-file", "", "PEM file of certificate authorities to trust in addition to the system roots")
+	rootCmd.PersistentFlags().BoolVar(&clientFlags.Insecure, "insecure", false, "Skip verification of server TLS certificates")
+	rootCmd.PersistentFlags().DurationVar(&clientFlags.Timeout, "request-timeout", sitemap.DefaultTimeout, "Time allowed for each request")
+	rootCmd.PersistentFlags().Int64Var(&clientFlags.MaxBodyBytes, "max-body-size", sitemap.DefaultMaxBodyBytes, "Maximum number of bytes read from each response")
+	rootCmd.PersistentFlags().StringSliceVar(&clientFlags.ContentTypes, "content-types", sitemap.DefaultContentTypes, "Media types of the pages downloaded and parsed for links, e.g. text/html,text/*")
+	rootCmd.PersistentFlags().BoolVar(&clientFlags.HeadCheck, "head-check", false, "Send a HEAD request before each page and skip pages with other content types without downloading them")
+	rootCmd.PersistentFlags().IntVar(&clientFlags.MaxRedirects, "max-redirects", sitemap.DefaultMaxRedirects, "Maximum redirects followed for each request, longer chains are reported as errors")
+	rootCmd.PersistentFlags().BoolVar(&finalURLs, "final-urls", false, "Record URLs which redirect within the crawl under the final URL of the redirect chain")
+	rootCmd.PersistentFlags().IntVar(&maxAttempts, "max-attempts", 1, "Maximum attempts for each URL failing with a timeout, connection error, 429 or 5xx response")
+	rootCmd.PersistentFlags().DurationVar(&retryDelay, "retry-delay", sitemap.DefaultRetryBaseDelay, "Delay before the first retry, doubling for each further retry")
+	rootCmd.PersistentFlags().DurationVar(&retryMaxDelay, "retry-max-delay", sitemap.DefaultRetryMaxDelay, "Maximum delay between retries, including delays requested by Retry-After")
+	rootCmd.PersistentFlags().BoolVar(&requeue, "requeue", false, "Crawl URLs which still fail after their retries once more at the end of the crawl")
+	rootCmd.Flags().StringVarP(&outputFormat, "output-format", "o", "json", "Specify output format: json, ndjson (one result per line, written during the crawl), xml, or a link graph format: dot, graphml, gexf, csv")
+	rootCmd.Flags().StringVar(&sortOrder, "sort", sitemap.SortByURL, "Specify the order of the JSON results: url, depth")
+	rootCmd.Flags().StringVar(&outputDir, "output-dir", "", "Write XML sitemap files to this directory instead of stdout, splitting into multiple files with a sitemap index if required")
+	rootCmd.Flags().StringVar(&baseURL, "sitemap-base-url", "", "Base URL used for sitemap locations in a sitemap index (default is the crawled site)")
+	rootCmd.Flags().StringVar(&changeFreq, "changefreq", "", "Specify changefreq for each XML sitemap entry: always, hourly, daily, weekly, monthly, yearly, never")
+	rootCmd.Flags().Float64Var(&priority, "priority", 0, "Specify priority (0.0 - 1.0) for each XML sitemap entry")
+	rootCmd.Flags().BoolVar(&gzipOutput, "gzip", false, "Compress XML sitemap output using gzip")
+	rootCmd.Flags().IntVar(&clusterDepth, "cluster-depth", 0, "Group the nodes of dot output into clusters by this many leading path segments (0 for no clusters)")
+}
+
+// siteOptional is the annotation given to commands which do not crawl, and so may be run without --site.
+const siteOptional = "site-optional"
+
+// requireSite checks that --site has been given to any command which crawls from it.
+func requireSite(cmd *cobra.Command, args []string) error {
+	if _, ok := cmd.Annotations[siteOptional]; ok || site != "" {
+		return nil
 	}
+	return errors.New(`required flag(s) "site" not set`)
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "sm",
-	Short: "Crawls from a start URL and writes a JSON based sitemap to stdout",
+	Short: "Crawls from a start URL and writes a JSON or XML based sitemap, or its link graph, to stdout",
+	// Commands which only read saved crawls may be annotated as not needing --site
+	PersistentPreRunE: requireSite,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		startUrl := strings.ToLower(site)
-		sm := sitemap.NewSiteMap()
-		var c sitemap.CrawlEngine = sitemap.NewConcurrentCrawlEngine(sm, depth, startUrl)
-		if mode != "" {
-			switch mode {
-			case "concurrent": // default mode if none specified
-			case "synchronous":
-				c = sitemap.NewSynchronousCrawlEngine(sm, depth, startUrl)
-			case "limited":
-				if limit <= 0 {
-					return errors.New("invalid limit")
-				}
-				l := sitemap.NewLimiter(limit)
-				c = sitemap.NewConcurrentLimitedCrawlEngine(sm, depth, startUrl, l)
-			default:
-				return errors.New("unsupported mode")
+		xe := sitemap.NewXMLEncoder()
+		xe.ChangeFreq = changeFreq
+		xe.Priority = priority
+		xe.Gzip = gzipOutput
+		ge := sitemap.NewGraphEncoder(outputFormat)
+		ge.ClusterDepth = clusterDepth
+		switch outputFormat {
+		case "json", "ndjson":
+		case "xml":
+			if err := xe.Validate(); err != nil {
+				return err
+			}
+		default:
+			if !sitemap.IsGraphFormat(outputFormat) {
+				return errors.New("unsupported output format")
+			}
+			if err := ge.Validate(); err != nil {
+				return err
 			}
 		}
-		log.Printf("Using mode: %s\n", mode)
 
-		log.Printf("Crawling %s with depth %d", site, depth)
-		start := time.Now()
-		c.Run()
-		end := time.Now()
-		elapsed := end.Sub(start)
-		log.Println("Elapsed milliseconds: ", elapsed.Milliseconds())
+		startUrl := site
+		sm := sitemap.NewSiteMap()
+		if err := sm.SetSortOrder(sortOrder); err != nil {
+			return err
+		}
+		var opts []sitemap.Option
+		if outputFormat == "ndjson" {
+			opts = append(opts, sitemap.WithResultStream(os.Stdout))
+		}
+		c, err := newCrawlEngine(cmd, sm, depth, startUrl, opts...)
+		if err != nil {
+			return err
+		}
+		crawl(c, depth)
 
+		if outputFormat == "ndjson" {
+			// Each result has already been written as it was crawled
+			return nil
+		}
+		if outputFormat == "xml" {
+			return writeXML(xe, sm, startUrl)
+		}
+		if sitemap.IsGraphFormat(outputFormat) {
+			return ge.Encode(os.Stdout, sm.Graph(startUrl))
+		}
 		enc := json.NewEncoder(os.Stdout)
-		err := enc.Encode(sm)
+		err = enc.Encode(sm)
 		return err
 	},
 }
 
+// clientConfig returns the HTTP client settings from the config file, if any, overridden by any client flags which
+// have been set on the command.
+func clientConfig(cmd *cobra.Command) (sitemap.ClientConfig, error) {
+	cfg, err := clientFlags.Config(cmd.Flags().Changed)
+	if err != nil {
+		return cfg, err
+	}
+	// Sitemap discovery uses the client before the crawl engine restricts it, so the default is set here
+	if u, err := url.Parse(site); err == nil && u.Host != "" && len(cfg.CredentialHosts) == 0 {
+		cfg.CredentialHosts = []string{u.Host}
+	}
+	return cfg, nil
+}
+
+// newCrawlEngine returns the crawl engine for the selected mode, configured using the crawl flags and any extra
+// options given.
+func newCrawlEngine(cmd *cobra.Command, sm *sitemap.SiteMap, maxDepth int, startUrl string, extra ...sitemap.Option) (sitemap.CrawlEngine, error) {
+	opts := extra
+	cfg, err := clientConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sitemap.NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, sitemap.WithHTTPClient(client))
+	n := sitemap.NewNormalizer()
+	switch trailingSlash {
+	case sitemap.TrailingSlashKeep, sitemap.TrailingSlashAdd, sitemap.TrailingSlashRemove:
+		n.TrailingSlash = trailingSlash
+	default:
+		return nil, errors.New("unsupported trailing slash policy")
+	}
+	n.KeepFragment = keepFragments
+	opts = append(opts, sitemap.WithNormalizer(n))
+	qp := sitemap.QueryPolicy{Mode: queryMode, Params: queryParams, MaxValues: maxParamValues}
+	if err := qp.Validate(); err != nil {
+		return nil, err
+	}
+	opts = append(opts, sitemap.WithQueryPolicy(qp))
+	scope, err := sitemap.NewScope(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, sitemap.WithScope(scope))
+	hs := sitemap.HostScope{Mode: hostMode, Hosts: hosts}
+	if err := hs.Validate(); err != nil {
+		return nil, err
+	}
+	opts = append(opts, sitemap.WithHostScope(hs))
+	rp := sitemap.RetryPolicy{MaxAttempts: maxAttempts, BaseDelay: retryDelay, MaxDelay: retryMaxDelay, Requeue: requeue}
+	if err := rp.Validate(); err != nil {
+		return nil, err
+	}
+	opts = append(opts, sitemap.WithRetryPolicy(rp))
+	if external {
+		opts = append(opts, sitemap.WithExternalLinks())
+	}
+	rc := sitemap.NewRobotsCheckerWithClient(client)
+	p := sitemap.Politeness{RequestsPerSecond: rps, MinDelay: minDelay, MaxInFlight: maxPerHost}
+	if respectRobots {
+		opts = append(opts, sitemap.WithRobots(rc), sitemap.WithHostLimiter(sitemap.NewHostLimiter(p, rc)))
+	} else {
+		opts = append(opts, sitemap.WithHostLimiter(sitemap.NewHostLimiter(p, nil)))
+	}
+	if respectNoFollow {
+		opts = append(opts, sitemap.WithNoFollow())
+	}
+	if finalURLs {
+		opts = append(opts, sitemap.WithFinalURLs())
+	}
+	if assets {
+		opts = append(opts, sitemap.WithLinkExtractors(&sitemap.HTMLExtractor{Assets: true, UserAgent: cfg.UserAgent}))
+	}
+	if sitemapSeeds {
+		seeds, err := sitemap.DiscoverSitemapURLs(cmd.Context(), startUrl, hs, rc)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Found %d URLs in sitemap files", len(seeds))
+		opts = append(opts, sitemap.WithSitemapSeeds(seeds))
+	}
+	var c sitemap.CrawlEngine = sitemap.NewConcurrentCrawlEngine(sm, maxDepth, startUrl, opts...)
+	if mode != "" {
+		switch mode {
+		case "concurrent": // default mode if none specified
+		case "synchronous":
+			c = sitemap.NewSynchronousCrawlEngine(sm, maxDepth, startUrl, opts...)
+		case "limited":
+			if limit <= 0 {
+				return nil, errors.New("invalid limit")
+			}
+			l := sitemap.NewLimiter(limit)
+			c = sitemap.NewConcurrentLimitedCrawlEngine(sm, maxDepth, startUrl, l, opts...)
+		default:
+			return nil, errors.New("unsupported mode")
+		}
+	}
+	log.Printf("Using mode: %s\n", mode)
+	return c, nil
+}
+
+// crawl runs the crawl engine until it completes, the timeout expires or the process is interrupted.
+func crawl(c sitemap.CrawlEngine, maxDepth int) {
+	// An interrupt stops the crawl, but the partial results are still written out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	log.Printf("Crawling %s with depth %d", site, maxDepth)
+	start := time.Now()
+	_, truncated := c.Run(ctx)
+	end := time.Now()
+	elapsed := end.Sub(start)
+	log.Println("Elapsed milliseconds: ", elapsed.Milliseconds())
+	if truncated {
+		log.Printf("Crawl stopped before completion: %v", ctx.Err())
+	}
+}
+
+// writeXML writes the sitemap as XML to stdout, or to one or more files if an output directory has been specified.
+func writeXML(xe *sitemap.XMLEncoder, sm *sitemap.SiteMap, startUrl string) error {
+	urls := sm.SitemapURLs()
+	if outputDir == "" {
+		err := xe.Encode(os.Stdout, urls)
+		if errors.Is(err, sitemap.ErrSitemapTooLarge) {
+			return fmt.Errorf("%w, use --output-dir to write multiple files", err)
+		}
+		return err
+	}
+
+	if baseURL == "" {
+		baseURL = startUrl
+	}
+	files, err := xe.WriteFiles(outputDir, baseURL, urls)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		log.Printf("Wrote %s", f)
+	}
+	return nil
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
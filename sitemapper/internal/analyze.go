@@ -0,0 +1,389 @@
+package sitemap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// PageRankDamping is the probability that a visitor follows a link rather than jumping to a random page when PageRank
+// is computed.
+const PageRankDamping = 0.85
+
+// pageRankIterations and pageRankTolerance bound the power iteration used to compute PageRank, which stops once the
+// total change in rank between iterations falls below the tolerance.
+const (
+	pageRankIterations = 100
+	pageRankTolerance  = 1e-9
+)
+
+// PageStats holds the link graph metrics of a crawled URL. InDegree and OutDegree count the distinct crawled pages
+// linking to and linked from the URL. Depth is the minimum number of clicks needed to reach the URL from the start URL,
+// or -1 if it cannot be reached by following links. Component identifies the strongly connected component the URL
+// belongs to. An Orphan has no links to it, a DeadEnd has no links to other crawled pages, and a SingleLink page is
+// linked to from only one other page.
+type PageStats struct {
+	URL        string
+	InDegree   int
+	OutDegree  int
+	Depth      int
+	PageRank   float64
+	Component  int
+	Orphan     bool `json:",omitempty"`
+	DeadEnd    bool `json:",omitempty"`
+	SingleLink bool `json:",omitempty"`
+}
+
+// GraphStats summarises the link graph of a crawl of Count pages, with the metrics of each page in Pages sorted by
+// URL. DepthCounts holds the number of pages at each click depth from the start URL, and Unreachable the number which
+// cannot be reached from it. Components lists the strongly connected components of more than one page, largest first,
+// where every page can be reached from every other by following links. Orphans, DeadEnds and SingleLink list the URLs
+// of the pages with each flag set, sorted by URL.
+type GraphStats struct {
+	StartURL       string
+	Count          int
+	Links          int
+	MaxDepth       int
+	DepthCounts    []int
+	Unreachable    int
+	ComponentCount int
+	Components     [][]string
+	Orphans        []string
+	DeadEnds       []string
+	SingleLink     []string
+	Pages          []PageStats
+}
+
+// Analyze computes the link graph metrics of a crawl. The graph holds a node for each crawled URL and an edge for each
+// link between two crawled URLs. Links to URLs which were not crawled, such as those beyond the crawl depth or outside
+// the crawl scope, and links from a page to itself, are ignored. The start URL is not reported as an orphan.
+func Analyze(startURL string, results []Result) GraphStats {
+	g := newLinkGraph(results)
+	start, ok := g.index[startURL]
+	if !ok {
+		start, ok = g.index[NewNormalizer().Normalize(startURL)]
+	}
+	if !ok {
+		start = -1
+	}
+
+	depths := g.depths(start)
+	ranks := g.pageRank()
+	components, count := g.components()
+
+	stats := GraphStats{
+		StartURL:       startURL,
+		Count:          len(g.urls),
+		DepthCounts:    make([]int, 0),
+		ComponentCount: count,
+		Components:     make([][]string, 0),
+		Orphans:        make([]string, 0),
+		DeadEnds:       make([]string, 0),
+		SingleLink:     make([]string, 0),
+		Pages:          make([]PageStats, len(g.urls)),
+	}
+	members := make([][]string, count)
+	for i, u := range g.urls {
+		p := PageStats{
+			URL:       u,
+			InDegree:  len(g.in[i]),
+			OutDegree: len(g.out[i]),
+			Depth:     depths[i],
+			PageRank:  ranks[i],
+			Component: components[i],
+		}
+		p.Orphan = p.InDegree == 0 && i != start
+		p.DeadEnd = p.OutDegree == 0
+		p.SingleLink = p.InDegree == 1
+		stats.Pages[i] = p
+		stats.Links += p.OutDegree
+		members[p.Component] = append(members[p.Component], u)
+
+		if p.Depth < 0 {
+			stats.Unreachable++
+		} else {
+			for len(stats.DepthCounts) <= p.Depth {
+				stats.DepthCounts = append(stats.DepthCounts, 0)
+			}
+			stats.DepthCounts[p.Depth]++
+			if p.Depth > stats.MaxDepth {
+				stats.MaxDepth = p.Depth
+			}
+		}
+		if p.Orphan {
+			stats.Orphans = append(stats.Orphans, u)
+		}
+		if p.DeadEnd {
+			stats.DeadEnds = append(stats.DeadEnds, u)
+		}
+		if p.SingleLink {
+			stats.SingleLink = append(stats.SingleLink, u)
+		}
+	}
+	for _, m := range members {
+		if len(m) > 1 {
+			stats.Components = append(stats.Components, m)
+		}
+	}
+	sort.SliceStable(stats.Components, func(i, j int) bool { return len(stats.Components[i]) > len(stats.Components[j]) })
+
+	return stats
+}
+
+// linkGraph is the adjacency list of the crawled URLs, which are numbered in order of URL. out and in hold the
+// distinct pages linked from and linking to each page, in ascending order.
+type linkGraph struct {
+	urls  []string
+	index map[string]int
+	out   [][]int
+	in    [][]int
+}
+
+// newLinkGraph builds the link graph of the crawled URLs in the results.
+func newLinkGraph(results []Result) *linkGraph {
+	g := &linkGraph{index: make(map[string]int, len(results))}
+	for _, r := range results {
+		if _, ok := g.index[r.URL]; !ok {
+			g.index[r.URL] = 0
+			g.urls = append(g.urls, r.URL)
+		}
+	}
+	sort.Strings(g.urls)
+	for i, u := range g.urls {
+		g.index[u] = i
+	}
+
+	edges := make([]map[int]struct{}, len(g.urls))
+	for _, r := range results {
+		from := g.index[r.URL]
+		for _, l := range r.Links {
+			to, ok := g.index[l]
+			if !ok || to == from {
+				continue
+			}
+			if edges[from] == nil {
+				edges[from] = map[int]struct{}{}
+			}
+			edges[from][to] = struct{}{}
+		}
+	}
+
+	g.out = make([][]int, len(g.urls))
+	g.in = make([][]int, len(g.urls))
+	for from, tos := range edges {
+		for to := range tos {
+			g.out[from] = append(g.out[from], to)
+		}
+		sort.Ints(g.out[from])
+		for _, to := range g.out[from] {
+			g.in[to] = append(g.in[to], from)
+		}
+	}
+	return g
+}
+
+// depths returns the minimum click depth of each page from the start page using a breadth first search, with -1 for
+// pages which cannot be reached. All pages are unreachable if start is negative.
+func (g *linkGraph) depths(start int) []int {
+	depths := make([]int, len(g.urls))
+	for i := range depths {
+		depths[i] = -1
+	}
+	if start < 0 {
+		return depths
+	}
+	depths[start] = 0
+	queue := []int{start}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, m := range g.out[n] {
+			if depths[m] < 0 {
+				depths[m] = depths[n] + 1
+				queue = append(queue, m)
+			}
+		}
+	}
+	return depths
+}
+
+// pageRank returns the PageRank of each page, computed by power iteration. The rank of dead end pages is shared
+// evenly between all pages, so that the ranks always sum to one.
+func (g *linkGraph) pageRank() []float64 {
+	n := len(g.urls)
+	if n == 0 {
+		return nil
+	}
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iter := 0; iter < pageRankIterations; iter++ {
+		var dangling float64
+		for i := range g.urls {
+			if len(g.out[i]) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-PageRankDamping)/float64(n) + PageRankDamping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i, tos := range g.out {
+			if len(tos) == 0 {
+				continue
+			}
+			share := PageRankDamping * rank[i] / float64(len(tos))
+			for _, to := range tos {
+				next[to] += share
+			}
+		}
+		var delta float64
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < pageRankTolerance {
+			break
+		}
+	}
+	return rank
+}
+
+// components returns the strongly connected component of each page along with the number of components, found using
+// Tarjan's algorithm. Components are numbered in the order their first page appears in the sorted URLs.
+func (g *linkGraph) components() ([]int, int) {
+	n := len(g.urls)
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	found := make([]int, n)
+	for i := range index {
+		index[i] = -1
+	}
+	var stack []int
+	var next, count int
+
+	var connect func(v int)
+	connect = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range g.out[v] {
+			if index[w] < 0 {
+				connect(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] == index[v] {
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				found[w] = count
+				if w == v {
+					break
+				}
+			}
+			count++
+		}
+	}
+	for v := 0; v < n; v++ {
+		if index[v] < 0 {
+			connect(v)
+		}
+	}
+
+	// Renumber the components so that the numbering depends only on the URLs and not on the order of the search
+	ids := make(map[int]int, count)
+	components := make([]int, n)
+	for v := 0; v < n; v++ {
+		id, ok := ids[found[v]]
+		if !ok {
+			id = len(ids)
+			ids[found[v]] = id
+		}
+		components[v] = id
+	}
+	return components, count
+}
+
+// flags returns the flags set for the page, separated by commas.
+func (p PageStats) flags() string {
+	var f []string
+	if p.Depth < 0 {
+		f = append(f, "unreachable")
+	}
+	if p.Orphan {
+		f = append(f, "orphan")
+	}
+	if p.DeadEnd {
+		f = append(f, "dead-end")
+	}
+	if p.SingleLink {
+		f = append(f, "single-link")
+	}
+	return strings.Join(f, ",")
+}
+
+// WriteGraphStatsTable writes a human-readable summary of the link graph followed by one row per page giving its
+// metrics and flags.
+func WriteGraphStatsTable(w io.Writer, stats GraphStats) error {
+	if _, err := fmt.Fprintf(w, "pages: %d, links: %d, max depth: %d, unreachable: %d, components: %d\n",
+		stats.Count, stats.Links, stats.MaxDepth, stats.Unreachable, stats.ComponentCount); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "orphans: %d, dead ends: %d, single link: %d\n\n",
+		len(stats.Orphans), len(stats.DeadEnds), len(stats.SingleLink)); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "URL\tIN\tOUT\tDEPTH\tPAGERANK\tCOMPONENT\tFLAGS"); err != nil {
+		return err
+	}
+	for _, p := range stats.Pages {
+		depth := strconv.Itoa(p.Depth)
+		if p.Depth < 0 {
+			depth = "-"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%.6f\t%d\t%s\n", p.URL, p.InDegree, p.OutDegree, depth, p.PageRank,
+			p.Component, p.flags()); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteGraphStatsCSV writes the metrics of each page as CSV with a header row. Pages which cannot be reached from the
+// start URL have an empty depth.
+func WriteGraphStatsCSV(w io.Writer, stats GraphStats) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"url", "in_degree", "out_degree", "depth", "pagerank", "component", "flags"}); err != nil {
+		return err
+	}
+	for _, p := range stats.Pages {
+		depth := ""
+		if p.Depth >= 0 {
+			depth = strconv.Itoa(p.Depth)
+		}
+		row := []string{p.URL, strconv.Itoa(p.InDegree), strconv.Itoa(p.OutDegree), depth,
+			strconv.FormatFloat(p.PageRank, 'f', -1, 64), strconv.Itoa(p.Component), p.flags()}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,137 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/matryer/is"
+	"math"
+	"strings"
+	"testing"
+)
+
+var testGraph = []Result{
+	{URL: "http://example.com/", Links: []string{"http://example.com/a", "http://example.com/b"}},
+	{URL: "http://example.com/a", Links: []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}},
+	{URL: "http://example.com/b", Links: []string{"http://example.com/a", "http://example.com/e", "http://example.com/uncrawled"}},
+	{URL: "http://example.com/c"},
+	{URL: "http://example.com/d", Links: []string{"http://example.com/c"}},
+	{URL: "http://example.com/e"},
+}
+
+func TestAnalyze(t *testing.T) {
+	is := is.New(t)
+	stats := Analyze("http://example.com/", testGraph)
+
+	is.Equal(stats.Count, 6)
+	is.Equal(stats.Links, 7)
+	is.Equal(stats.MaxDepth, 2)
+	is.Equal(stats.DepthCounts, []int{1, 2, 2})
+	is.Equal(stats.Unreachable, 1)
+	is.Equal(stats.ComponentCount, 5)
+	is.Equal(stats.Components, [][]string{{"http://example.com/a", "http://example.com/b"}})
+	is.Equal(stats.Orphans, []string{"http://example.com/d"})
+	is.Equal(stats.DeadEnds, []string{"http://example.com/c", "http://example.com/e"})
+	is.Equal(stats.SingleLink, []string{"http://example.com/e"})
+
+	data := []struct {
+		url     string
+		in, out int
+		depth   int
+		deadEnd bool
+		orphan  bool
+		flags   string
+	}{
+		{"http://example.com/", 0, 2, 0, false, false, ""},
+		{"http://example.com/a", 2, 2, 1, false, false, ""},
+		{"http://example.com/b", 2, 2, 1, false, false, ""},
+		{"http://example.com/c", 2, 0, 2, true, false, "dead-end"},
+		{"http://example.com/d", 0, 1, -1, false, true, "unreachable,orphan"},
+		{"http://example.com/e", 1, 0, 2, true, false, "dead-end,single-link"},
+	}
+	var total float64
+	for i, d := range data {
+		p := stats.Pages[i]
+		is.Equal(p.URL, d.url)
+		is.Equal(p.InDegree, d.in)
+		is.Equal(p.OutDegree, d.out)
+		is.Equal(p.Depth, d.depth)
+		is.Equal(p.DeadEnd, d.deadEnd)
+		is.Equal(p.Orphan, d.orphan)
+		is.Equal(p.flags(), d.flags)
+		total += p.PageRank
+	}
+	is.True(math.Abs(total-1) < 1e-6)
+	// Pages in the same component share a component number, and c is linked to by more highly ranked pages than d
+	is.Equal(stats.Pages[1].Component, stats.Pages[2].Component)
+	is.True(stats.Pages[0].Component != stats.Pages[1].Component)
+	is.True(stats.Pages[3].PageRank > stats.Pages[4].PageRank)
+}
+
+func TestAnalyze_PageRank(t *testing.T) {
+	is := is.New(t)
+	// In a cycle every page has the same rank
+	stats := Analyze("http://example.com/", []Result{
+		{URL: "http://example.com/", Links: []string{"http://example.com/a"}},
+		{URL: "http://example.com/a", Links: []string{"http://example.com/b"}},
+		{URL: "http://example.com/b", Links: []string{"http://example.com/"}},
+	})
+	for _, p := range stats.Pages {
+		is.True(math.Abs(p.PageRank-1.0/3) < 1e-6)
+	}
+	is.Equal(stats.ComponentCount, 1)
+	is.Equal(len(stats.Components[0]), 3)
+}
+
+func TestAnalyze_UnknownStart(t *testing.T) {
+	is := is.New(t)
+	stats := Analyze("http://other.com", testGraph)
+	is.Equal(stats.Unreachable, 6)
+	is.Equal(stats.DepthCounts, []int{})
+
+	stats = Analyze("http://example.com/", nil)
+	is.Equal(stats.Count, 0)
+	is.Equal(len(stats.Pages), 0)
+}
+
+func TestReadResults(t *testing.T) {
+	is := is.New(t)
+	sm := NewSiteMap()
+	for _, r := range testGraph {
+		sm.AddURL(r.URL)
+		sm.UpdateURLWithLinks(r.URL, r.Links)
+	}
+	sm.SetStatus("http://example.com/c", URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+	b, err := json.Marshal(sm)
+	is.NoErr(err)
+
+	results, err := ReadResults(bytes.NewReader(b))
+	is.NoErr(err)
+	is.Equal(len(results), len(testGraph))
+	is.Equal(Analyze("http://example.com/", results), Analyze("http://example.com/", testGraph))
+	for _, r := range results {
+		if r.URL == "http://example.com/c" {
+			is.Equal(r.StatusCode, 404)
+		}
+	}
+
+	_, err = ReadResults(strings.NewReader("not json"))
+	is.True(err != nil)
+}
+
+func TestWriteGraphStats(t *testing.T) {
+	is := is.New(t)
+	stats := Analyze("http://example.com/", testGraph)
+
+	var b bytes.Buffer
+	is.NoErr(WriteGraphStatsCSV(&b, stats))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	is.Equal(len(lines), 7)
+	is.Equal(lines[0], "url,in_degree,out_degree,depth,pagerank,component,flags")
+	is.True(strings.HasPrefix(lines[5], "http://example.com/d,0,1,,"))
+	is.True(strings.HasSuffix(lines[5], `,"unreachable,orphan"`))
+
+	b.Reset()
+	is.NoErr(WriteGraphStatsTable(&b, stats))
+	is.True(strings.HasPrefix(b.String(), "pages: 6, links: 7, max depth: 2, unreachable: 1, components: 5\n"))
+	is.True(strings.Contains(b.String(), "orphans: 1, dead ends: 2, single link: 1\n"))
+}
@@ -0,0 +1,136 @@
+package sitemap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// A BrokenLink is a crawled URL which could not be retrieved, along with the pages which link to it.
+type BrokenLink struct {
+	URL        string
+	StatusCode int `json:",omitempty"`
+	ErrorClass string
+	Sources    []string
+}
+
+// Broken returns true if the status represents a broken link: a 4xx or 5xx response, or a URL which could not be
+// reached because of a DNS, connection, TLS or timeout failure, a redirect loop or too many redirects.
+func (s URLStatus) Broken() bool {
+	if s.StatusCode >= 400 {
+		return true
+	}
+	switch s.ErrorClass {
+	case ErrorClassDNS, ErrorClassConnection, ErrorClassTLS, ErrorClassTimeout, ErrorClassRedirectLoop,
+		ErrorClassTooManyRedirects:
+		return true
+	}
+	return false
+}
+
+// BrokenLinks returns the broken links found during the crawl, sorted by URL.
+func (sm *SiteMap) BrokenLinks() []BrokenLink {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	results := make([]Result, 0, len(sm.lm))
+	for u, ls := range sm.lm {
+		r := Result{URL: u, URLStatus: sm.status[u]}
+		for l := range ls {
+			r.Links = append(r.Links, l)
+		}
+		results = append(results, r)
+	}
+
+	return FindBrokenLinks(results)
+}
+
+// FindBrokenLinks returns the broken links in a set of crawl results, sorted by URL. The sources of each broken link
+// are the crawled URLs which link to it, also sorted by URL.
+func FindBrokenLinks(results []Result) []BrokenLink {
+	sources := map[string][]string{}
+	for _, r := range results {
+		for _, l := range r.Links {
+			sources[l] = append(sources[l], r.URL)
+		}
+	}
+
+	broken := make([]BrokenLink, 0)
+	for _, r := range results {
+		if !r.Broken() {
+			continue
+		}
+		s := sources[r.URL]
+		if s == nil {
+			s = make([]string, 0)
+		}
+		sort.Strings(s)
+		broken = append(broken, BrokenLink{URL: r.URL, StatusCode: r.StatusCode, ErrorClass: r.ErrorClass, Sources: s})
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].URL < broken[j].URL })
+
+	return broken
+}
+
+// status returns the HTTP status code of the broken link, or its error class if no response was received.
+func (b BrokenLink) status() string {
+	if b.StatusCode != 0 {
+		return strconv.Itoa(b.StatusCode)
+	}
+	return b.ErrorClass
+}
+
+// WriteBrokenLinksTable writes the broken links as a human-readable table, with one row per source page.
+func WriteBrokenLinksTable(w io.Writer, broken []BrokenLink) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "URL\tSTATUS\tSOURCE"); err != nil {
+		return err
+	}
+	for _, b := range broken {
+		if len(b.Sources) == 0 {
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t\n", b.URL, b.status()); err != nil {
+				return err
+			}
+			continue
+		}
+		for i, s := range b.Sources {
+			u, status := b.URL, b.status()
+			// Only the first row for each broken link repeats the URL and status, to keep the table readable
+			if i > 0 {
+				u, status = "", ""
+			}
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", u, status, s); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteBrokenLinksCSV writes the broken links as CSV with a header row, and one row per source page.
+func WriteBrokenLinksCSV(w io.Writer, broken []BrokenLink) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"url", "status_code", "error_class", "source"}); err != nil {
+		return err
+	}
+	for _, b := range broken {
+		code := ""
+		if b.StatusCode != 0 {
+			code = strconv.Itoa(b.StatusCode)
+		}
+		sources := b.Sources
+		if len(sources) == 0 {
+			sources = []string{""}
+		}
+		for _, s := range sources {
+			if err := cw.Write([]string{b.URL, code, b.ErrorClass, s}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package sitemap
+
+import (
+	"bytes"
+	"github.com/matryer/is"
+	"testing"
+)
+
+func testBrokenSiteMap() *SiteMap {
+	sm := NewSiteMap()
+	sm.AddURL("https://example.com/")
+	sm.UpdateURLWithLinks("https://example.com/", []string{"https://example.com/about.html", "https://example.com/missing.html"})
+	sm.SetStatus("https://example.com/", URLStatus{StatusCode: 200})
+	sm.AddURL("https://example.com/about.html")
+	sm.UpdateURLWithLinks("https://example.com/about.html", []string{"https://example.com/missing.html", "https://example.com/slow.html"})
+	sm.SetStatus("https://example.com/about.html", URLStatus{StatusCode: 200})
+	sm.AddURL("https://example.com/missing.html")
+	sm.SetStatus("https://example.com/missing.html", URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+	sm.AddURL("https://example.com/slow.html")
+	sm.SetStatus("https://example.com/slow.html", URLStatus{ErrorClass: ErrorClassTimeout})
+	return sm
+}
+
+func TestURLStatus_Broken(t *testing.T) {
+	data := []struct {
+		name   string
+		status URLStatus
+		broken bool
+	}{
+		{"ok", URLStatus{StatusCode: 200}, false},
+		{"not modified", URLStatus{StatusCode: 304, ErrorClass: ErrorClassHTTPStatus}, false},
+		{"not found", URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus}, true},
+		{"server error", URLStatus{StatusCode: 503, ErrorClass: ErrorClassHTTPStatus}, true},
+		{"dns", URLStatus{ErrorClass: ErrorClassDNS}, true},
+		{"timeout", URLStatus{ErrorClass: ErrorClassTimeout}, true},
+		{"parse error", URLStatus{StatusCode: 200, ErrorClass: ErrorClassParse}, false},
+		{"cancelled", URLStatus{ErrorClass: ErrorClassCancelled}, false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(d.status.Broken(), d.broken)
+		})
+	}
+}
+
+func TestSiteMap_BrokenLinks(t *testing.T) {
+	is := is.New(t)
+	broken := testBrokenSiteMap().BrokenLinks()
+	is.Equal(broken, []BrokenLink{
+		{
+			URL:        "https://example.com/missing.html",
+			StatusCode: 404,
+			ErrorClass: ErrorClassHTTPStatus,
+			Sources:    []string{"https://example.com/", "https://example.com/about.html"},
+		},
+		{
+			URL:        "https://example.com/slow.html",
+			ErrorClass: ErrorClassTimeout,
+			Sources:    []string{"https://example.com/about.html"},
+		},
+	})
+}
+
+func TestWriteBrokenLinks(t *testing.T) {
+	broken := testBrokenSiteMap().BrokenLinks()
+
+	data := []struct {
+		name     string
+		write    func(b *bytes.Buffer) error
+		expected string
+	}{
+		{"table", func(b *bytes.Buffer) error { return WriteBrokenLinksTable(b, broken) },
+			"URL                               STATUS   SOURCE\n" +
+				"https://example.com/missing.html  404      https://example.com/\n" +
+				"                                           https://example.com/about.html\n" +
+				"https://example.com/slow.html     timeout  https://example.com/about.html\n"},
+		{"csv", func(b *bytes.Buffer) error { return WriteBrokenLinksCSV(b, broken) },
+			"url,status_code,error_class,source\n" +
+				"https://example.com/missing.html,404,http-status,https://example.com/\n" +
+				"https://example.com/missing.html,404,http-status,https://example.com/about.html\n" +
+				"https://example.com/slow.html,,timeout,https://example.com/about.html\n"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			var b bytes.Buffer
+			is.NoErr(d.write(&b))
+			is.Equal(b.String(), d.expected)
+		})
+	}
+}
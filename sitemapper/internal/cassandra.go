@@ -1,6 +1,7 @@
 package sitemap
 
 import (
+	"encoding/json"
 	"github.com/NathanBak/easy-cass-go/pkg/easycass"
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
@@ -10,7 +11,8 @@ import (
 )
 
 type AstraDB struct {
-	session *gocql.Session
+	session    *gocql.Session
+	normalizer *Normalizer
 }
 
 func getAstraDBCreds() (string, string, string) {
@@ -38,7 +40,7 @@ func NewAstraDB() *AstraDB {
 		log.Fatal(err)
 	}
 	log.Println(easycass.GetKeyspace(session))
-	return &AstraDB{session: session}
+	return &AstraDB{session: session, normalizer: NewNormalizer()}
 }
 
 func (c *AstraDB) HealthCheck() error {
@@ -99,7 +101,7 @@ func (c *AstraDB) UpdateStatus(crawlID, sitemapID uuid.UUID, status string) erro
 	return nil
 }
 
-func (c *AstraDB) WriteSitemap(sitemapID string, url string, maxDepth int) error {
+func (c *AstraDB) WriteSitemap(sitemapID string, url string, maxDepth int, opts CrawlOptions) error {
 	smUUID, err := gocql.ParseUUID(sitemapID)
 	if err != nil {
 		return err
@@ -114,8 +116,18 @@ func (c *AstraDB) WriteSitemap(sitemapID string, url string, maxDepth int) error
 		return errors.Errorf("Sitemap ID %s already exists", smUUID)
 	}
 
-	if err = c.session.Query(`INSERT INTO sitemaps (sitemap_id, url, max_depth) VALUES (?, ?, ?)`,
-		smUUID, url, maxDepth).Exec(); err != nil {
+	// Credentials are never stored, the crawl jobs read them from the Secret named in the options
+	if opts.Client != nil {
+		cfg := opts.Client.WithoutCredentials()
+		opts.Client = &cfg
+	}
+	o, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	if err = c.session.Query(`INSERT INTO sitemaps (sitemap_id, url, max_depth, options) VALUES (?, ?, ?, ?)`,
+		smUUID, url, maxDepth, string(o)).Exec(); err != nil {
 		return errors.Wrap(err, "Unable to write sitemap to DB")
 	}
 	return nil
@@ -163,6 +175,30 @@ func (c *AstraDB) GetMaxDepthForSitemapID(sitemapID uuid.UUID) (int, error) {
 	return maxDepth, nil
 }
 
+func (c *AstraDB) GetCrawlOptionsForSitemapID(sitemapID uuid.UUID) (*CrawlOptions, error) {
+	smUUID, err := gocql.ParseUUID(sitemapID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var o string
+	err = c.session.Query("SELECT options FROM sitemaps WHERE sitemap_id = ?", smUUID).Scan(&o)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error checking options for sitemap ID %s", sitemapID.String())
+	}
+
+	var opts CrawlOptions
+	// Sitemaps created before crawl options were introduced have no options stored
+	if o == "" {
+		return &opts, nil
+	}
+	if err = json.Unmarshal([]byte(o), &opts); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing options for sitemap ID %s", sitemapID.String())
+	}
+
+	return &opts, nil
+}
+
 func (c *AstraDB) URLExistsForSitemapID(sitemapID uuid.UUID, URL string) (bool, error) {
 	smUUID, err := gocql.ParseUUID(sitemapID.String())
 	if err != nil {
@@ -170,7 +206,8 @@ func (c *AstraDB) URLExistsForSitemapID(sitemapID uuid.UUID, URL string) (bool,
 	}
 	var count int
 
-	err = c.session.Query("SELECT COUNT(*) FROM results_by_sitemap_id WHERE sitemap_id = ? AND url = ?", smUUID, URL).Scan(&count)
+	// Results are recorded using normalized URLs, so equivalent forms of a URL are found
+	err = c.session.Query("SELECT COUNT(*) FROM results_by_sitemap_id WHERE sitemap_id = ? AND url = ?", smUUID, c.normalizer.Normalize(URL)).Scan(&count)
 	if err != nil {
 		return false, errors.Wrap(err, "Error checking if URL exists for sitemap ID")
 	}
@@ -181,7 +218,7 @@ func (c *AstraDB) URLExistsForSitemapID(sitemapID uuid.UUID, URL string) (bool,
 	return true, nil
 }
 
-func (c *AstraDB) WriteResults(sitemapID, crawlID uuid.UUID, URL string, links []string) error {
+func (c *AstraDB) WriteResults(sitemapID, crawlID uuid.UUID, r Result) error {
 	smUUID, err := gocql.ParseUUID(sitemapID.String())
 	if err != nil {
 		return err
@@ -192,8 +229,18 @@ func (c *AstraDB) WriteResults(sitemapID, crawlID uuid.UUID, URL string, links [
 		return err
 	}
 
-	if err = c.session.Query(`INSERT into results_by_sitemap_id ( sitemap_id, url, crawl_id, links) values (?, ?, ?, ?)`,
-		smUUID, URL, cUUID, links).Exec(); err != nil {
+	// The redirect chain is stored as JSON text, which is left empty when the URL was not redirected
+	var redirects string
+	if len(r.Redirects) > 0 {
+		b, err := json.Marshal(r.Redirects)
+		if err != nil {
+			return err
+		}
+		redirects = string(b)
+	}
+
+	if err = c.session.Query(`INSERT into results_by_sitemap_id ( sitemap_id, url, crawl_id, links, external_links, status_code, final_url, content_type, duration_ms, error_class, attempts, redirects, truncated, depth, parent, robots, canonical, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		smUUID, r.URL, cUUID, r.Links, r.External, r.StatusCode, r.FinalURL, r.ContentType, r.DurationMs, r.ErrorClass, r.Attempts, redirects, r.Truncated, r.Depth, r.Parent, r.Robots, r.Canonical, r.LastModified).Exec(); err != nil {
 		return errors.Wrap(err, "Unable to write results to DB")
 	}
 	return nil
@@ -227,19 +274,26 @@ func (c *AstraDB) GetSitemapResults(sitemapID uuid.UUID) (*[]Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	scanner := c.session.Query("SELECT url, links FROM results_by_sitemap_id WHERE sitemap_id = ?", smUUID).Iter().Scanner()
+	scanner := c.session.Query("SELECT url, links, external_links, status_code, final_url, content_type, duration_ms, error_class, attempts, redirects, truncated, depth, parent, robots, canonical, last_modified FROM results_by_sitemap_id WHERE sitemap_id = ?", smUUID).Iter().Scanner()
 
 	var results []Result
 
 	for scanner.Next() {
-		var URL string
-		var URLlinks []string
+		var r Result
+		var redirects string
 
-		err = scanner.Scan(&URL, &URLlinks)
+		// Results written before per-URL status, external links, attempts, redirects, truncation, depth, parent, robots
+		// directives, canonical URLs and Last-Modified times were recorded have null columns, which scan as zero values
+		err = scanner.Scan(&r.URL, &r.Links, &r.External, &r.StatusCode, &r.FinalURL, &r.ContentType, &r.DurationMs, &r.ErrorClass, &r.Attempts, &redirects, &r.Truncated, &r.Depth, &r.Parent, &r.Robots, &r.Canonical, &r.LastModified)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, Result{URL: URL, Links: URLlinks})
+		if redirects != "" {
+			if err = json.Unmarshal([]byte(redirects), &r.Redirects); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, r)
 	}
 
 	if err = scanner.Err(); err != nil {
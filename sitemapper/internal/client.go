@@ -0,0 +1,430 @@
+package sitemap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the time allowed for each request, including reading the response body, when no timeout is
+// configured.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultMaxBodyBytes is the maximum number of bytes read from each response body when no limit is configured.
+const DefaultMaxBodyBytes = 10 * 1024 * 1024
+
+// DefaultContentTypes are the media types of the pages which are downloaded and parsed for links when no content types
+// are configured.
+var DefaultContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// DefaultMaxRedirects is the number of redirects followed for each request when no limit is configured.
+const DefaultMaxRedirects = 10
+
+// A ClientConfig configures the HTTP client used to fetch pages, robots.txt and sitemap files.
+//
+// Headers are extra request headers in the form "Name: value", and Cookies are sent in the form "name=value".
+// BasicAuth holds a "user:password" pair for HTTP basic authentication, and BearerToken a token sent in an
+// "Authorization: Bearer" header; only one of them may be set. Proxy is the URL of an http, https or socks5 proxy, and
+// if it is empty the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables. CAFile is a
+// PEM bundle of certificate authorities trusted in addition to the system roots, and Insecure disables verification of
+// server certificates. A zero Timeout or MaxBodyBytes uses DefaultTimeout or DefaultMaxBodyBytes.
+//
+// ContentTypes lists the media types of the pages which are downloaded and parsed, such as "text/html" or "text/*",
+// and defaults to DefaultContentTypes. The bodies of other responses are not read. When a response has no Content-Type
+// header its type is sniffed from the start of the body. With HeadCheck a HEAD request is sent before each page is
+// fetched, so that pages of other types are not requested at all.
+//
+// MaxRedirects is the number of redirects followed for each request, and defaults to DefaultMaxRedirects. Requests
+// which are redirected more times, or which are redirected back to a URL already requested, fail.
+//
+// CredentialHosts lists the hosts, such as "example.com" or "*.example.com", to which the Headers, Cookies and
+// authentication are sent. If it is empty they are sent only to the host of the crawl's start URL once the client is
+// given to a crawl engine, so that they never reach third-party hosts serving robots.txt, sitemap files or redirects.
+type ClientConfig struct {
+	UserAgent       string        `json:",omitempty"`
+	Headers         []string      `json:",omitempty"`
+	Cookies         []string      `json:",omitempty"`
+	BasicAuth       string        `json:",omitempty"`
+	BearerToken     string        `json:",omitempty"`
+	Proxy           string        `json:",omitempty"`
+	CAFile          string        `json:",omitempty"`
+	Insecure        bool          `json:",omitempty"`
+	Timeout         time.Duration `json:",omitempty"`
+	MaxBodyBytes    int64         `json:",omitempty"`
+	ContentTypes    []string      `json:",omitempty"`
+	HeadCheck       bool          `json:",omitempty"`
+	MaxRedirects    int           `json:",omitempty"`
+	CredentialHosts []string      `json:",omitempty"`
+}
+
+// WithoutCredentials returns a copy of the settings with the basic auth and bearer token credentials removed, so that
+// the settings can be stored or reported without revealing them.
+func (c ClientConfig) WithoutCredentials() ClientConfig {
+	c.BasicAuth = ""
+	c.BearerToken = ""
+	return c
+}
+
+// HasCredentials returns true if basic auth or bearer token credentials are set.
+func (c ClientConfig) HasCredentials() bool {
+	return c.BasicAuth != "" || c.BearerToken != ""
+}
+
+// LoadClientConfig reads a ClientConfig from a JSON file. The Timeout may be given as a duration string such as "10s".
+func LoadClientConfig(path string) (*ClientConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg ClientConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing client config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// ClientFlags holds the command line settings of the HTTP client, which are combined with any ClientConfig read from
+// ConfigFile by Config.
+type ClientFlags struct {
+	ConfigFile      string
+	UserAgent       string
+	Headers         []string
+	Cookies         []string
+	BasicAuth       string
+	BearerToken     string
+	CredentialHosts []string
+	Proxy           string
+	CAFile          string
+	Insecure        bool
+	Timeout         time.Duration
+	MaxBodyBytes    int64
+	ContentTypes    []string
+	HeadCheck       bool
+	MaxRedirects    int
+}
+
+// Config returns the ClientConfig read from the ConfigFile, if any, with the settings overridden by the flags which
+// changed reports as set on the command line. Flags with default values fill in the settings missing from the file,
+// and the Headers and Cookies are added to those in the file. The BasicAuth and BearerToken override the file when
+// they are not empty, so that they may also be taken from the environment. An error is returned if the file cannot be
+// read or the resulting settings are invalid.
+func (f ClientFlags) Config(changed func(name string) bool) (ClientConfig, error) {
+	cfg := ClientConfig{}
+	if f.ConfigFile != "" {
+		c, err := LoadClientConfig(f.ConfigFile)
+		if err != nil {
+			return cfg, err
+		}
+		cfg = *c
+	}
+	if changed("user-agent") || cfg.UserAgent == "" {
+		cfg.UserAgent = f.UserAgent
+	}
+	cfg.Headers = append(cfg.Headers, f.Headers...)
+	cfg.Cookies = append(cfg.Cookies, f.Cookies...)
+	if f.BasicAuth != "" {
+		cfg.BasicAuth = f.BasicAuth
+	}
+	if f.BearerToken != "" {
+		cfg.BearerToken = f.BearerToken
+	}
+	if changed("credential-hosts") {
+		cfg.CredentialHosts = f.CredentialHosts
+	}
+	if changed("proxy") {
+		cfg.Proxy = f.Proxy
+	}
+	if changed("ca-file") {
+		cfg.CAFile = f.CAFile
+	}
+	if changed("insecure") {
+		cfg.Insecure = f.Insecure
+	}
+	if changed("request-timeout") || cfg.Timeout == 0 {
+		cfg.Timeout = f.Timeout
+	}
+	if changed("max-body-size") || cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = f.MaxBodyBytes
+	}
+	if changed("content-types") || len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = f.ContentTypes
+	}
+	if changed("head-check") {
+		cfg.HeadCheck = f.HeadCheck
+	}
+	if changed("max-redirects") || cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = f.MaxRedirects
+	}
+	return cfg, cfg.Validate()
+}
+
+// UnmarshalJSON is provided so that the Timeout may be given either as a duration string or as nanoseconds.
+func (c *ClientConfig) UnmarshalJSON(b []byte) error {
+	type config ClientConfig
+	aux := struct {
+		*config
+		Timeout json.RawMessage `json:",omitempty"`
+	}{config: (*config)(c)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if err := unmarshalDuration(aux.Timeout, &c.Timeout); err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	return nil
+}
+
+// unmarshalDuration parses a JSON duration given either as a duration string such as "10s" or as nanoseconds. An empty
+// or null value leaves the duration unchanged.
+func unmarshalDuration(raw json.RawMessage, d *time.Duration) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = v
+		return nil
+	}
+	var ns int64
+	if err := json.Unmarshal(raw, &ns); err != nil {
+		return err
+	}
+	*d = time.Duration(ns)
+	return nil
+}
+
+// Validate returns an error if any of the settings are malformed.
+func (c ClientConfig) Validate() error {
+	for _, h := range c.Headers {
+		if i := strings.Index(h, ":"); i <= 0 || strings.TrimSpace(h[:i]) == "" {
+			return fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+	}
+	for _, ck := range c.Cookies {
+		if i := strings.Index(ck, "="); i <= 0 {
+			return fmt.Errorf("invalid cookie %q, expected \"name=value\"", ck)
+		}
+	}
+	if c.BasicAuth != "" && !strings.Contains(c.BasicAuth, ":") {
+		return errors.New("basic auth must be given as user:password")
+	}
+	if c.BasicAuth != "" && c.BearerToken != "" {
+		return errors.New("only one of basic auth and bearer token may be set")
+	}
+	if c.Proxy != "" {
+		pu, err := url.Parse(c.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch pu.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return errors.New("proxy scheme must be one of http, https, socks5")
+		}
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		return errors.New("max body size must not be negative")
+	}
+	if c.MaxRedirects < 0 {
+		return errors.New("max redirects must not be negative")
+	}
+	for _, ct := range c.ContentTypes {
+		if i := strings.Index(ct, "/"); i <= 0 || i == len(ct)-1 {
+			return fmt.Errorf("invalid content type %q, expected a media type such as text/html", ct)
+		}
+	}
+	return nil
+}
+
+// An HTTPClient sends requests using the settings of a ClientConfig. The user agent is added to every request, and the
+// headers, cookies and authentication to requests to the credential hosts. An HTTPClient is safe for concurrent use,
+// and a single HTTPClient is shared by the crawl engine, the RobotsChecker and sitemap discovery so that connections
+// are reused.
+type HTTPClient struct {
+	client          *http.Client
+	config          ClientConfig
+	headers         http.Header
+	credentials     http.Header
+	credentialHosts []string
+}
+
+// NewHTTPClient returns an HTTPClient for the configuration. An error is returned if the configuration is invalid or
+// the CA file cannot be read.
+func NewHTTPClient(cfg ClientConfig) (*HTTPClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultContentTypes
+	}
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = DefaultMaxRedirects
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Proxy != "" {
+		pu, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(pu)
+	}
+	if cfg.CAFile != "" || cfg.Insecure {
+		tc := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+		if cfg.CAFile != "" {
+			pem, err := ioutil.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+			}
+			tc.RootCAs = pool
+		}
+		transport.TLSClientConfig = tc
+	}
+
+	credentials := http.Header{}
+	for _, h := range cfg.Headers {
+		i := strings.Index(h, ":")
+		credentials.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	if len(cfg.Cookies) > 0 {
+		credentials.Set("Cookie", strings.Join(cfg.Cookies, "; "))
+	}
+	switch {
+	case cfg.BasicAuth != "":
+		credentials.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(cfg.BasicAuth)))
+	case cfg.BearerToken != "":
+		credentials.Set("Authorization", "Bearer "+cfg.BearerToken)
+	}
+	headers := http.Header{}
+	headers.Set("User-Agent", cfg.UserAgent)
+
+	h := &HTTPClient{
+		client:      &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		config:      cfg,
+		headers:     headers,
+		credentials: credentials,
+	}
+	for _, ch := range cfg.CredentialHosts {
+		h.credentialHosts = append(h.credentialHosts, strings.ToLower(strings.TrimSpace(ch)))
+	}
+	h.client.CheckRedirect = h.checkRedirect
+	return h, nil
+}
+
+// defaultHTTPClient returns an HTTPClient with the default settings and the given user agent.
+func defaultHTTPClient(userAgent string) *HTTPClient {
+	// The default configuration is always valid
+	h, _ := NewHTTPClient(ClientConfig{UserAgent: userAgent})
+	return h
+}
+
+// Do sends the request after adding the configured headers, replacing any headers of the same name. The headers,
+// cookies and authentication are only added if the request is to one of the credential hosts.
+func (h *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	for k, v := range h.headers {
+		req.Header[k] = v
+	}
+	if h.sendsCredentials(req.URL.Host) {
+		for k, v := range h.credentials {
+			req.Header[k] = v
+		}
+	}
+	return h.client.Do(req)
+}
+
+// sendsCredentials returns true if the headers, cookies and authentication are sent to the host. They are sent to any
+// host if no credential hosts are configured and the client has not been restricted to the start host of a crawl.
+func (h *HTTPClient) sendsCredentials(host string) bool {
+	if h.credentialHosts == nil {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, ch := range h.credentialHosts {
+		if host == ch || (strings.HasPrefix(ch, "*.") && strings.HasSuffix(host, ch[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictCredentials limits the headers, cookies and authentication to the start host of a crawl, unless credential
+// hosts have been configured. It must be called before the client is used.
+func (h *HTTPClient) restrictCredentials(startHost string) {
+	if len(h.config.CredentialHosts) == 0 {
+		h.credentialHosts = []string{strings.ToLower(startHost)}
+	}
+}
+
+// UserAgent returns the user agent sent with each request.
+func (h *HTTPClient) UserAgent() string {
+	return h.config.UserAgent
+}
+
+// MaxBodyBytes returns the maximum number of bytes read from each response body.
+func (h *HTTPClient) MaxBodyBytes() int64 {
+	return h.config.MaxBodyBytes
+}
+
+// acceptsContent returns true if pages of the media type are downloaded and parsed. An empty media type is not
+// accepted.
+func (h *HTTPClient) acceptsContent(mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+	for _, ct := range h.config.ContentTypes {
+		ct = strings.ToLower(ct)
+		if ct == mediaType || (strings.HasSuffix(ct, "/*") && strings.HasPrefix(mediaType, ct[:len(ct)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the lower case media type of a Content-Type header value, without any parameters.
+func mediaType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// withMinTimeout returns an HTTPClient sharing the same connections and settings, with a timeout of at least d.
+func (h *HTTPClient) withMinTimeout(d time.Duration) *HTTPClient {
+	if h.client.Timeout >= d {
+		return h
+	}
+	c := *h.client
+	c.Timeout = d
+	return &HTTPClient{client: &c, config: h.config, headers: h.headers, credentials: h.credentials, credentialHosts: h.credentialHosts}
+}
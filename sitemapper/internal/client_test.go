@@ -0,0 +1,418 @@
+package sitemap
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientConfig_Validate(t *testing.T) {
+	data := []struct {
+		name   string
+		config ClientConfig
+		valid  bool
+	}{
+		{"empty", ClientConfig{}, true},
+		{"full", ClientConfig{Headers: []string{"X-Test: 1"}, Cookies: []string{"session=abc"}, BasicAuth: "user:pass", Proxy: "socks5://localhost:1080"}, true},
+		{"bad header", ClientConfig{Headers: []string{"X-Test"}}, false},
+		{"empty header name", ClientConfig{Headers: []string{": value"}}, false},
+		{"bad cookie", ClientConfig{Cookies: []string{"session"}}, false},
+		{"bad basic auth", ClientConfig{BasicAuth: "user"}, false},
+		{"basic and bearer", ClientConfig{BasicAuth: "user:pass", BearerToken: "token"}, false},
+		{"bad proxy scheme", ClientConfig{Proxy: "ftp://localhost"}, false},
+		{"negative timeout", ClientConfig{Timeout: -time.Second}, false},
+		{"negative max body", ClientConfig{MaxBodyBytes: -1}, false},
+		{"content types", ClientConfig{ContentTypes: []string{"text/html", "text/*"}}, true},
+		{"bad content type", ClientConfig{ContentTypes: []string{"html"}}, false},
+		{"empty subtype", ClientConfig{ContentTypes: []string{"text/"}}, false},
+		{"negative max redirects", ClientConfig{MaxRedirects: -1}, false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(d.config.Validate() == nil, d.valid)
+		})
+	}
+}
+
+func TestLoadClientConfig(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "client.json")
+	is.NoErr(os.WriteFile(path, []byte(`{"UserAgent": "test-agent", "Headers": ["X-Test: 1"], "Timeout": "10s", "MaxBodyBytes": 1024}`), 0600))
+
+	cfg, err := LoadClientConfig(path)
+	is.NoErr(err)
+	is.Equal(cfg.UserAgent, "test-agent")
+	is.Equal(cfg.Headers, []string{"X-Test: 1"})
+	is.Equal(cfg.Timeout, 10*time.Second)
+	is.Equal(cfg.MaxBodyBytes, int64(1024))
+
+	is.NoErr(os.WriteFile(path, []byte(`{"Timeout": "soon"}`), 0600))
+	_, err = LoadClientConfig(path)
+	is.True(err != nil)
+}
+
+func TestClientFlags_Config(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "client.json")
+	is.NoErr(os.WriteFile(path, []byte(`{"UserAgent": "file-agent", "Headers": ["X-File: 1"], "Timeout": "10s"}`), 0600))
+	f := ClientFlags{
+		ConfigFile:   path,
+		UserAgent:    DefaultUserAgent,
+		Headers:      []string{"X-Flag: 2"},
+		Timeout:      DefaultTimeout,
+		MaxBodyBytes: DefaultMaxBodyBytes,
+		ContentTypes: DefaultContentTypes,
+		MaxRedirects: DefaultMaxRedirects,
+	}
+	unchanged := func(string) bool { return false }
+
+	// Flags left at their defaults only fill in the settings missing from the file
+	cfg, err := f.Config(unchanged)
+	is.NoErr(err)
+	is.Equal(cfg.UserAgent, "file-agent")
+	is.Equal(cfg.Headers, []string{"X-File: 1", "X-Flag: 2"})
+	is.Equal(cfg.Timeout, 10*time.Second)
+	is.Equal(cfg.MaxBodyBytes, int64(DefaultMaxBodyBytes))
+	is.Equal(cfg.MaxRedirects, DefaultMaxRedirects)
+
+	f.Timeout = time.Second
+	f.BearerToken = "token"
+	cfg, err = f.Config(func(name string) bool { return name == "request-timeout" })
+	is.NoErr(err)
+	is.Equal(cfg.Timeout, time.Second)
+	is.Equal(cfg.BearerToken, "token")
+
+	f.BasicAuth = "user:pass"
+	_, err = f.Config(unchanged)
+	is.True(err != nil) // both credentials set
+
+	f.ConfigFile = filepath.Join(t.TempDir(), "missing.json")
+	_, err = f.Config(unchanged)
+	is.True(err != nil)
+}
+
+func TestHTTPClient_Headers(t *testing.T) {
+	data := []struct {
+		name     string
+		config   ClientConfig
+		header   string
+		expected string
+	}{
+		{"default user agent", ClientConfig{}, "User-Agent", DefaultUserAgent},
+		{"user agent", ClientConfig{UserAgent: "test-agent/1.0"}, "User-Agent", "test-agent/1.0"},
+		{"header", ClientConfig{Headers: []string{"X-Test:  a value "}}, "X-Test", "a value"},
+		{"cookies", ClientConfig{Cookies: []string{"a=1", "b=2"}}, "Cookie", "a=1; b=2"},
+		{"basic auth", ClientConfig{BasicAuth: "user:pass"}, "Authorization", "Basic dXNlcjpwYXNz"},
+		{"bearer token", ClientConfig{BearerToken: "token"}, "Authorization", "Bearer token"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			var got string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get(d.header)
+			}))
+			defer srv.Close()
+
+			h, err := NewHTTPClient(d.config)
+			is.NoErr(err)
+			_, err = h.Fetch(context.Background(), srv.URL)
+			is.NoErr(err)
+			is.Equal(got, d.expected)
+		})
+	}
+}
+
+func TestHTTPClient_CredentialHosts(t *testing.T) {
+	data := []struct {
+		name       string
+		hosts      func(start, other string) []string
+		restrict   bool
+		start      string
+		other      string
+		redirected string
+	}{
+		{"all hosts", func(start, other string) []string { return nil }, false, "Bearer token", "Bearer token", "Bearer token"},
+		{"start host", func(start, other string) []string { return nil }, true, "Bearer token", "", ""},
+		{"listed hosts", func(start, other string) []string { return []string{start, other} }, true, "Bearer token", "Bearer token", "Bearer token"},
+		{"listed host", func(start, other string) []string { return []string{other} }, false, "", "Bearer token", ""},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			var got string
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("Authorization")
+			}))
+			defer other.Close()
+			start := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/redirect" {
+					http.Redirect(w, r, other.URL, http.StatusFound)
+					return
+				}
+				got = r.Header.Get("Authorization")
+			}))
+			defer start.Close()
+			startURL, _ := url.Parse(start.URL)
+			otherURL, _ := url.Parse(other.URL)
+
+			h, err := NewHTTPClient(ClientConfig{BearerToken: "token", CredentialHosts: d.hosts(startURL.Host, otherURL.Host)})
+			is.NoErr(err)
+			if d.restrict {
+				h.restrictCredentials(startURL.Host)
+			}
+			_, err = h.Fetch(context.Background(), start.URL)
+			is.NoErr(err)
+			is.Equal(got, d.start)
+			_, err = h.Fetch(context.Background(), other.URL)
+			is.NoErr(err)
+			is.Equal(got, d.other)
+			got = ""
+			_, err = h.Fetch(context.Background(), start.URL+"/redirect")
+			is.NoErr(err)
+			is.Equal(got, d.redirected) // credentials are removed on redirect to a host not listed
+		})
+	}
+}
+
+func TestHTTPClient_MaxBodyBytes(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, strings.Repeat("a", 100))
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTPClient(ClientConfig{MaxBodyBytes: 10})
+	is.NoErr(err)
+	res, err := h.Fetch(context.Background(), srv.URL)
+	is.NoErr(err)
+	is.Equal(len(res.Content), 10)
+	is.True(res.Truncated)
+	is.True(res.status().Truncated)
+
+	h, err = NewHTTPClient(ClientConfig{MaxBodyBytes: 100})
+	is.NoErr(err)
+	res, err = h.Fetch(context.Background(), srv.URL)
+	is.NoErr(err)
+	is.Equal(len(res.Content), 100)
+	is.True(!res.Truncated)
+}
+
+func TestHTTPClient_ContentTypes(t *testing.T) {
+	data := []struct {
+		name         string
+		config       ClientConfig
+		contentType  string
+		body         string
+		expected     string
+		expectedType string
+	}{
+		{"html", ClientConfig{}, "text/html; charset=utf-8", "<p>page</p>", "<p>page</p>", "text/html; charset=utf-8"},
+		{"xhtml", ClientConfig{}, "application/xhtml+xml", "<p>page</p>", "<p>page</p>", "application/xhtml+xml"},
+		{"pdf", ClientConfig{}, "application/pdf", "%PDF-1.4", "", "application/pdf"},
+		{"plain text", ClientConfig{}, "text/plain", "<p>page</p>", "", "text/plain"},
+		{"sniffed html", ClientConfig{}, "", "<html><p>page</p></html>", "<html><p>page</p></html>", "text/html; charset=utf-8"},
+		{"sniffed binary", ClientConfig{}, "", "\x00\x01\x02", "", "application/octet-stream"},
+		{"wildcard", ClientConfig{ContentTypes: []string{"text/*"}}, "text/plain", "page", "page", "text/plain"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Setting the header to nil stops the server sniffing a content type of its own
+				w.Header()["Content-Type"] = nil
+				if d.contentType != "" {
+					w.Header().Set("Content-Type", d.contentType)
+				}
+				fmt.Fprint(w, d.body)
+			}))
+			defer srv.Close()
+
+			h, err := NewHTTPClient(d.config)
+			is.NoErr(err)
+			res, err := h.Fetch(context.Background(), srv.URL)
+			is.NoErr(err)
+			is.Equal(res.StatusCode, http.StatusOK)
+			is.Equal(res.Content, d.expected)
+			is.Equal(res.status().ContentType, d.expectedType)
+		})
+	}
+}
+
+func TestHTTPClient_HeadCheck(t *testing.T) {
+	var gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets++
+		}
+		switch r.URL.Path {
+		case "/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+		}
+		fmt.Fprint(w, "<p>page</p>")
+	}))
+	defer srv.Close()
+
+	data := []struct {
+		path     string
+		gets     int
+		expected string
+	}{
+		{"/doc.pdf", 0, ""},
+		{"/page.html", 1, "<p>page</p>"},
+		{"/no-head", 1, "<p>page</p>"},
+	}
+
+	h, err := NewHTTPClient(ClientConfig{HeadCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range data {
+		t.Run(d.path, func(t *testing.T) {
+			is := is.New(t)
+			gets = 0
+			res, err := h.Fetch(context.Background(), srv.URL+d.path)
+			is.NoErr(err)
+			is.Equal(res.Content, d.expected)
+			is.Equal(gets, d.gets)
+		})
+	}
+}
+
+func TestCrawlEngine_ContentTypes(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("X-Robots-Tag", "noindex")
+			fmt.Fprint(w, `<a href="/hidden.html">not a link</a>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/doc.pdf">doc</a>`)
+		}
+	}))
+	defer srv.Close()
+
+	sm := NewSiteMap()
+	NewSynchronousCrawlEngine(sm, 3, srv.URL+"/").Run(context.Background())
+	links, ok := sm.GetLinks(srv.URL + "/doc.pdf")
+	is.True(ok)
+	is.Equal(len(links), 0)
+	status, _ := sm.GetStatus(srv.URL + "/doc.pdf")
+	is.True(status.OK())
+	is.Equal(status.ContentType, "application/pdf")
+	is.Equal(sm.GetRobotsDirectives(srv.URL+"/doc.pdf"), []string{DirectiveNoIndex})
+	_, ok = sm.GetLinks(srv.URL + "/hidden.html")
+	is.True(!ok)
+
+	// Pages from a Fetcher with no content type are sniffed
+	sm = NewSiteMap()
+	f := FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+		return &Response{StatusCode: http.StatusOK, Header: http.Header{}, Content: "%PDF-1.4 <a href=\"/a\">a</a>"}, nil
+	})
+	NewSynchronousCrawlEngine(sm, 2, "http://example.com/", WithFetcher(f)).Run(context.Background())
+	links, _ = sm.GetLinks("http://example.com/")
+	is.Equal(len(links), 0)
+}
+
+func TestHTTPClient_Proxy(t *testing.T) {
+	is := is.New(t)
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	h, err := NewHTTPClient(ClientConfig{Proxy: proxy.URL})
+	is.NoErr(err)
+	_, err = h.Fetch(context.Background(), "http://example.com/page")
+	is.NoErr(err)
+	is.Equal(proxied, "http://example.com/page")
+}
+
+func TestHTTPClient_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		name   string
+		config ClientConfig
+		valid  bool
+	}{
+		{"untrusted", ClientConfig{}, false},
+		{"ca file", ClientConfig{CAFile: caFile}, true},
+		{"insecure", ClientConfig{Insecure: true}, true},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			h, err := NewHTTPClient(d.config)
+			is.NoErr(err)
+			_, err = h.Fetch(context.Background(), srv.URL)
+			is.Equal(err == nil, d.valid)
+			if !d.valid {
+				is.Equal(classifyError(err), ErrorClassTLS)
+			}
+		})
+	}
+}
+
+func TestRobotsChecker_Client(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "User-agent: test-agent\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTPClient(ClientConfig{UserAgent: "test-agent", BearerToken: "token"})
+	is.NoErr(err)
+	rc := NewRobotsCheckerWithClient(h)
+	is.True(!rc.Allowed(context.Background(), srv.URL+"/private"))
+	is.True(rc.Allowed(context.Background(), srv.URL+"/public"))
+}
+
+func TestClientConfig_WithoutCredentials(t *testing.T) {
+	is := is.New(t)
+	c := ClientConfig{UserAgent: "test", BasicAuth: "user:secret"}
+	is.True(c.HasCredentials())
+	stripped := c.WithoutCredentials()
+	is.Equal(stripped, ClientConfig{UserAgent: "test"})
+	is.True(!stripped.HasCredentials())
+	is.Equal(c.BasicAuth, "user:secret")
+	is.True(ClientConfig{BearerToken: "token"}.HasCredentials())
+}
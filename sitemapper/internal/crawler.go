@@ -2,32 +2,181 @@
 package sitemap
 
 import (
-	"errors"
-	"fmt"
-	"golang.org/x/net/html"
-	"io/ioutil"
+	"context"
+	"io"
 	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
 	"sync"
-	"time"
 )
 
-const MAX_BACKOFF_MS int = 500
-
 // CrawlEngine is the interface implemented by the various crawl engines.
+// Run crawls until complete or until the context is cancelled, and returns the SiteMap along with a boolean which is
+// true if the crawl was cut short by the context, in which case the SiteMap holds partial results.
 type CrawlEngine interface {
-	Run()
+	Run(ctx context.Context) (*SiteMap, bool)
 }
 
 // A SynchronousCrawlEngine recursively visits extracted URLs one URL at a time up to a specified tree depth.
 type SynchronousCrawlEngine struct {
-	sm       *SiteMap
-	maxDepth int
-	startURL string
+	sm         *SiteMap
+	maxDepth   int
+	startURL   string
+	robots     *RobotsChecker
+	seeds      []string
+	hosts      *HostLimiter
+	extractors []LinkExtractor
+	nofollow   bool
+	normalizer *Normalizer
+	query      *QueryPolicy
+	queryLimit *queryLimiter
+	scope      *Scope
+	hostScope  *HostScope
+	inHost     func(host string) bool
+	external   bool
+	client     *HTTPClient
+	fetcher    Fetcher
+	middleware []Middleware
+	retry      *RetryPolicy
+	failed     []frontierItem
+	failMutex  sync.Mutex
+	attempts   map[string]int
+	finalURLs  bool
+	stream     *resultStream
+}
+
+// An Option configures optional crawl behaviour shared by all crawl engines.
+type Option func(*SynchronousCrawlEngine)
+
+// WithRobots configures the crawl engine to consult robots.txt before visiting each URL.
+// URLs which are disallowed are recorded in the SiteMap as skipped.
+func WithRobots(rc *RobotsChecker) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.robots = rc
+	}
+}
+
+// WithSitemapSeeds provides additional URLs, discovered from sitemap files, which are crawled from depth zero
+// alongside the start URL. The seeds are recorded in the SiteMap so that sitemap-only URLs can be reported.
+func WithSitemapSeeds(urls []string) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.seeds = urls
+	}
+}
+
+// WithHostLimiter configures the crawl engine to schedule each request through the per-host politeness limiter.
+func WithHostLimiter(h *HostLimiter) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.hosts = h
+	}
+}
+
+// WithLinkExtractors replaces the default HTMLExtractor with the provided link extractors. The links found by each
+// extractor are merged, so custom extractors may be used alongside an HTMLExtractor.
+func WithLinkExtractors(extractors ...LinkExtractor) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.extractors = extractors
+	}
+}
+
+// WithNoFollow configures the crawl engine not to follow links marked rel="nofollow", or any of the links on a page
+// carrying a nofollow robots directive. The links are still recorded in the SiteMap.
+func WithNoFollow() Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.nofollow = true
+	}
+}
+
+// WithNormalizer replaces the default Normalizer used to convert crawled URLs and the links found to a canonical form.
+// The Normalizer is also used by the SiteMap.
+func WithNormalizer(n *Normalizer) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.normalizer = n
+	}
+}
+
+// WithQueryPolicy configures which query parameters are kept on the links found, and optionally caps the number of
+// distinct values followed for each parameter. The policy is applied on top of the Normalizer.
+func WithQueryPolicy(p QueryPolicy) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.query = &p
+	}
+}
+
+// WithScope restricts the crawl to the URLs allowed by the Scope. Links outside the scope are recorded in the SiteMap
+// as skipped, and sitemap seeds outside the scope are ignored. The start URL is always crawled.
+func WithScope(s *Scope) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.scope = s
+	}
+}
+
+// WithHostScope configures which hosts are crawled. By default only the host of the start URL is crawled.
+func WithHostScope(h HostScope) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.hostScope = &h
+	}
+}
+
+// WithExternalLinks configures the crawl engine to record the links from each page to hosts outside the host scope.
+// External links are recorded in the SiteMap but never crawled.
+func WithExternalLinks() Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.external = true
+	}
+}
+
+// WithHTTPClient replaces the default HTTPClient used to fetch pages. The same client should be given to the
+// RobotsChecker using NewRobotsCheckerWithClient, so that robots.txt is fetched with the same user agent and settings.
+func WithHTTPClient(h *HTTPClient) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.client = h
+	}
+}
+
+// WithFetcher replaces the Fetcher used to retrieve pages, which by default is the HTTPClient. The HTTPClient is still
+// used for robots.txt and sitemap files, and its user agent selects the X-Robots-Tag directives which apply.
+func WithFetcher(f Fetcher) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.fetcher = f
+	}
+}
+
+// WithFetchMiddleware wraps the Fetcher, whether the default or one given with WithFetcher, with each of the
+// middleware in the order given by Chain.
+func WithFetchMiddleware(mw ...Middleware) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.middleware = append(c.middleware, mw...)
+	}
+}
+
+// WithRetryPolicy configures the crawl engine to retry fetches which fail with a transient error. The retries are made
+// outside of any fetch middleware, so the middleware sees each attempt. With Requeue set, URLs which still fail are
+// crawled once more after the rest of the crawl.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.retry = &p
+	}
+}
+
+// WithFinalURLs configures the crawl engine to record a URL which redirects to another crawlable URL under the final
+// URL of its redirect chain, so that each page appears once however it is linked to. The redirected URL and its chain
+// are kept for the redirect report. URLs which redirect to a host or path outside the crawl are recorded as usual.
+func WithFinalURLs() Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.finalURLs = true
+	}
+}
+
+// WithResultStream configures the crawl engine to write the result of each crawled URL to w as newline delimited JSON,
+// as soon as the crawl has finished with the URL. Results are written in the order URLs are crawled, and links to URLs
+// which are later recorded under their final URL are not resolved. The results can be read with an NDJSONDecoder.
+func WithResultStream(w io.Writer) Option {
+	return func(c *SynchronousCrawlEngine) {
+		c.stream = newResultStream(w)
+	}
 }
 
 // A ConcurrentCrawlEngine recursively visits extracted URLs up to a specified tree depth,
@@ -37,172 +186,504 @@ type ConcurrentCrawlEngine struct {
 	WG sync.WaitGroup
 }
 
-// A ConcurrentLimitedCrawlEngine recursively visits extracted URLs up to a specified tree depth,
-// with each visit happening concurrently, with a limit to the number of concurrent visits.
-// A WaitGroup is used to monitor for crawl completion.
+// A ConcurrentLimitedCrawlEngine visits extracted URLs breadth first up to a specified tree depth, using a fixed pool
+// of worker goroutines which take URLs from a shared frontier queue. The number of workers is set by the Limiter.
+// The number of URLs visited at each depth is recorded.
 type ConcurrentLimitedCrawlEngine struct {
-	ConcurrentCrawlEngine
-	limiter *Limiter
+	SynchronousCrawlEngine
+	limiter     *Limiter
+	mutex       sync.Mutex
+	depthCounts []int
 }
 
 // NewSynchronousCrawlEngine returns a pointer to an instance of a SynchronousCrawlEngine.
-func NewSynchronousCrawlEngine(sitemap *SiteMap, maxDepth int, startURL string) *SynchronousCrawlEngine {
-	return &SynchronousCrawlEngine{sm: sitemap, maxDepth: maxDepth, startURL: startURL}
+func NewSynchronousCrawlEngine(sitemap *SiteMap, maxDepth int, startURL string, opts ...Option) *SynchronousCrawlEngine {
+	c := &SynchronousCrawlEngine{sm: sitemap, maxDepth: maxDepth, startURL: startURL}
+	c.apply(opts)
+	return c
 }
 
 // NewConcurrentCrawlEngine returns a pointer to an instance of a ConcurrentCrawlEngine.
-func NewConcurrentCrawlEngine(sitemap *SiteMap, maxDepth int, startURL string) *ConcurrentCrawlEngine {
-	return &ConcurrentCrawlEngine{SynchronousCrawlEngine: SynchronousCrawlEngine{sm: sitemap, maxDepth: maxDepth, startURL: startURL}}
+func NewConcurrentCrawlEngine(sitemap *SiteMap, maxDepth int, startURL string, opts ...Option) *ConcurrentCrawlEngine {
+	c := &ConcurrentCrawlEngine{SynchronousCrawlEngine: SynchronousCrawlEngine{sm: sitemap, maxDepth: maxDepth, startURL: startURL}}
+	c.apply(opts)
+	return c
 }
 
 // NewConcurrentLimitedCrawlEngine returns a pointer to an instance of a ConcurrentLimitedCrawlEngine.
-func NewConcurrentLimitedCrawlEngine(sitemap *SiteMap, maxDepth int, startURL string, limiter *Limiter) *ConcurrentLimitedCrawlEngine {
-	return &ConcurrentLimitedCrawlEngine{
-		ConcurrentCrawlEngine: ConcurrentCrawlEngine{
-			SynchronousCrawlEngine: SynchronousCrawlEngine{
-				sm:       sitemap,
-				maxDepth: maxDepth,
-				startURL: startURL,
-			},
+func NewConcurrentLimitedCrawlEngine(sitemap *SiteMap, maxDepth int, startURL string, limiter *Limiter, opts ...Option) *ConcurrentLimitedCrawlEngine {
+	c := &ConcurrentLimitedCrawlEngine{
+		SynchronousCrawlEngine: SynchronousCrawlEngine{
+			sm:       sitemap,
+			maxDepth: maxDepth,
+			startURL: startURL,
 		},
 		limiter: limiter,
 	}
+	c.apply(opts)
+	return c
+}
+
+// apply applies each of the provided options to the crawl engine.
+func (c *SynchronousCrawlEngine) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.client == nil {
+		c.client = defaultHTTPClient(DefaultUserAgent)
+	}
+	if len(c.extractors) == 0 {
+		c.extractors = []LinkExtractor{&HTMLExtractor{UserAgent: c.client.UserAgent()}}
+	}
+	if c.normalizer == nil {
+		c.normalizer = NewNormalizer()
+	}
+	if c.fetcher == nil {
+		c.fetcher = c.client
+	}
+	c.fetcher = Chain(c.fetcher, c.middleware...)
+	if c.hosts != nil {
+		c.fetcher = c.hosts.Limit()(c.fetcher)
+	}
+	if c.retry != nil && c.retry.MaxAttempts > 1 {
+		c.fetcher = Retry(*c.retry)(c.fetcher)
+	}
+	if c.query != nil {
+		c.normalizer = c.query.normalizer(c.normalizer)
+		if c.query.MaxValues > 0 {
+			c.queryLimit = newQueryLimiter(c.query.MaxValues)
+		}
+	}
+	c.sm.SetNormalizer(c.normalizer)
+	c.startURL = c.normalizer.Normalize(c.startURL)
+	hs := HostScope{Mode: HostScopeExact}
+	if c.hostScope != nil {
+		hs = *c.hostScope
+	}
+	root, err := url.Parse(c.startURL)
+	if err != nil {
+		root = &url.URL{}
+	}
+	c.inHost = hs.matcher(root)
+	c.client.restrictCredentials(root.Host)
+	seeds := make([]string, 0, len(c.seeds))
+	for _, s := range c.seeds {
+		s = c.normalizer.Normalize(s)
+		if su, err := url.Parse(s); err != nil || !c.inHost(su.Host) {
+			continue
+		}
+		if c.scope != nil && !c.scope.Allowed(s) {
+			continue
+		}
+		seeds = append(seeds, s)
+	}
+	c.seeds = seeds
+	// The start URL and seeds count towards the query parameter values, although they are always crawled
+	if c.queryLimit != nil {
+		c.queryLimit.allow(c.startURL)
+		for _, s := range c.seeds {
+			c.queryLimit.allow(s)
+		}
+	}
 }
 
 // Run begins the sitemap crawl activity for the SynchronousCrawlEngine.
-func (c *SynchronousCrawlEngine) Run() {
-	c.crawl(c.startURL, c.startURL, c.startURL, 0)
+func (c *SynchronousCrawlEngine) Run(ctx context.Context) (*SiteMap, bool) {
+	c.sm.AddSitemapURLs(c.seeds)
+	c.crawl(ctx, c.startURL, c.startURL, c.startURL, 0)
+	for _, s := range c.seeds {
+		c.crawl(ctx, s, c.startURL, c.startURL, 0)
+	}
+	for _, item := range c.requeue(ctx) {
+		c.crawl(ctx, item.url, c.startURL, item.parent, item.depth)
+	}
+	return c.sm, c.sm.Truncated()
 }
 
 // Run begins the sitemap crawl activity for the ConcurrentCrawlEngine.
-func (c *ConcurrentCrawlEngine) Run() {
-	c.crawl(c.startURL, c.startURL, c.startURL, 0)
+func (c *ConcurrentCrawlEngine) Run(ctx context.Context) (*SiteMap, bool) {
+	c.sm.AddSitemapURLs(c.seeds)
+	c.crawl(ctx, c.startURL, c.startURL, c.startURL, 0)
+	for _, s := range c.seeds {
+		c.spawn(ctx, s, c.startURL, c.startURL, 0)
+	}
 	c.WG.Wait()
+	for _, item := range c.requeue(ctx) {
+		c.spawn(ctx, item.url, c.startURL, item.parent, item.depth)
+	}
+	c.WG.Wait()
+	return c.sm, c.sm.Truncated()
 }
 
 // Run begins the sitemap crawl activity for the ConcurrentLimitedCrawlEngine.
-func (c *ConcurrentLimitedCrawlEngine) Run() {
-	rand.Seed(time.Now().Unix())
-	c.crawl(c.startURL, c.startURL, c.startURL, 0)
-	c.WG.Wait()
+// The start URL and any seeds are queued, and Run returns once the workers have emptied the frontier.
+func (c *ConcurrentLimitedCrawlEngine) Run(ctx context.Context) (*SiteMap, bool) {
+	c.sm.AddSitemapURLs(c.seeds)
+	c.depthCounts = make([]int, c.maxDepth)
+
+	var items []frontierItem
+	if c.maxDepth > 0 {
+		items = append(items, frontierItem{url: c.startURL, parent: c.startURL})
+		for _, s := range c.seeds {
+			items = append(items, frontierItem{url: s, parent: c.startURL})
+		}
+	}
+	c.runWorkers(ctx, items)
+	c.runWorkers(ctx, c.requeue(ctx))
+
+	for d, n := range c.depthCounts {
+		log.Printf("visited %d URLs at depth %d", n, d)
+	}
+	return c.sm, c.sm.Truncated()
 }
 
-// crawl is the recursive function which is called for each visit to a specified URL.
-// For the SynchronousCrawlEngine, crawl performs a recursive synchronous depth-first traversal.
-func (c *SynchronousCrawlEngine) crawl(u, root, parent string, depth int) {
-	if c.maxDepth == depth {
+// runWorkers queues the items on a new frontier and visits them using the pool of workers, returning once the
+// frontier is empty.
+func (c *ConcurrentLimitedCrawlEngine) runWorkers(ctx context.Context, items []frontierItem) {
+	if len(items) == 0 {
 		return
 	}
-	urls, exists := getLinks(u, root, parent, depth, c.sm)
-	if exists {
-		return
+	f := newFrontier()
+	for _, item := range items {
+		f.push(item)
 	}
-	depth++
 
-	for _, urlLink := range urls {
-		c.crawl(urlLink, root, u, depth)
+	workers := c.limiter.Limit()
+	if workers < 1 {
+		workers = 1
 	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx, f)
+		}()
+	}
+	wg.Wait()
+}
+
+// DepthCounts returns the number of URLs visited at each depth during the most recent run.
+func (c *ConcurrentLimitedCrawlEngine) DepthCounts() []int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make([]int, len(c.depthCounts))
+	copy(counts, c.depthCounts)
+	return counts
 }
 
 // crawl is the recursive function which is called for each visit to a specified URL.
-// For the ConcurrentCrawlEngine, crawl performs a recursive concurrent traversal where each URL at each depth
-// is crawled in a new goroutine concurrently. A WaitGroup keeps track of the number of goroutines.
-func (c *ConcurrentCrawlEngine) crawl(u, root, parent string, depth int) {
+// For the SynchronousCrawlEngine, crawl performs a recursive synchronous depth-first traversal.
+func (c *SynchronousCrawlEngine) crawl(ctx context.Context, u, root, parent string, depth int) {
 	if c.maxDepth == depth {
 		return
 	}
-	urls, exists := getLinks(u, root, parent, depth, c.sm)
+	urls, exists := c.getLinks(ctx, u, root, parent, depth)
 	if exists {
 		return
 	}
 	depth++
 
 	for _, urlLink := range urls {
-		c.WG.Add(1)
-		ul := urlLink
-		go func() {
-			defer c.WG.Done()
-			c.crawl(ul, root, parent, depth)
-		}()
+		c.crawl(ctx, urlLink, root, u, depth)
 	}
 }
 
 // crawl is the recursive function which is called for each visit to a specified URL.
-// For the ConcurrentLimitedCrawlEngine, crawl performs a recursive concurrent traversal where each URL at each depth
-// is crawled in a new goroutine concurrently, with a limited enforcing the maximum number of concurrent goroutines.
-// A WaitGroup keeps track of the number of goroutines.
-func (c *ConcurrentLimitedCrawlEngine) crawl(u, root, parent string, depth int) {
+// For the ConcurrentCrawlEngine, crawl performs a recursive concurrent traversal where each URL at each depth
+// is crawled in a new goroutine concurrently. A WaitGroup keeps track of the number of goroutines.
+func (c *ConcurrentCrawlEngine) crawl(ctx context.Context, u, root, parent string, depth int) {
 	if c.maxDepth == depth {
 		return
 	}
-	urls, exists := getLinks(u, root, parent, depth, c.sm)
+	urls, exists := c.getLinks(ctx, u, root, parent, depth)
 	if exists {
 		return
 	}
 	depth++
 
 	for _, urlLink := range urls {
-		c.WG.Add(1)
-		ul := urlLink
-		go func() {
-			defer c.WG.Done()
-			for {
-				err := c.limiter.RunFunc(func() {
-					c.crawl(ul, root, parent, depth)
-				})
-				if err != nil {
-					n := rand.Intn(MAX_BACKOFF_MS)
-					log.Printf("task limited for URL %s, sleeping for %d millisecconds\n", ul, n)
-					time.Sleep(time.Duration(n) * time.Millisecond)
-				} else {
-					break
-				}
+		c.spawn(ctx, urlLink, root, u, depth)
+	}
+}
+
+// spawn crawls the URL in a new goroutine which is tracked by the WaitGroup.
+func (c *ConcurrentCrawlEngine) spawn(ctx context.Context, u, root, parent string, depth int) {
+	c.WG.Add(1)
+	go func() {
+		defer c.WG.Done()
+		c.crawl(ctx, u, root, parent, depth)
+	}()
+}
+
+// work is run by each worker goroutine. It takes URLs from the frontier and visits them, queueing any links found
+// when the next depth is within the maximum depth, until the frontier reports that the crawl is complete.
+// Once the context is cancelled getLinks returns immediately, so the workers quickly drain the remaining URLs.
+func (c *ConcurrentLimitedCrawlEngine) work(ctx context.Context, f *frontier) {
+	for {
+		item, ok := f.pop()
+		if !ok {
+			return
+		}
+
+		urls, exists := c.getLinks(ctx, item.url, c.startURL, item.parent, item.depth)
+		// A requeued URL was already counted when it was first visited
+		if _, requeued := c.attempts[item.url]; !exists && !requeued {
+			c.mutex.Lock()
+			c.depthCounts[item.depth]++
+			c.mutex.Unlock()
+		}
+		if !exists && item.depth+1 < c.maxDepth {
+			for _, u := range urls {
+				f.push(frontierItem{url: u, parent: item.url, depth: item.depth + 1})
 			}
-		}()
+		}
+		f.done(item)
 	}
 }
 
 // getLinks performs a series of tasks, calling into other functions responsible for fetching the HTML,
 // extracting any links, and then cleaning the extracted links.
 // getLinks returns a slice of strings of relevant and applicable links as related to the parent and root URLs.
-func getLinks(url, root, parent string, depth int, sm *SiteMap) ([]string, bool) {
+// If the context has been cancelled the URL is not visited and the SiteMap is marked as truncated.
+func (c *SynchronousCrawlEngine) getLinks(ctx context.Context, url, root, parent string, depth int) ([]string, bool) {
+	sm := c.sm
+	sm.SetDiscovered(url, parent, depth)
 	if urls, exists := sm.GetLinks(url); exists {
 		return urls, true
 	}
 
+	if ctx.Err() != nil {
+		sm.SetTruncated()
+		return nil, false
+	}
+
+	if c.robots != nil && !c.robots.Allowed(ctx, url) {
+		log.Printf("skipping URL %s disallowed by robots.txt", url)
+		sm.AddSkippedURL(url, SkipReasonRobots)
+		return nil, false
+	}
+
 	sm.AddURL(url)
 	log.Printf("visiting URL %s at depth %d with parent %s", url, depth, parent)
+	visited := url
+	if c.stream != nil {
+		defer func() { c.stream.write(sm, visited) }()
+	}
 
-	content, requestUrl, err := getHTML(url)
+	res, err := c.fetcher.Fetch(ctx, url)
+	if res == nil {
+		res = &Response{}
+	}
+	status := res.status()
 	if err != nil {
+		if ctx.Err() != nil {
+			sm.SetTruncated()
+		}
+		status.ErrorClass = classifyError(err)
+		c.setStatus(url, status)
 		log.Printf("error retrieving content for URL %s: %v", url, err)
+		if c.retry != nil && c.retry.Requeue && ctx.Err() == nil && retryable(err) {
+			c.failMutex.Lock()
+			c.failed = append(c.failed, frontierItem{url: url, parent: parent, depth: depth})
+			c.failMutex.Unlock()
+			// The result is written when the URL is visited again, unless the crawl is cancelled before then
+			if c.attempts == nil {
+				visited = ""
+			}
+		}
 		return nil, false
 	}
-	if content == "" {
+	if final, ok := c.finalURL(url, res); ok {
+		log.Printf("recording URL %s under its final URL %s", url, final)
+		sm.AddRedirect(url, status)
+		visited = ""
+		// The redirect chain belongs to the redirected URL rather than the final URL
+		status.Redirects = nil
+		if _, exists := sm.GetLinks(final); exists {
+			return nil, true
+		}
+		sm.AddURL(final)
+		sm.SetDiscovered(final, parent, depth)
+		url = final
+		visited = final
+	}
+	if lm, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		sm.SetLastModified(url, lm)
+	}
+	if mt := res.mediaType(); !c.client.acceptsContent(mt) {
+		log.Printf("not parsing URL %s with content type %s", url, mt)
+		c.setStatus(url, status)
+		if directives := robotsTagDirectives(res.Header, c.client.UserAgent()); len(directives) > 0 {
+			sm.SetRobotsDirectives(url, directives)
+		}
 		return nil, false
 	}
-	links, err := extractLinks(content)
+	ex, err := extractAll(c.extractors, res.Content)
 	if err != nil {
+		status.ErrorClass = ErrorClassParse
+		c.setStatus(url, status)
 		log.Printf("error extracting links from HTML content for URL %s: %v", url, err)
 		return nil, false
 	}
-	if links == nil {
+	c.setStatus(url, status)
+	directives := mergeDirectives(robotsTagDirectives(res.Header, c.client.UserAgent()), ex.Robots)
+	if len(directives) > 0 {
+		sm.SetRobotsDirectives(url, directives)
+	}
+
+	// Relative references are resolved against the <base href> of the page if it has one
+	base := res.URL
+	if ex.Base != "" {
+		if b, err := res.URL.Parse(ex.Base); err == nil {
+			base = b
+		}
+	}
+	if len(ex.Assets) > 0 {
+		sm.UpdateURLWithAssets(url, resolveAssets(ex.Assets, base))
+	}
+	if ex.Links == nil {
 		return nil, false
 	}
 
-	urls := cleanLinks(links, root, requestUrl)
+	if ex.Canonical != "" {
+		if cu, err := base.Parse(ex.Canonical); err == nil {
+			sm.SetCanonical(url, cu.String())
+		}
+	}
+
+	urls, external := cleanLinks(ex.Links, root, base, c.normalizer, c.inHost)
+	urls, skippedLinks := c.filterLinks(urls)
 	if len(urls) > 0 {
 		sm.UpdateURLWithLinks(url, urls)
 	}
+	if c.external && len(external) > 0 {
+		sm.UpdateURLWithExternalLinks(url, external)
+	}
+
+	if c.nofollow {
+		if hasDirective(directives, DirectiveNoFollow) {
+			log.Printf("not following links on URL %s with nofollow directive", url)
+			return nil, false
+		}
+		// The nofollow links are removed before cleaning, as a followed link may clean to the same URL
+		urls, _ = cleanLinks(removeLinks(ex.Links, ex.NoFollow), root, base, c.normalizer, c.inHost)
+		urls = removeLinks(urls, skippedLinks)
+	}
 
 	return urls, false
 }
 
+// finalURL returns the normalized final URL of a response which was redirected, along with true if the URL should be
+// recorded under it in place of the requested URL.
+func (c *SynchronousCrawlEngine) finalURL(u string, res *Response) (string, bool) {
+	if !c.finalURLs || len(res.Redirects) == 0 || res.URL == nil {
+		return "", false
+	}
+	fu := c.normalizer.NormalizeURL(res.URL)
+	final := fu.String()
+	if final == u || !c.inHost(fu.Host) || (c.scope != nil && !c.scope.Allowed(final)) {
+		return "", false
+	}
+	return final, true
+}
+
+// setStatus records the outcome of fetching a URL, adding any attempts made before the URL was requeued.
+func (c *SynchronousCrawlEngine) setStatus(url string, status URLStatus) {
+	if n, ok := c.attempts[url]; ok {
+		if status.Attempts == 0 {
+			status.Attempts = 1
+		}
+		status.Attempts += n
+	}
+	c.sm.SetStatus(url, status)
+}
+
+// requeue returns the URLs which failed with a transient error, removing them from the SiteMap so that they are
+// visited again. The attempts already made for each URL are kept so that the final status records the total. URLs are
+// only requeued once, and none are requeued if the context has been cancelled.
+func (c *SynchronousCrawlEngine) requeue(ctx context.Context) []frontierItem {
+	c.failMutex.Lock()
+	failed := c.failed
+	c.failed = nil
+	c.failMutex.Unlock()
+	if len(failed) == 0 || c.attempts != nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		// The failed URLs will not be visited again, so their results are final
+		for _, item := range failed {
+			c.stream.write(c.sm, item.url)
+		}
+		return nil
+	}
+
+	log.Printf("requeueing %d failed URLs", len(failed))
+	c.attempts = make(map[string]int, len(failed))
+	for _, item := range failed {
+		n := 1
+		if s, ok := c.sm.GetStatus(item.url); ok && s.Attempts > 0 {
+			n = s.Attempts
+		}
+		c.attempts[item.url] = n
+		c.sm.removeURL(item.url)
+	}
+	return failed
+}
+
+// filterLinks removes the links which are outside the crawl scope, or which would exceed the query parameter value
+// limit, recording them in the SiteMap as skipped. The links which are kept and the links which were skipped are
+// returned.
+func (c *SynchronousCrawlEngine) filterLinks(urls []string) ([]string, []string) {
+	if c.scope == nil && c.queryLimit == nil {
+		return urls, nil
+	}
+	var kept, skipped []string
+	for _, u := range urls {
+		if c.scope != nil && !c.scope.Allowed(u) {
+			log.Printf("skipping URL %s outside the crawl scope", u)
+			c.sm.AddSkippedURL(u, SkipReasonScope)
+			skipped = append(skipped, u)
+			continue
+		}
+		if c.queryLimit != nil {
+			if _, exists := c.sm.GetLinks(u); !exists && !c.queryLimit.allow(u) {
+				log.Printf("skipping URL %s exceeding the query parameter limit", u)
+				c.sm.AddSkippedURL(u, SkipReasonQueryLimit)
+				skipped = append(skipped, u)
+				continue
+			}
+		}
+		kept = append(kept, u)
+	}
+	return kept, skipped
+}
+
+// removeLinks returns the links which do not appear in the list of links to remove.
+func removeLinks(ls, remove []string) []string {
+	if len(remove) == 0 {
+		return ls
+	}
+	r := make(links, len(remove))
+	for _, l := range remove {
+		r[l] = struct{}{}
+	}
+	var kept []string
+	for _, l := range ls {
+		if _, ok := r[l]; !ok {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
 // cleanLinks accepts a list of links and applies a set of rules to determine whether the links should be included
 // in the sitemap results
-// cleanLinks returns a slice of full URLs strings including scheme, host and path for any applicable links.
-func cleanLinks(links []string, root string, parentUrl *url.URL) []string {
-	var cLinks []string
+// cleanLinks returns a slice of full URLs strings including scheme, host and path for any applicable links, each
+// converted to canonical form by the Normalizer. Links to hosts for which inHost returns false are returned in a
+// second slice of external links.
+func cleanLinks(links []string, root string, parentUrl *url.URL, n *Normalizer, inHost func(host string) bool) ([]string, []string) {
+	var cLinks, external []string
 
 	for _, link := range links {
 
@@ -217,7 +698,7 @@ func cleanLinks(links []string, root string, parentUrl *url.URL) []string {
 			continue
 		}
 
-		if l.Host == "" && (l.Path == "" || l.Path == "/") {
+		if l.Host == "" && l.RawQuery == "" && (l.Path == "" || l.Path == "/") {
 			log.Printf("ignoring link %s", link)
 			continue
 		}
@@ -232,82 +713,61 @@ func cleanLinks(links []string, root string, parentUrl *url.URL) []string {
 		switch {
 		// Relative URL to host
 		case l.Host == "" && strings.HasPrefix(l.Path, "/"):
-			urlLink = &url.URL{Host: parentUrl.Host, Path: l.Path, Scheme: rootUrl.Scheme}
+			urlLink = &url.URL{Host: parentUrl.Host, Path: l.Path, Scheme: rootUrl.Scheme, RawQuery: l.RawQuery}
 		// Relative URL to current parent path, append to current document path
 		case l.Host == "" && l.Path != "" && strings.HasSuffix(parentUrl.Path, "/"):
 			newPath := path.Join(parentUrl.Path, l.Path)
-			urlLink = &url.URL{Host: parentUrl.Host, Path: newPath, Scheme: parentUrl.Scheme}
+			urlLink = &url.URL{Host: parentUrl.Host, Path: newPath, Scheme: parentUrl.Scheme, RawQuery: l.RawQuery}
 		// Relative URL to current path, same depth as current document
 		case l.Host == "" && l.Path != "":
 			li := strings.LastIndex(parentUrl.Path, "/")
 			parentPath := parentUrl.Path[:li+1]
 			newPath := path.Join(parentPath, l.Path)
-			urlLink = &url.URL{Host: parentUrl.Host, Path: newPath, Scheme: parentUrl.Scheme}
-		// Absolute link featuring same host as root
-		case l.Host == rootUrl.Host:
-			urlLink = &url.URL{Host: l.Host, Path: l.Path, Scheme: l.Scheme}
+			urlLink = &url.URL{Host: parentUrl.Host, Path: newPath, Scheme: parentUrl.Scheme, RawQuery: l.RawQuery}
+		// Query only, relative to the current document
+		case l.Host == "" && l.Path == "" && l.RawQuery != "":
+			urlLink = &url.URL{Host: parentUrl.Host, Path: parentUrl.Path, Scheme: parentUrl.Scheme, RawQuery: l.RawQuery}
+		// Absolute link featuring a host within the host scope
+		case inHost(n.NormalizeURL(l).Host):
+			urlLink = &url.URL{Host: l.Host, Path: l.Path, Scheme: schemeOf(l, parentUrl), RawQuery: l.RawQuery}
+		// Absolute link to another host
+		default:
+			el := &url.URL{Host: l.Host, Path: l.Path, Scheme: schemeOf(l, parentUrl), RawQuery: l.RawQuery}
+			external = append(external, n.NormalizeURL(el).String())
 		}
 
 		if urlLink != nil {
-			cLinks = append(cLinks, urlLink.String())
+			cLinks = append(cLinks, n.NormalizeURL(urlLink).String())
 		}
 	}
 
-	return cLinks
+	return cLinks, external
 }
 
-// getHTML visits the provided URL and returns any HTML in the response as a string.
-func getHTML(u string) (string, *url.URL, error) {
-	client := http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(u)
-	if err != nil {
-		return "", nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", resp.Request.URL, fmt.Errorf("received HTTP response code %d for site %s", resp.StatusCode, u)
-	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", resp.Request.URL, errors.New("error reading response body")
+// schemeOf returns the scheme of the link, or the scheme of the parent URL for a scheme relative link such as
+// "//example.com/page".
+func schemeOf(l, parentUrl *url.URL) string {
+	if l.Scheme == "" {
+		return parentUrl.Scheme
 	}
-	return string(b), resp.Request.URL, nil
+	return l.Scheme
 }
 
-// extractLinks applies a regular expression pattern to an HTML string and returns a slice of string links
-// Implementation uses the example from the docs: https://pkg.go.dev/golang.org/x/net/html#example-Parse
-func extractLinks(content string) ([]string, error) {
-	doc, err := html.Parse(strings.NewReader(content))
-	if err != nil {
-		return nil, err
-	}
-
-	// Using a map to build a set of unique links
-	// When we add a new link to the map we also append it to the slice which is returned
-	lm := make(map[string]struct{})
-	var links []string
-
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
-			for _, a := range n.Attr {
-				if a.Key == "href" {
-					v := a.Val
-					v = strings.TrimSpace(v)
-					if _, ok := lm[v]; !ok {
-						lm[v] = struct{}{}
-						links = append(links, v)
-					}
-					break
-				}
-			}
+// resolveAssets returns the absolute URLs of the asset references found on a page. Unlike links, assets on other hosts
+// are kept, as pages commonly load assets from a CDN. References which are not http or https URLs are ignored.
+func resolveAssets(assets []string, base *url.URL) []string {
+	var urls []string
+	for _, a := range assets {
+		u, err := base.Parse(a)
+		if err != nil {
+			log.Printf("error parsing asset %s", a)
+			continue
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
 		}
+		u.Fragment = ""
+		urls = append(urls, u.String())
 	}
-	f(doc)
-
-	return links, nil
+	return urls
 }
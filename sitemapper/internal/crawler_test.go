@@ -1,9 +1,11 @@
 package sitemap
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/matryer/is"
 	"io/ioutil"
 	"log"
@@ -12,22 +14,30 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // startHttpServer is based off the solution for HTTP server graceful shutdown from https://stackoverflow.com/a/42533360
+// The listener is created before returning so that the server is accepting connections before any crawl begins.
 func startHttpServer(wg *sync.WaitGroup) *http.Server {
 	srv := &http.Server{Addr: ":2015"}
 	fs := http.FileServer(http.Dir("../testsite"))
 
 	http.Handle("/", fs)
 
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatalf("Listen(): %v", err)
+	}
+
 	go func() {
 		defer wg.Done()
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe(): %v", err)
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			log.Fatalf("Serve(): %v", err)
 		}
 	}()
 
@@ -37,12 +47,12 @@ func startHttpServer(wg *sync.WaitGroup) *http.Server {
 func TestCrawlEngine_Run(t *testing.T) {
 	// Set up the crawlEngine engines we wish to test
 	smSce := NewSiteMap()
-	sce := NewSynchronousCrawlEngine(smSce, 5, "http://localhost:2015")
+	sce := NewSynchronousCrawlEngine(smSce, 5, "http://localhost:2015/")
 	smCce := NewSiteMap()
-	cce := NewConcurrentCrawlEngine(smCce, 5, "http://localhost:2015")
+	cce := NewConcurrentCrawlEngine(smCce, 5, "http://localhost:2015/")
 	limiter := NewLimiter(1)
 	smClce := NewSiteMap()
-	clce := NewConcurrentLimitedCrawlEngine(smClce, 5, "http://localhost:2015", limiter)
+	clce := NewConcurrentLimitedCrawlEngine(smClce, 5, "http://localhost:2015/", limiter)
 
 	// Run a local HTTP server serving static content pointing to the "testsite" directory
 	httpServerExitDone := &sync.WaitGroup{}
@@ -61,7 +71,7 @@ func TestCrawlEngine_Run(t *testing.T) {
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			d.crawlEngine.Run()
+			d.crawlEngine.Run(context.Background())
 			// NOTE: The expected output represents the JSON that is printed out
 			// when running the program. The internal sitemap data structure is slightly different
 			// in that the list of links for a parent link is a map and not a slice
@@ -123,28 +133,28 @@ func Test_extractLinks(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			html := string(file)
-			links, err := extractLinks(html)
+			ex, err := (&HTMLExtractor{}).ExtractLinks(bytes.NewReader(file))
 			is.NoErr(err)
-			is.Equal(len(links), len(d.links))
-			is.Equal(links, d.links)
+			is.Equal(len(ex.Links), len(d.links))
+			is.Equal(ex.Links, d.links)
 		})
 	}
 }
 
-func Test_getHtml(t *testing.T) {
+func TestHTTPClient_Fetch(t *testing.T) {
 	data := []struct {
 		name         string
 		responseBody string
 		expectedBody string
 		statusCode   int
 		err          error
+		errorClass   string
 	}{
-		{"200 success", "expected body", "expected body", 200, nil},
-		{"200 success empty body", "", "", 200, nil},
-		{"404 error", "", "", 404, errors.New("received HTTP response code 404 for site http://127.0.0.1:")},
-		{"500 error", "expected body", "", 500, errors.New("received HTTP response code 500 for site http://127.0.0.1:")},
-		{"server error", "", "", 500, errors.New("received HTTP response code 500 for site http://127.0.0.1:")},
+		{"200 success", "expected body", "expected body", 200, nil, ""},
+		{"200 success empty body", "", "", 200, nil, ""},
+		{"404 error", "", "", 404, errors.New("received HTTP response code 404 for site http://127.0.0.1:"), ErrorClassHTTPStatus},
+		{"500 error", "expected body", "", 500, errors.New("received HTTP response code 500 for site http://127.0.0.1:"), ErrorClassHTTPStatus},
+		{"server error", "", "", 500, errors.New("received HTTP response code 500 for site http://127.0.0.1:"), ErrorClassHTTPStatus},
 	}
 
 	is := is.New(t)
@@ -153,6 +163,7 @@ func Test_getHtml(t *testing.T) {
 		t.Run(d.name, func(t *testing.T) {
 			var sUrl string
 			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
 				w.WriteHeader(d.statusCode)
 				_, err := w.Write([]byte(d.responseBody))
 				is.NoErr(err)
@@ -161,12 +172,14 @@ func Test_getHtml(t *testing.T) {
 			sUrl = srv.URL
 			defer srv.Close()
 
-			result, requestUrl, err := getHTML(sUrl)
+			res, err := defaultHTTPClient(DefaultUserAgent).Fetch(context.Background(), sUrl)
 
-			is.Equal(result, d.expectedBody)
-			is.Equal(requestUrl.String(), sUrl)
+			is.Equal(res.Content, d.expectedBody)
+			is.Equal(res.URL.String(), sUrl)
+			is.Equal(res.StatusCode, d.statusCode)
 			if d.err != nil {
 				is.True(strings.Contains(err.Error(), d.err.Error()))
+				is.Equal(classifyError(err), d.errorClass)
 			} else {
 				is.NoErr(err)
 			}
@@ -174,15 +187,18 @@ func Test_getHtml(t *testing.T) {
 	}
 }
 
-func Test_getHtml_BadServer(t *testing.T) {
+func TestHTTPClient_Fetch_BadServer(t *testing.T) {
 	is := is.New(t)
 	sUrl := "http://badserver"
-	result, requestUrl, err := getHTML(sUrl)
+	res, err := defaultHTTPClient(DefaultUserAgent).Fetch(context.Background(), sUrl)
 
-	is.Equal(result, "")
-	is.Equal(requestUrl, nil)
+	is.Equal(res.Content, "")
+	is.Equal(res.URL, nil)
+	is.Equal(res.Header, nil)
 	var urlError *net.DNSError
 	is.True(errors.As(err, &urlError))
+	is.Equal(classifyError(err), ErrorClassDNS)
+	is.Equal(res.status().StatusCode, 0)
 }
 
 func Test_cleanLinks(t *testing.T) {
@@ -193,7 +209,7 @@ func Test_cleanLinks(t *testing.T) {
 		inputLinks    []string
 		expectedLinks []string
 	}{
-		{"parent trailing slash", "https://example.com", "https://example.com/parent/",
+		{"parent trailing slash", "https://example.com/", "https://example.com/parent/",
 			[]string{
 				"relative/link/index.html",
 				"/absolute/index.html",
@@ -208,7 +224,7 @@ func Test_cleanLinks(t *testing.T) {
 				"https://example.com/index.html",
 			},
 		},
-		{"parent index.html", "https://example.com", "https://www.example.com/parent/index.html",
+		{"parent index.html", "https://example.com/", "https://www.example.com/parent/index.html",
 			[]string{
 				"relative/link/index.html",
 				"/absolute/index.html",
@@ -224,7 +240,21 @@ func Test_cleanLinks(t *testing.T) {
 				"https://example.com/index.html",
 			},
 		},
-		{"bad link", "https://example.com", "https://example.com/parent/index.html",
+		{"query strings", "https://example.com/", "https://example.com/products/list.html?page=1",
+			[]string{
+				"?page=2",
+				"item.html?id=7&utm_source=feed",
+				"/search?q=shoes&sort=asc",
+				"https://example.com/?lang=en",
+			},
+			[]string{
+				"https://example.com/products/list.html?page=2",
+				"https://example.com/products/item.html?id=7",
+				"https://example.com/search?q=shoes&sort=asc",
+				"https://example.com/?lang=en",
+			},
+		},
+		{"bad link", "https://example.com/", "https://example.com/parent/index.html",
 			[]string{
 				string([]byte{0x7f}),
 			},
@@ -240,7 +270,12 @@ func Test_cleanLinks(t *testing.T) {
 		t.Run(d.name, func(t *testing.T) {
 			p, err := url.Parse(d.parent)
 			is.NoErr(err)
-			cLinks := cleanLinks(d.inputLinks, d.root, p)
+			// The root may be invalid, as cleanLinks must handle a bad root
+			r, err := url.Parse(d.root)
+			if err != nil {
+				r = &url.URL{}
+			}
+			cLinks, _ := cleanLinks(d.inputLinks, d.root, p, NewNormalizer(), HostScope{}.matcher(r))
 			is.Equal(len(cLinks), len(d.expectedLinks))
 			for i, l := range cLinks {
 				is.Equal(l, d.expectedLinks[i])
@@ -256,13 +291,15 @@ func Test_getLinks(t *testing.T) {
 		expectedLinks []string
 		statusCode    int
 		existingLinks bool
+		errorClass    string
 	}{
-		{"existing links returned", `<a href="https://example.com">link</a>`, []string{"https://example.com"}, 200, true},
-		{"links returned", `<a href="https://example.com">link</a>`, []string{"https://example.com"}, 200, false},
-		{"500 error", `<a href="https://example.com">link</a>`, nil, 500, false},
-		{"empty body", "", nil, 200, false},
-		{"no links", "no links here", nil, 200, false},
-		{"no clean links", `<a href="/">link</a>`, nil, 200, false},
+		{"existing links returned", `<a href="https://example.com/">link</a>`, []string{"https://example.com/"}, 200, true, ""},
+		{"links returned", `<a href="https://example.com/">link</a>`, []string{"https://example.com/"}, 200, false, ""},
+		{"404 error", "not found", nil, 404, false, ErrorClassHTTPStatus},
+		{"500 error", `<a href="https://example.com/">link</a>`, nil, 500, false, ErrorClassHTTPStatus},
+		{"empty body", "", nil, 200, false, ""},
+		{"no links", "no links here", nil, 200, false, ""},
+		{"no clean links", `<a href="/">link</a>`, nil, 200, false, ""},
 	}
 
 	is := is.New(t)
@@ -271,6 +308,7 @@ func Test_getLinks(t *testing.T) {
 		t.Run(d.name, func(t *testing.T) {
 			var sUrl string
 			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
 				w.WriteHeader(d.statusCode)
 				_, err := w.Write([]byte(d.responseBody))
 				is.NoErr(err)
@@ -279,7 +317,7 @@ func Test_getLinks(t *testing.T) {
 			sUrl = srv.URL
 			defer srv.Close()
 
-			root := "https://example.com"
+			root := "https://example.com/"
 			parent := "https://example.com/foo/"
 			depth := 1
 			sm := NewSiteMap()
@@ -288,9 +326,211 @@ func Test_getLinks(t *testing.T) {
 				sm.UpdateURLWithLinks(srv.URL, d.expectedLinks)
 			}
 
-			links, _ := getLinks(sUrl, root, parent, depth, sm)
+			c := NewSynchronousCrawlEngine(sm, depth, root)
+			links, _ := c.getLinks(context.Background(), sUrl, root, parent, depth)
 
 			is.Equal(links, d.expectedLinks)
+			status, ok := sm.GetStatus(sUrl)
+			is.Equal(ok, !d.existingLinks) // a status is only recorded when the URL is fetched
+			if ok {
+				is.Equal(status.StatusCode, d.statusCode)
+				is.Equal(status.FinalURL, sUrl)
+				is.Equal(status.ErrorClass, d.errorClass)
+				is.Equal(status.ContentType, "text/html")
+			}
+		})
+	}
+}
+
+func TestConcurrentLimitedCrawlEngine_DepthCounts(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.FileServer(http.Dir("../testsite")))
+	defer srv.Close()
+
+	sm := NewSiteMap()
+	c := NewConcurrentLimitedCrawlEngine(sm, 5, srv.URL, NewLimiter(3))
+	c.Run(context.Background())
+
+	// The testsite index links to 3 pages, which link to 3 further pages. Each URL is counted at its minimum depth,
+	// whichever worker finds it first
+	is.Equal(c.DepthCounts(), []int{1, 3, 3, 0, 0})
+	total := 0
+	for _, n := range c.DepthCounts() {
+		total += n
+	}
+	is.Equal(total, len(sm.lm))
+}
+
+// generatedSite returns a handler for a synthetic site in which page n links to pages n*fanout+1 to n*fanout+fanout,
+// as long as those pages are below the page limit. Every page also links back to the index, to create duplicate links.
+func generatedSite(pages, fanout int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 0
+		if r.URL.Path != "/" {
+			if _, err := fmt.Sscanf(r.URL.Path, "/page%d.html", &n); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		fmt.Fprint(w, `<html><body><a href="/">index</a>`)
+		for i := n*fanout + 1; i <= n*fanout+fanout && i < pages; i++ {
+			fmt.Fprintf(w, `<a href="/page%d.html">page %d</a>`, i, i)
+		}
+		fmt.Fprint(w, `</body></html>`)
+	})
+}
+
+// BenchmarkCrawlEngines compares the crawl engines against the testsite fixture and a larger generated site,
+// reporting the peak number of goroutines observed during each crawl.
+func BenchmarkCrawlEngines(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	sites := []struct {
+		name    string
+		handler http.Handler
+		depth   int
+	}{
+		{"testsite", http.FileServer(http.Dir("../testsite")), 5},
+		{"generated", generatedSite(2000, 8), 5},
+	}
+
+	engines := []struct {
+		name string
+		new  func(sm *SiteMap, depth int, startURL string) CrawlEngine
+	}{
+		{"synchronous", func(sm *SiteMap, depth int, startURL string) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, depth, startURL)
+		}},
+		{"concurrent", func(sm *SiteMap, depth int, startURL string) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, depth, startURL)
+		}},
+		{"limited", func(sm *SiteMap, depth int, startURL string) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, depth, startURL, NewLimiter(10))
+		}},
+	}
+
+	for _, s := range sites {
+		srv := httptest.NewServer(s.handler)
+		for _, e := range engines {
+			b.Run(s.name+"/"+e.name, func(b *testing.B) {
+				peak := 0
+				for i := 0; i < b.N; i++ {
+					stop := make(chan struct{})
+					sampled := make(chan int)
+					go func() {
+						max := 0
+						ticker := time.NewTicker(time.Millisecond)
+						defer ticker.Stop()
+						for {
+							if n := runtime.NumGoroutine(); n > max {
+								max = n
+							}
+							select {
+							case <-stop:
+								sampled <- max
+								return
+							case <-ticker.C:
+							}
+						}
+					}()
+
+					e.new(NewSiteMap(), s.depth, srv.URL).Run(context.Background())
+
+					close(stop)
+					if n := <-sampled; n > peak {
+						peak = n
+					}
+				}
+				b.ReportMetric(float64(peak), "peak-goroutines")
+			})
+		}
+		srv.Close()
+	}
+}
+
+func TestCrawlEngine_RunCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/slow1.html">1</a><a href="/slow2.html">2</a><a href="/slow3.html">3</a>`)
+			return
+		}
+		// Pages other than the index never respond, so the crawl can only finish when the context is cancelled
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	data := []struct {
+		name        string
+		crawlEngine func(sm *SiteMap) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap) CrawlEngine { return NewSynchronousCrawlEngine(sm, 3, srv.URL) }},
+		{"Concurrent crawl engine", func(sm *SiteMap) CrawlEngine { return NewConcurrentCrawlEngine(sm, 3, srv.URL) }},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 3, srv.URL, NewLimiter(2))
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			sm, truncated := d.crawlEngine(NewSiteMap()).Run(ctx)
+			is.True(time.Since(start) < 4*time.Second) // the slow pages must be abandoned when the context expires
+			is.True(truncated)
+			is.True(sm.Truncated())
+			links, visited := sm.GetLinks(srv.URL)
+			is.True(visited)
+			is.Equal(len(links), 3)
+			status, ok := sm.GetStatus(srv.URL + "/slow1.html")
+			is.True(ok)
+			is.Equal(status.ErrorClass, ErrorClassTimeout)
+		})
+	}
+}
+
+func TestCrawlEngine_Discovered(t *testing.T) {
+	expected := map[string]Discovered{
+		"http://example.com/":        {Depth: 0},
+		"http://example.com/a":       {Depth: 1, Parent: "http://example.com/"},
+		"http://example.com/b":       {Depth: 1, Parent: "http://example.com/"},
+		"http://example.com/c":       {Depth: 2, Parent: "http://example.com/a"},
+		"http://example.com/missing": {Depth: 3, Parent: "http://example.com/c"},
+	}
+
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 5, "http://example.com/", NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			start := time.Now()
+			sm, _ := d.engine(NewSiteMap(), WithFetcher(testMapSite)).Run(context.Background())
+			for u, e := range expected {
+				found, ok := sm.GetDiscovered(u)
+				is.True(ok)
+				is.Equal(found.Depth, e.Depth)
+				is.Equal(found.Parent, e.Parent)
+				is.True(!found.Time.Before(start))
+			}
 		})
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/google/uuid"
 	"log"
 	"math/rand"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -16,12 +17,35 @@ type CrawlManager struct {
 
 func (cm *CrawlManager) HandleStartMessage(s *StartMessage) {
 	log.Printf("[Start] %v", *s)
-	err := cm.CassDB.WriteSitemap(s.SitemapID, s.URL, s.MaxDepth)
+	sitemapID, err := uuid.Parse(s.SitemapID)
 	if err != nil {
 		log.Print(err)
 		return
 	}
-	sitemapID, err := uuid.Parse(s.SitemapID)
+
+	// Credentials are moved into a Secret so that they are neither stored with the sitemap nor passed to each job
+	opts := s.Options
+	if opts.Client != nil && opts.Client.HasCredentials() {
+		name, err := cm.JobManager.CreateCredentialsSecret(sitemapID, *opts.Client)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		c := opts.Client.WithoutCredentials()
+		opts.Client = &c
+		opts.CredentialsSecret = name
+	}
+	// Each job crawls a single URL, so the credentials are limited to the start host of the sitemap rather than that of
+	// the job
+	if opts.Client != nil && len(opts.Client.CredentialHosts) == 0 {
+		if u, err := url.Parse(s.URL); err == nil {
+			c := *opts.Client
+			c.CredentialHosts = []string{u.Host}
+			opts.Client = &c
+		}
+	}
+
+	err = cm.CassDB.WriteSitemap(s.SitemapID, s.URL, s.MaxDepth, opts)
 	if err != nil {
 		log.Print(err)
 		return
@@ -33,7 +57,7 @@ func (cm *CrawlManager) HandleStartMessage(s *StartMessage) {
 		return
 	}
 
-	err = cm.NatsManager.SendCrawlMessage(crawlID, sitemapID, s.URL, 1)
+	err = cm.NatsManager.SendCrawlMessage(crawlID, sitemapID, s.URL, 1, "", opts)
 	if err != nil {
 		log.Print(err)
 		return
@@ -79,12 +103,13 @@ func (cm *CrawlManager) HandleCrawlMessage(c *CrawlMessage) {
 			return
 		}
 
-		err = cm.JobManager.CreateJob(crawlID, c.URL)
+		// Crawl messages count depth from 1, while results count from 0 at the start URL
+		err = cm.JobManager.CreateJob(crawlID, c.URL, c.CurrentDepth-1, c.Parent, c.Options)
 		if err != nil {
 			if strings.Contains(err.Error(), "exceeded quota") {
 				log.Printf("Too many jobs, re-flighting message for crawl ID: %s\n", c.CrawlID)
 				time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
-				err = cm.NatsManager.SendCrawlMessage(crawlID, sitemapID, c.URL, c.CurrentDepth)
+				err = cm.NatsManager.SendCrawlMessage(crawlID, sitemapID, c.URL, c.CurrentDepth, c.Parent, c.Options)
 				if err != nil {
 					log.Print(err)
 				}
@@ -115,8 +140,14 @@ func (cm *CrawlManager) HandleResultsMessage(r *ResultsMessage) {
 		return
 	}
 
+	opts, err := cm.CassDB.GetCrawlOptionsForSitemapID(cj.SitemapID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
 	for _, rs := range r.Results {
-		err := cm.CassDB.WriteResults(cj.SitemapID, cj.CrawlID, rs.URL, rs.Links)
+		err := cm.CassDB.WriteResults(cj.SitemapID, cj.CrawlID, rs)
 		if err != nil {
 			log.Print(err)
 			continue
@@ -131,7 +162,7 @@ func (cm *CrawlManager) HandleResultsMessage(r *ResultsMessage) {
 					return
 				}
 
-				err = cm.NatsManager.SendCrawlMessage(newCrawlID, cj.SitemapID, link, nextDepth)
+				err = cm.NatsManager.SendCrawlMessage(newCrawlID, cj.SitemapID, link, nextDepth, rs.URL, *opts)
 				if err != nil {
 					log.Print(err)
 					return
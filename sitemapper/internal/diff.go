@@ -0,0 +1,222 @@
+package sitemap
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A LinkChange reports a URL found in both crawls whose outbound links changed. Added holds the links found only in the
+// new crawl and Removed the links found only in the old crawl, each sorted.
+type LinkChange struct {
+	URL     string
+	Added   []string
+	Removed []string
+}
+
+// A StatusChange reports a URL found in both crawls whose status code or error class changed.
+type StatusChange struct {
+	URL           string
+	OldStatusCode int
+	NewStatusCode int
+	OldErrorClass string `json:",omitempty"`
+	NewErrorClass string `json:",omitempty"`
+}
+
+// A CrawlDiff reports what changed between two crawls of a site: the URLs crawled only in the new crawl (Added) or
+// only in the old crawl (Removed), and the URLs crawled in both whose links or status changed. Each list is sorted by
+// URL.
+type CrawlDiff struct {
+	Added         []string
+	Removed       []string
+	LinksChanged  []LinkChange
+	StatusChanged []StatusChange
+}
+
+// DiffResults compares the results of an old and a new crawl. Links are compared as sets, so a change in the order or
+// number of times a page links to a URL is not reported.
+func DiffResults(older, newer []Result) CrawlDiff {
+	d := CrawlDiff{
+		Added:         make([]string, 0),
+		Removed:       make([]string, 0),
+		LinksChanged:  make([]LinkChange, 0),
+		StatusChanged: make([]StatusChange, 0),
+	}
+	before := make(map[string]Result, len(older))
+	for _, r := range older {
+		before[r.URL] = r
+	}
+	after := make(map[string]Result, len(newer))
+	for _, r := range newer {
+		after[r.URL] = r
+	}
+
+	for u := range before {
+		if _, ok := after[u]; !ok {
+			d.Removed = append(d.Removed, u)
+		}
+	}
+	for u, n := range after {
+		o, ok := before[u]
+		if !ok {
+			d.Added = append(d.Added, u)
+			continue
+		}
+		added, removed := diffLinks(o.Links, n.Links)
+		if len(added) > 0 || len(removed) > 0 {
+			d.LinksChanged = append(d.LinksChanged, LinkChange{URL: u, Added: added, Removed: removed})
+		}
+		if o.StatusCode != n.StatusCode || o.ErrorClass != n.ErrorClass {
+			d.StatusChanged = append(d.StatusChanged, StatusChange{
+				URL:           u,
+				OldStatusCode: o.StatusCode,
+				NewStatusCode: n.StatusCode,
+				OldErrorClass: o.ErrorClass,
+				NewErrorClass: n.ErrorClass,
+			})
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.LinksChanged, func(i, j int) bool { return d.LinksChanged[i].URL < d.LinksChanged[j].URL })
+	sort.Slice(d.StatusChanged, func(i, j int) bool { return d.StatusChanged[i].URL < d.StatusChanged[j].URL })
+
+	return d
+}
+
+// diffLinks returns the sorted links found only in the newer links, and those found only in the older links.
+func diffLinks(older, newer []string) ([]string, []string) {
+	before := make(links, len(older))
+	for _, l := range older {
+		before[l] = struct{}{}
+	}
+	after := make(links, len(newer))
+	for _, l := range newer {
+		after[l] = struct{}{}
+	}
+
+	added, removed := make([]string, 0), make([]string, 0)
+	for l := range after {
+		if _, ok := before[l]; !ok {
+			added = append(added, l)
+		}
+	}
+	for l := range before {
+		if _, ok := after[l]; !ok {
+			removed = append(removed, l)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// statusText describes a status code and error class, such as "404 http-status", or "- timeout" for a fetch which
+// received no response.
+func statusText(code int, class string) string {
+	s := "-"
+	if code != 0 {
+		s = strconv.Itoa(code)
+	}
+	if class != "" {
+		s += " " + class
+	}
+	return s
+}
+
+// summary returns a single line giving the number of changes of each kind.
+func (d CrawlDiff) summary() string {
+	return fmt.Sprintf("added: %d, removed: %d, links changed: %d, status changed: %d",
+		len(d.Added), len(d.Removed), len(d.LinksChanged), len(d.StatusChanged))
+}
+
+// WriteCrawlDiffText writes a human-readable report of the changes between two crawls, with a summary line followed
+// by a section for each kind of change. Added URLs and links are marked with +, removed ones with - and changed URLs
+// with ~.
+func WriteCrawlDiffText(w io.Writer, d CrawlDiff) error {
+	var b strings.Builder
+	b.WriteString(d.summary() + "\n")
+	if len(d.Added) > 0 {
+		b.WriteString("\nAdded URLs:\n")
+		for _, u := range d.Added {
+			fmt.Fprintf(&b, "+ %s\n", u)
+		}
+	}
+	if len(d.Removed) > 0 {
+		b.WriteString("\nRemoved URLs:\n")
+		for _, u := range d.Removed {
+			fmt.Fprintf(&b, "- %s\n", u)
+		}
+	}
+	if len(d.LinksChanged) > 0 {
+		b.WriteString("\nLinks changed:\n")
+		for _, c := range d.LinksChanged {
+			fmt.Fprintf(&b, "~ %s\n", c.URL)
+			for _, l := range c.Added {
+				fmt.Fprintf(&b, "    + %s\n", l)
+			}
+			for _, l := range c.Removed {
+				fmt.Fprintf(&b, "    - %s\n", l)
+			}
+		}
+	}
+	if len(d.StatusChanged) > 0 {
+		b.WriteString("\nStatus changed:\n")
+		for _, c := range d.StatusChanged {
+			fmt.Fprintf(&b, "~ %s: %s -> %s\n", c.URL, statusText(c.OldStatusCode, c.OldErrorClass),
+				statusText(c.NewStatusCode, c.NewErrorClass))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteCrawlDiffMarkdown writes the changes between two crawls as a Markdown document, suitable for a report or an
+// issue comment, with a summary followed by a section for each kind of change found.
+func WriteCrawlDiffMarkdown(w io.Writer, d CrawlDiff) error {
+	var b strings.Builder
+	b.WriteString("# Crawl diff\n\n")
+	b.WriteString("| Added | Removed | Links changed | Status changed |\n")
+	b.WriteString("| ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d |\n", len(d.Added), len(d.Removed), len(d.LinksChanged), len(d.StatusChanged))
+	if len(d.Added) > 0 {
+		b.WriteString("\n## Added URLs\n\n")
+		for _, u := range d.Added {
+			fmt.Fprintf(&b, "- %s\n", u)
+		}
+	}
+	if len(d.Removed) > 0 {
+		b.WriteString("\n## Removed URLs\n\n")
+		for _, u := range d.Removed {
+			fmt.Fprintf(&b, "- %s\n", u)
+		}
+	}
+	if len(d.LinksChanged) > 0 {
+		b.WriteString("\n## Links changed\n")
+		for _, c := range d.LinksChanged {
+			fmt.Fprintf(&b, "\n### %s\n\n", c.URL)
+			for _, l := range c.Added {
+				fmt.Fprintf(&b, "- added %s\n", l)
+			}
+			for _, l := range c.Removed {
+				fmt.Fprintf(&b, "- removed %s\n", l)
+			}
+		}
+	}
+	if len(d.StatusChanged) > 0 {
+		b.WriteString("\n## Status changed\n\n")
+		b.WriteString("| URL | Old | New |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, c := range d.StatusChanged {
+			// A pipe in the URL would end the table cell
+			u := strings.ReplaceAll(c.URL, "|", `\|`)
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", u, statusText(c.OldStatusCode, c.OldErrorClass),
+				statusText(c.NewStatusCode, c.NewErrorClass))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,95 @@
+package sitemap
+
+import (
+	"bytes"
+	"github.com/matryer/is"
+	"testing"
+)
+
+var testOldCrawl = []Result{
+	{URL: "http://example.com/", Links: []string{"http://example.com/a", "http://example.com/b"}, URLStatus: URLStatus{StatusCode: 200}},
+	{URL: "http://example.com/a", Links: []string{"http://example.com/b"}, URLStatus: URLStatus{StatusCode: 200}},
+	{URL: "http://example.com/b", URLStatus: URLStatus{StatusCode: 200}},
+	{URL: "http://example.com/old", URLStatus: URLStatus{StatusCode: 200}},
+}
+
+var testNewCrawl = []Result{
+	{URL: "http://example.com/", Links: []string{"http://example.com/b", "http://example.com/a", "http://example.com/a"}, URLStatus: URLStatus{StatusCode: 200}},
+	{URL: "http://example.com/a", Links: []string{"http://example.com/c"}, URLStatus: URLStatus{StatusCode: 200}},
+	{URL: "http://example.com/b", URLStatus: URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus}},
+	{URL: "http://example.com/c", URLStatus: URLStatus{ErrorClass: ErrorClassTimeout}},
+}
+
+func TestDiffResults(t *testing.T) {
+	is := is.New(t)
+	d := DiffResults(testOldCrawl, testNewCrawl)
+	is.Equal(d.Added, []string{"http://example.com/c"})
+	is.Equal(d.Removed, []string{"http://example.com/old"})
+	// The order and repetition of links on the start URL are ignored
+	is.Equal(d.LinksChanged, []LinkChange{{URL: "http://example.com/a", Added: []string{"http://example.com/c"}, Removed: []string{"http://example.com/b"}}})
+	is.Equal(d.StatusChanged, []StatusChange{{URL: "http://example.com/b", OldStatusCode: 200, NewStatusCode: 404, NewErrorClass: ErrorClassHTTPStatus}})
+
+	same := DiffResults(testOldCrawl, testOldCrawl)
+	is.Equal(same, CrawlDiff{Added: []string{}, Removed: []string{}, LinksChanged: []LinkChange{}, StatusChanged: []StatusChange{}})
+}
+
+func TestWriteCrawlDiff(t *testing.T) {
+	is := is.New(t)
+	d := DiffResults(testOldCrawl, testNewCrawl)
+	d.StatusChanged = append(d.StatusChanged, StatusChange{URL: "http://example.com/c", OldStatusCode: 200, NewErrorClass: ErrorClassTimeout})
+
+	var b bytes.Buffer
+	is.NoErr(WriteCrawlDiffText(&b, d))
+	is.Equal(b.String(), `added: 1, removed: 1, links changed: 1, status changed: 2
+
+Added URLs:
++ http://example.com/c
+
+Removed URLs:
+- http://example.com/old
+
+Links changed:
+~ http://example.com/a
+    + http://example.com/c
+    - http://example.com/b
+
+Status changed:
+~ http://example.com/b: 200 -> 404 http-status
+~ http://example.com/c: 200 -> - timeout
+`)
+
+	b.Reset()
+	is.NoErr(WriteCrawlDiffMarkdown(&b, d))
+	is.Equal(b.String(), `# Crawl diff
+
+| Added | Removed | Links changed | Status changed |
+| ---: | ---: | ---: | ---: |
+| 1 | 1 | 1 | 2 |
+
+## Added URLs
+
+- http://example.com/c
+
+## Removed URLs
+
+- http://example.com/old
+
+## Links changed
+
+### http://example.com/a
+
+- added http://example.com/c
+- removed http://example.com/b
+
+## Status changed
+
+| URL | Old | New |
+| --- | --- | --- |
+| http://example.com/b | 200 | 404 http-status |
+| http://example.com/c | 200 | - timeout |
+`)
+
+	b.Reset()
+	is.NoErr(WriteCrawlDiffText(&b, DiffResults(testOldCrawl, testOldCrawl)))
+	is.Equal(b.String(), "added: 0, removed: 0, links changed: 0, status changed: 0\n")
+}
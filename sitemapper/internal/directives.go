@@ -0,0 +1,74 @@
+package sitemap
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Robots directives which affect the crawl and the generated sitemap. The "none" directive is equivalent to both.
+const (
+	DirectiveNoIndex  = "noindex"
+	DirectiveNoFollow = "nofollow"
+	DirectiveNone     = "none"
+)
+
+// parseDirectives splits the content of a robots meta element or X-Robots-Tag header into lower case directives.
+func parseDirectives(content string) []string {
+	var ds []string
+	for _, d := range strings.Split(content, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// robotsTagDirectives returns the directives in the X-Robots-Tag headers of a response which apply to the user agent.
+// A header value may be prefixed with a user agent, as in "googlebot: noindex", in which case it only applies to
+// that user agent.
+func robotsTagDirectives(h http.Header, userAgent string) []string {
+	var ds []string
+	for _, v := range h.Values("X-Robots-Tag") {
+		if i := strings.Index(v, ":"); i >= 0 {
+			prefix := strings.ToLower(strings.TrimSpace(v[:i]))
+			// unavailable_after is the only directive which itself contains a colon
+			if !strings.HasPrefix(prefix, "unavailable_after") && !strings.Contains(prefix, ",") {
+				if prefix != productToken(userAgent) {
+					continue
+				}
+				v = v[i+1:]
+			}
+		}
+		ds = append(ds, parseDirectives(v)...)
+	}
+	return ds
+}
+
+// mergeDirectives returns the unique directives from each of the lists, sorted.
+func mergeDirectives(lists ...[]string) []string {
+	seen := map[string]struct{}{}
+	var ds []string
+	for _, l := range lists {
+		for _, d := range l {
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = struct{}{}
+			ds = append(ds, d)
+		}
+	}
+	sort.Strings(ds)
+	return ds
+}
+
+// hasDirective returns true if the directives include the given directive, either directly or through "none".
+func hasDirective(ds []string, directive string) bool {
+	for _, d := range ds {
+		if d == directive || (d == DirectiveNone && (directive == DirectiveNoIndex || directive == DirectiveNoFollow)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_robotsTagDirectives(t *testing.T) {
+	data := []struct {
+		name       string
+		values     []string
+		directives []string
+	}{
+		{"none", nil, nil},
+		{"single value", []string{"noindex, nofollow"}, []string{"noindex", "nofollow"}},
+		{"multiple headers", []string{"noindex", "NoArchive"}, []string{"noindex", "noarchive"}},
+		{"other user agent", []string{"googlebot: noindex", "nofollow"}, []string{"nofollow"}},
+		{"this user agent", []string{"sitemapper: noindex"}, []string{"noindex"}},
+		{"unavailable_after", []string{"unavailable_after: 25 Jun 2010 15:00:00 PST"}, []string{"unavailable_after: 25 jun 2010 15:00:00 pst"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			h := http.Header{}
+			for _, v := range d.values {
+				h.Add("X-Robots-Tag", v)
+			}
+			is.Equal(robotsTagDirectives(h, DefaultUserAgent), d.directives)
+		})
+	}
+}
+
+func Test_hasDirective(t *testing.T) {
+	is := is.New(t)
+	is.True(hasDirective([]string{"noarchive", "noindex"}, DirectiveNoIndex))
+	is.True(!hasDirective([]string{"noindex"}, DirectiveNoFollow))
+	is.True(hasDirective([]string{"none"}, DirectiveNoFollow))
+	is.True(hasDirective([]string{"none"}, DirectiveNoIndex))
+}
+
+func TestHTMLExtractor_Directives(t *testing.T) {
+	is := is.New(t)
+	content := `<html><head><meta name="robots" content="NoIndex"><meta name="sitemapper" content="noarchive, noindex">
+<meta name="googlebot" content="nofollow"></head>
+<body><a href="/a.html" rel="nofollow">a</a><a href="/b.html" rel="nofollow">b</a><a href="/b.html">b again</a></body></html>`
+
+	ex, err := (&HTMLExtractor{}).ExtractLinks(strings.NewReader(content))
+	is.NoErr(err)
+	is.Equal(ex.Links, []string{"/a.html", "/b.html"})
+	is.Equal(ex.NoFollow, []string{"/a.html"}) // b.html is also linked without nofollow
+	is.Equal(ex.Robots, []string{"noarchive", "noindex"})
+}
+
+func TestHTMLExtractor_DirectivesUserAgent(t *testing.T) {
+	content := `<meta name="sitemapper" content="noindex"><meta name="mybot" content="nofollow">`
+	data := []struct {
+		name      string
+		userAgent string
+		expected  []string
+	}{
+		{"default user agent", "", []string{"noindex"}},
+		{"configured user agent", "MyBot/2.0 (+https://example.com/bot)", []string{"nofollow"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			ex, err := (&HTMLExtractor{UserAgent: d.userAgent}).ExtractLinks(strings.NewReader(content))
+			is.NoErr(err)
+			is.Equal(ex.Robots, d.expected)
+		})
+	}
+}
+
+func TestCrawlEngine_RobotsDirectives(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/private.html">private</a><a href="/nofollow.html" rel="nofollow">nofollow</a>`)
+		case "/private.html":
+			w.Header().Set("X-Robots-Tag", "noindex")
+			fmt.Fprint(w, `<a href="/linked-from-private.html">linked</a>`)
+		case "/nofollow.html":
+			fmt.Fprint(w, `<meta name="robots" content="nofollow"><a href="/never.html">never</a>`)
+		default:
+			fmt.Fprint(w, "<p>page</p>")
+		}
+	}))
+	defer srv.Close()
+
+	data := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{"nofollow ignored", nil,
+			[]string{"/", "/linked-from-private.html", "/never.html", "/nofollow.html", "/private.html"}},
+		{"nofollow respected", []Option{WithNoFollow()},
+			[]string{"/", "/linked-from-private.html", "/private.html"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			sm := NewSiteMap()
+			NewSynchronousCrawlEngine(sm, 3, srv.URL+"/", d.opts...).Run(context.Background())
+
+			var crawled []string
+			for _, u := range []string{"/", "/linked-from-private.html", "/never.html", "/nofollow.html", "/private.html"} {
+				if _, ok := sm.GetLinks(srv.URL + u); ok {
+					crawled = append(crawled, u)
+				}
+			}
+			is.Equal(crawled, d.expected)
+			is.Equal(sm.GetRobotsDirectives(srv.URL+"/private.html"), []string{DirectiveNoIndex})
+
+			// The noindex page is traversed but excluded from the sitemap
+			for _, u := range sm.SitemapURLs() {
+				is.True(u.Loc != srv.URL+"/private.html")
+			}
+			is.Equal(len(sm.SitemapURLs()), len(d.expected)-1)
+		})
+	}
+}
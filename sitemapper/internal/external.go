@@ -0,0 +1,155 @@
+package sitemap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// An ExternalDomain aggregates the links from crawled pages to a domain outside the crawl. Domain is the registrable
+// domain of the links, such as "example.com" for "https://www.example.com/page", or the host name for hosts without
+// one, such as IP addresses. Count is the number of references to the domain, counting each link once per page, and
+// Sources lists the pages which link to the domain.
+type ExternalDomain struct {
+	Domain  string
+	Count   int
+	Sources []string
+	Links   []ExternalLink
+}
+
+// An ExternalLink is a URL outside the crawl along with the pages which link to it.
+type ExternalLink struct {
+	URL     string
+	Sources []string
+}
+
+// ExternalDomains returns the external links recorded during the crawl, aggregated by domain.
+func (sm *SiteMap) ExternalDomains() []ExternalDomain {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return FindExternalDomains(sm.externalResults())
+}
+
+// externalResults returns a Result holding the external links of each page which has any. The caller must hold the
+// mutex.
+func (sm *SiteMap) externalResults() []Result {
+	results := make([]Result, 0, len(sm.external))
+	for u, ls := range sm.external {
+		r := Result{URL: u}
+		for l := range ls {
+			r.External = append(r.External, l)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// FindExternalDomains aggregates the external links in a set of crawl results by domain. Domains are sorted by the
+// number of references, most first, and then by name. The links for each domain and all sources are sorted by URL.
+func FindExternalDomains(results []Result) []ExternalDomain {
+	sources := map[string]map[string]struct{}{}
+	for _, r := range results {
+		for _, l := range r.External {
+			if sources[l] == nil {
+				sources[l] = map[string]struct{}{}
+			}
+			sources[l][r.URL] = struct{}{}
+		}
+	}
+
+	byDomain := map[string]*ExternalDomain{}
+	domainSources := map[string]map[string]struct{}{}
+	for l, s := range sources {
+		d := externalDomain(l)
+		ed, ok := byDomain[d]
+		if !ok {
+			ed = &ExternalDomain{Domain: d}
+			byDomain[d] = ed
+			domainSources[d] = map[string]struct{}{}
+		}
+		el := ExternalLink{URL: l, Sources: sortedKeys(s)}
+		ed.Links = append(ed.Links, el)
+		ed.Count += len(el.Sources)
+		for u := range s {
+			domainSources[d][u] = struct{}{}
+		}
+	}
+
+	domains := make([]ExternalDomain, 0, len(byDomain))
+	for d, ed := range byDomain {
+		sort.Slice(ed.Links, func(i, j int) bool { return ed.Links[i].URL < ed.Links[j].URL })
+		ed.Sources = sortedKeys(domainSources[d])
+		domains = append(domains, *ed)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Count != domains[j].Count {
+			return domains[i].Count > domains[j].Count
+		}
+		return domains[i].Domain < domains[j].Domain
+	})
+
+	return domains
+}
+
+// externalDomain returns the registrable domain of a URL, or its host name if it has no registrable domain.
+func externalDomain(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	host := strings.ToLower(pu.Hostname())
+	if d, ok := registrableDomain(host); ok {
+		return d
+	}
+	return host
+}
+
+// sortedKeys returns the keys of a set, sorted.
+func sortedKeys(s map[string]struct{}) []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteExternalDomainsTable writes a human-readable summary of the external domains, with one row per domain giving
+// the number of references, distinct URLs and linking pages.
+func WriteExternalDomainsTable(w io.Writer, domains []ExternalDomain) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "DOMAIN\tLINKS\tURLS\tPAGES"); err != nil {
+		return err
+	}
+	for _, d := range domains {
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", d.Domain, d.Count, len(d.Links), len(d.Sources)); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteExternalDomainsCSV writes the external links as CSV with a header row, and one row per link from each source
+// page.
+func WriteExternalDomainsCSV(w io.Writer, domains []ExternalDomain) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"domain", "domain_count", "url", "source"}); err != nil {
+		return err
+	}
+	for _, d := range domains {
+		for _, l := range d.Links {
+			for _, s := range l.Sources {
+				if err := cw.Write([]string{d.Domain, strconv.Itoa(d.Count), l.URL, s}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
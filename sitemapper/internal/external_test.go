@@ -0,0 +1,95 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/matryer/is"
+	"testing"
+)
+
+func testExternalSiteMap() *SiteMap {
+	sm := NewSiteMap()
+	sm.AddURL("https://example.com/")
+	sm.UpdateURLWithExternalLinks("https://example.com/", []string{"https://www.other.com/a", "https://docs.other.com/b", "http://10.0.0.1/c"})
+	sm.AddURL("https://example.com/about.html")
+	sm.UpdateURLWithExternalLinks("https://example.com/about.html", []string{"https://www.other.com/a"})
+	return sm
+}
+
+func TestSiteMap_ExternalDomains(t *testing.T) {
+	is := is.New(t)
+	domains := testExternalSiteMap().ExternalDomains()
+	is.Equal(domains, []ExternalDomain{
+		{
+			Domain:  "other.com",
+			Count:   3,
+			Sources: []string{"https://example.com/", "https://example.com/about.html"},
+			Links: []ExternalLink{
+				{URL: "https://docs.other.com/b", Sources: []string{"https://example.com/"}},
+				{URL: "https://www.other.com/a", Sources: []string{"https://example.com/", "https://example.com/about.html"}},
+			},
+		},
+		{
+			Domain:  "10.0.0.1",
+			Count:   1,
+			Sources: []string{"https://example.com/"},
+			Links:   []ExternalLink{{URL: "http://10.0.0.1/c", Sources: []string{"https://example.com/"}}},
+		},
+	})
+}
+
+func TestSiteMap_EncodeExternal(t *testing.T) {
+	is := is.New(t)
+	b, err := json.Marshal(testExternalSiteMap())
+	is.NoErr(err)
+
+	var out struct {
+		Results []struct {
+			URL      string
+			External []string
+		}
+		ExternalDomains []ExternalDomain
+	}
+	is.NoErr(json.Unmarshal(b, &out))
+	is.Equal(len(out.ExternalDomains), 2)
+	for _, r := range out.Results {
+		if r.URL == "https://example.com/about.html" {
+			is.Equal(r.External, []string{"https://www.other.com/a"})
+		}
+	}
+
+	// The external section is left out when no external links were recorded
+	b, err = json.Marshal(NewSiteMap())
+	is.NoErr(err)
+	is.True(!bytes.Contains(b, []byte("ExternalDomains")))
+}
+
+func TestWriteExternalDomains(t *testing.T) {
+	domains := testExternalSiteMap().ExternalDomains()
+
+	data := []struct {
+		name     string
+		write    func(b *bytes.Buffer) error
+		expected string
+	}{
+		{"table", func(b *bytes.Buffer) error { return WriteExternalDomainsTable(b, domains) },
+			"DOMAIN     LINKS  URLS  PAGES\n" +
+				"other.com  3      2     2\n" +
+				"10.0.0.1   1      1     1\n"},
+		{"csv", func(b *bytes.Buffer) error { return WriteExternalDomainsCSV(b, domains) },
+			"domain,domain_count,url,source\n" +
+				"other.com,3,https://docs.other.com/b,https://example.com/\n" +
+				"other.com,3,https://www.other.com/a,https://example.com/\n" +
+				"other.com,3,https://www.other.com/a,https://example.com/about.html\n" +
+				"10.0.0.1,1,http://10.0.0.1/c,https://example.com/\n"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			var b bytes.Buffer
+			is.NoErr(d.write(&b))
+			is.Equal(b.String(), d.expected)
+		})
+	}
+}
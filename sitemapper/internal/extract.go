@@ -0,0 +1,247 @@
+package sitemap
+
+import (
+	"golang.org/x/net/html"
+	"io"
+	"strings"
+)
+
+// Extracted holds the references found in a page by a LinkExtractor. Links are URLs which may be crawled, and Assets
+// are the images, scripts and stylesheets used by the page, which are recorded but never crawled. Base is the value of
+// the page's <base href> element, if any, against which relative references are resolved.
+// NoFollow lists the links which were only ever marked rel="nofollow", and Robots holds the directives from the page's
+// robots meta elements. Canonical is the URL declared by the page's <link rel="canonical"> element, if any.
+type Extracted struct {
+	Base      string
+	Canonical string
+	Links     []string
+	Assets    []string
+	NoFollow  []string
+	Robots    []string
+}
+
+// A LinkExtractor finds the links in the content of a page. Additional extractors may be registered with a crawl
+// engine using WithLinkExtractors to support links which the HTMLExtractor does not find.
+type LinkExtractor interface {
+	ExtractLinks(r io.Reader) (*Extracted, error)
+}
+
+// The LinkExtractorFunc type is an adapter to allow the use of ordinary functions as link extractors.
+type LinkExtractorFunc func(r io.Reader) (*Extracted, error)
+
+// ExtractLinks calls f(r).
+func (f LinkExtractorFunc) ExtractLinks(r io.Reader) (*Extracted, error) {
+	return f(r)
+}
+
+// An HTMLExtractor is the default LinkExtractor. It finds links in <a href>, <area href>, <iframe src>, <frame src>,
+// <link href> elements with a rel of alternate, canonical, next or prev, and meta refresh targets. The <base href> of
+// the page is reported so that relative links can be resolved correctly, as are links marked rel="nofollow" and the
+// directives of <meta name="robots"> elements, or <meta> elements named for the product token of UserAgent, such as
+// "sitemapper" for "sitemapper/1.0". UserAgent defaults to the DefaultUserAgent.
+// If Assets is true, <img src>, <script src> and <link rel=stylesheet href> references are also collected as assets.
+type HTMLExtractor struct {
+	Assets    bool
+	UserAgent string
+}
+
+// pageRels are the <link> rel values which refer to other pages which should be crawled.
+var pageRels = map[string]struct{}{"alternate": {}, "canonical": {}, "next": {}, "prev": {}}
+
+// ExtractLinks tokenizes the HTML content and returns the unique links and assets found, in document order. No
+// document tree is built, so parsing adds little to the memory held by the page content itself, which the HTTPClient
+// limits to MaxBodyBytes.
+func (e *HTMLExtractor) ExtractLinks(r io.Reader) (*Extracted, error) {
+	ex := &Extracted{}
+	agent := DefaultUserAgent
+	if e.UserAgent != "" {
+		agent = productToken(e.UserAgent)
+	}
+	// Using maps to build sets of unique references
+	// When we add a new reference to a map we also append it to the slice which is returned
+	seenLinks := make(map[string]struct{})
+	seenAssets := make(map[string]struct{})
+	// A link is only reported as nofollow if every reference to it in the page is marked rel="nofollow"
+	followed := make(map[string]struct{})
+	addLink := func(v string) {
+		ex.Links = appendUnique(ex.Links, seenLinks, v)
+		followed[strings.TrimSpace(v)] = struct{}{}
+	}
+	var nofollow []string
+	addAnchor := func(attrs map[string]string, v string) {
+		for _, r := range strings.Fields(strings.ToLower(attrs["rel"])) {
+			if r == DirectiveNoFollow {
+				ex.Links = appendUnique(ex.Links, seenLinks, v)
+				nofollow = append(nofollow, strings.TrimSpace(v))
+				return
+			}
+		}
+		addLink(v)
+	}
+	addAsset := func(v string) {
+		if e.Assets {
+			ex.Assets = appendUnique(ex.Assets, seenAssets, v)
+		}
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		name, hasAttr := z.TagName()
+		tag := string(name)
+		if _, ok := extractedTags[tag]; !ok || !hasAttr {
+			continue
+		}
+		attrs := tagAttrs(z)
+
+		switch tag {
+		case "a", "area":
+			if v, ok := attrs["href"]; ok {
+				addAnchor(attrs, v)
+			}
+		case "iframe", "frame":
+			if v, ok := attrs["src"]; ok {
+				addLink(v)
+			}
+		case "link":
+			v, ok := attrs["href"]
+			for _, r := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if _, page := pageRels[r]; page && ok {
+					addLink(v)
+				}
+				if r == "canonical" && ok && ex.Canonical == "" {
+					ex.Canonical = strings.TrimSpace(v)
+				}
+				if r == "stylesheet" && ok {
+					addAsset(v)
+				}
+			}
+		case "meta":
+			content := attrs["content"]
+			if strings.EqualFold(attrs["http-equiv"], "refresh") {
+				if v, ok := refreshURL(content); ok {
+					addLink(v)
+				}
+			}
+			if name := attrs["name"]; strings.EqualFold(name, "robots") || strings.EqualFold(name, agent) {
+				ex.Robots = append(ex.Robots, parseDirectives(content)...)
+			}
+		case "base":
+			// Only the first <base> element in a document is used
+			if v, ok := attrs["href"]; ok && ex.Base == "" {
+				ex.Base = strings.TrimSpace(v)
+			}
+		case "img", "script":
+			if v, ok := attrs["src"]; ok {
+				addAsset(v)
+			}
+		}
+	}
+
+	seenNoFollow := make(map[string]struct{})
+	for _, v := range nofollow {
+		if _, ok := followed[v]; !ok {
+			ex.NoFollow = appendUnique(ex.NoFollow, seenNoFollow, v)
+		}
+	}
+	ex.Robots = mergeDirectives(ex.Robots)
+
+	return ex, nil
+}
+
+// extractedTags are the elements which may hold references of interest to the HTMLExtractor.
+var extractedTags = map[string]struct{}{
+	"a": {}, "area": {}, "iframe": {}, "frame": {}, "link": {}, "meta": {}, "base": {}, "img": {}, "script": {},
+}
+
+// tagAttrs returns the attributes of the current tag of the tokenizer. Where an attribute is repeated the first value is
+// used, as browsers do.
+func tagAttrs(z *html.Tokenizer) map[string]string {
+	attrs := make(map[string]string)
+	for {
+		key, val, more := z.TagAttr()
+		if _, ok := attrs[string(key)]; !ok {
+			attrs[string(key)] = string(val)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// appendUnique trims the value and appends it to the slice, unless it is already present in the set.
+func appendUnique(s []string, seen map[string]struct{}, v string) []string {
+	v = strings.TrimSpace(v)
+	if _, ok := seen[v]; ok {
+		return s
+	}
+	seen[v] = struct{}{}
+	return append(s, v)
+}
+
+// refreshURL returns the target URL of a meta refresh element's content attribute, such as "5; url=/next.html".
+// False is returned if the content does not contain a URL.
+func refreshURL(content string) (string, bool) {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return "", false
+	}
+	v := strings.TrimSpace(content[i+1:])
+	if len(v) < 3 || !strings.EqualFold(v[:3], "url") {
+		return "", false
+	}
+	v = strings.TrimSpace(v[3:])
+	if !strings.HasPrefix(v, "=") {
+		return "", false
+	}
+	v = strings.TrimSpace(v[1:])
+	v = strings.Trim(v, `"'`)
+	if v == "" {
+		return "", false
+	}
+	return v, true
+}
+
+// extractAll runs each of the link extractors over the content and merges the results. The first <base href> found is
+// used, and duplicate links, assets and directives are removed.
+func extractAll(extractors []LinkExtractor, content string) (*Extracted, error) {
+	merged := &Extracted{}
+	seenLinks := make(map[string]struct{})
+	seenAssets := make(map[string]struct{})
+	seenNoFollow := make(map[string]struct{})
+	for _, e := range extractors {
+		ex, err := e.ExtractLinks(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		if ex == nil {
+			continue
+		}
+		if merged.Base == "" {
+			merged.Base = ex.Base
+		}
+		if merged.Canonical == "" {
+			merged.Canonical = ex.Canonical
+		}
+		for _, l := range ex.Links {
+			merged.Links = appendUnique(merged.Links, seenLinks, l)
+		}
+		for _, a := range ex.Assets {
+			merged.Assets = appendUnique(merged.Assets, seenAssets, a)
+		}
+		for _, l := range ex.NoFollow {
+			merged.NoFollow = appendUnique(merged.NoFollow, seenNoFollow, l)
+		}
+		merged.Robots = mergeDirectives(merged.Robots, ex.Robots)
+	}
+	return merged, nil
+}
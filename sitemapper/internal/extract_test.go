@@ -0,0 +1,155 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"github.com/matryer/is"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHTMLExtractor_ExtractLinks(t *testing.T) {
+	data := []struct {
+		name   string
+		assets bool
+		links  []string
+		result []string
+	}{
+		{"links only", false,
+			[]string{"/refreshed.html", "https://example.com/docs/", "/fr/docs/", "page2.html", "guide.html", "/area.html", "/embedded.html"},
+			nil},
+		{"with assets", true,
+			[]string{"/refreshed.html", "https://example.com/docs/", "/fr/docs/", "page2.html", "guide.html", "/area.html", "/embedded.html"},
+			[]string{"/css/site.css", "https://cdn.example.net/app.js", "images/logo.png"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			f, err := os.Open("testdata/alllinks.html")
+			is.NoErr(err)
+			defer f.Close()
+
+			ex, err := (&HTMLExtractor{Assets: d.assets}).ExtractLinks(f)
+			is.NoErr(err)
+			is.Equal(ex.Base, "/docs/")
+			is.Equal(ex.Links, d.links)
+			is.Equal(ex.Assets, d.result)
+		})
+	}
+}
+
+func TestHTMLExtractor_Frames(t *testing.T) {
+	is := is.New(t)
+	content := `<html><frameset><frame src="left.html"><frame src="/right.html"></frameset></html>`
+	ex, err := (&HTMLExtractor{}).ExtractLinks(strings.NewReader(content))
+	is.NoErr(err)
+	is.Equal(ex.Links, []string{"left.html", "/right.html"})
+}
+
+// linkPageReader generates an HTML page with the given number of links without holding the page in memory.
+type linkPageReader struct {
+	links int
+	n     int
+	buf   []byte
+}
+
+func (r *linkPageReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.n == r.links {
+			return 0, io.EOF
+		}
+		r.buf = []byte(fmt.Sprintf(`<p>Paragraph %d <a href="/page/%d.html">link</a></p>`, r.n, r.n))
+		r.n++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func TestHTMLExtractor_Streaming(t *testing.T) {
+	is := is.New(t)
+	ex, err := (&HTMLExtractor{}).ExtractLinks(&linkPageReader{links: 20000})
+	is.NoErr(err)
+	is.Equal(len(ex.Links), 20000)
+	is.Equal(ex.Links[0], "/page/0.html")
+	is.Equal(ex.Links[19999], "/page/19999.html")
+}
+
+func Test_refreshURL(t *testing.T) {
+	data := []struct {
+		content string
+		url     string
+		ok      bool
+	}{
+		{"5; url=/next.html", "/next.html", true},
+		{"0;URL='https://example.com/'", "https://example.com/", true},
+		{`0, url = "next.html"`, "next.html", true},
+		{"30", "", false},
+		{"5; /next.html", "", false},
+		{"5; url=", "", false},
+	}
+
+	for _, d := range data {
+		t.Run(d.content, func(t *testing.T) {
+			is := is.New(t)
+			u, ok := refreshURL(d.content)
+			is.Equal(u, d.url)
+			is.Equal(ok, d.ok)
+		})
+	}
+}
+
+func TestCrawlEngine_LinkExtractors(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/docs/":
+			fmt.Fprint(w, `<html><head><base href="/guide/"></head><body><a href="intro.html">intro</a><img src="logo.png"></body></html>
+<!-- data-link: /hidden.html -->`)
+		default:
+			fmt.Fprint(w, "<p>page</p>")
+		}
+	}))
+	defer srv.Close()
+
+	// A custom extractor which finds links in comments, registered alongside the default extractor
+	re := regexp.MustCompile(`data-link: (\S+)`)
+	comments := LinkExtractorFunc(func(r io.Reader) (*Extracted, error) {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		ex := &Extracted{}
+		for _, m := range re.FindAllSubmatch(b, -1) {
+			ex.Links = append(ex.Links, string(m[1]))
+		}
+		return ex, nil
+	})
+
+	sm := NewSiteMap()
+	c := NewSynchronousCrawlEngine(sm, 1, srv.URL+"/docs/", WithLinkExtractors(&HTMLExtractor{Assets: true}, comments))
+	c.Run(context.Background())
+
+	links, ok := sm.GetLinks(srv.URL + "/docs/")
+	is.True(ok)
+	is.Equal(len(links), 2)
+	is.True(contains(links, srv.URL+"/guide/intro.html")) // resolved against the <base href>
+	is.True(contains(links, srv.URL+"/hidden.html"))
+	is.Equal(sm.GetAssets(srv.URL+"/docs/"), []string{srv.URL + "/guide/logo.png"})
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package sitemap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A Fetcher retrieves the content of a URL for the crawl engines. Fetch returns a Response even when an error occurs,
+// holding as much of the response as was received, and returns an error for any response other than 200 OK. A nil
+// Response is treated as an empty one. The request should be aborted if the context is cancelled. A Fetcher must be
+// safe for concurrent use.
+type Fetcher interface {
+	Fetch(ctx context.Context, u string) (*Response, error)
+}
+
+// FetcherFunc adapts an ordinary function to the Fetcher interface.
+type FetcherFunc func(ctx context.Context, u string) (*Response, error)
+
+// Fetch calls f(ctx, u).
+func (f FetcherFunc) Fetch(ctx context.Context, u string) (*Response, error) {
+	return f(ctx, u)
+}
+
+// A Response holds the response received for a fetched URL: the final request URL after any redirects, the status
+// code and headers, the content and the time taken to fetch the URL. Redirects lists each redirect followed to reach
+// the final URL. Attempts is the number of times the URL was requested, and is set by the Retry middleware. Truncated
+// is true if the body was longer than the client's MaxBodyBytes, so that only its start is held in Content. A Response
+// may be shared, for example by a cache, so it must not be modified once returned.
+type Response struct {
+	URL        *url.URL
+	StatusCode int
+	Header     http.Header
+	Content    string
+	Duration   time.Duration
+	Attempts   int
+	Redirects  []Redirect
+	Truncated  bool
+}
+
+// status returns the URLStatus describing the response.
+func (r *Response) status() URLStatus {
+	s := URLStatus{StatusCode: r.StatusCode, DurationMs: r.Duration.Milliseconds()}
+	if r.Attempts > 1 {
+		s.Attempts = r.Attempts
+	}
+	if r.URL != nil {
+		s.FinalURL = r.URL.String()
+	}
+	s.Redirects = r.Redirects
+	s.Truncated = r.Truncated
+	if r.Header != nil {
+		s.ContentType = r.Header.Get("Content-Type")
+	}
+	return s
+}
+
+// Fetch visits the provided URL and returns the response, making HTTPClient the default Fetcher. The body is only
+// read for the accepted content types, and at most MaxBodyBytes of it are read, with longer bodies marked as
+// Truncated. Pages of other types return a Response with no content and no error.
+func (h *HTTPClient) Fetch(ctx context.Context, u string) (*Response, error) {
+	res := &Response{}
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	if h.config.HeadCheck {
+		if hr, ok := h.head(ctx, u); ok {
+			res = hr
+			return res, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(withRedirects(ctx, &res.Redirects), http.MethodGet, u, nil)
+	if err != nil {
+		return res, err
+	}
+	resp, err := h.Do(req)
+	if err != nil {
+		// The last redirect response is returned along with the error when the redirect limit or a loop is hit
+		if resp != nil {
+			res.URL = resp.Request.URL
+			res.StatusCode = resp.StatusCode
+		}
+		return res, err
+	}
+	defer resp.Body.Close()
+	res.URL = resp.Request.URL
+	res.Header = resp.Header
+	res.StatusCode = resp.StatusCode
+
+	if resp.StatusCode != http.StatusOK {
+		return res, &statusError{url: u, code: resp.StatusCode}
+	}
+
+	// One byte more than the limit is read so that truncated pages can be reported
+	body := bufio.NewReader(io.LimitReader(resp.Body, h.MaxBodyBytes()+1))
+	mt := mediaType(resp.Header.Get("Content-Type"))
+	if mt == "" {
+		// Peek returns the available bytes along with an error for short bodies, which is reported when reading
+		sniff, _ := body.Peek(512)
+		ct := http.DetectContentType(sniff)
+		res.Header = resp.Header.Clone()
+		res.Header.Set("Content-Type", ct)
+		mt = mediaType(ct)
+	}
+	if !h.acceptsContent(mt) {
+		return res, nil
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return res, fmt.Errorf("%w: %v", errReadBody, err)
+	}
+	if int64(len(b)) > h.MaxBodyBytes() {
+		log.Printf("content of URL %s truncated to %d bytes", u, h.MaxBodyBytes())
+		b = b[:h.MaxBodyBytes()]
+		res.Truncated = true
+	}
+	res.Content = string(b)
+	return res, nil
+}
+
+// head sends a HEAD request for the URL and returns a Response along with true if the server reports a content type
+// which is not accepted, so that the page need not be downloaded. If the HEAD request fails, or reports no content
+// type, false is returned and the page is fetched as usual.
+func (h *HTTPClient) head(ctx context.Context, u string) (*Response, bool) {
+	var redirects []Redirect
+	req, err := http.NewRequestWithContext(withRedirects(ctx, &redirects), http.MethodHead, u, nil)
+	if err != nil {
+		return nil, false
+	}
+	resp, err := h.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	resp.Body.Close()
+	mt := mediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK || mt == "" || h.acceptsContent(mt) {
+		return nil, false
+	}
+	return &Response{URL: resp.Request.URL, StatusCode: resp.StatusCode, Header: resp.Header, Redirects: redirects}, true
+}
+
+// mediaType returns the media type of the response, sniffing it from the content if there is no Content-Type header.
+func (r *Response) mediaType() string {
+	if mt := mediaType(r.Header.Get("Content-Type")); mt != "" {
+		return mt
+	}
+	if r.Content == "" {
+		return ""
+	}
+	n := len(r.Content)
+	if n > 512 {
+		n = 512
+	}
+	return mediaType(http.DetectContentType([]byte(r.Content[:n])))
+}
+
+// A MapFetcher serves HTML pages from memory, keyed by URL, which allows crawls to be run without a network. URLs
+// which are not in the map respond with 404 Not Found. Keys must be in the normalized form used by the crawl, such as
+// "http://example.com/about" rather than "http://example.com/about/". A MapFetcher must not be modified during a
+// crawl.
+type MapFetcher map[string]string
+
+// Fetch returns the page held for the URL.
+func (m MapFetcher) Fetch(ctx context.Context, u string) (*Response, error) {
+	res := &Response{}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return res, err
+	}
+	res.URL = pu
+	res.Header = http.Header{"Content-Type": []string{"text/html; charset=utf-8"}}
+	content, ok := m[u]
+	if !ok {
+		res.StatusCode = http.StatusNotFound
+		return res, &statusError{url: u, code: http.StatusNotFound}
+	}
+	res.StatusCode = http.StatusOK
+	res.Content = content
+	return res, nil
+}
+
+// A Middleware wraps a Fetcher to add behaviour such as retries, caching, logging or metrics.
+type Middleware func(Fetcher) Fetcher
+
+// Chain wraps the Fetcher with each of the middleware. The first middleware is the outermost, so it sees each request
+// first and each response last.
+func Chain(f Fetcher, mw ...Middleware) Fetcher {
+	for i := len(mw) - 1; i >= 0; i-- {
+		f = mw[i](f)
+	}
+	return f
+}
+
+// Cache returns a Middleware which keeps successful responses in memory, so that each URL is fetched at most once
+// while it succeeds. Failed fetches are not cached. The cache is shared by every Fetcher the Middleware wraps.
+func Cache() Middleware {
+	var mutex sync.RWMutex
+	cache := map[string]*Response{}
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+			mutex.RLock()
+			res, ok := cache[u]
+			mutex.RUnlock()
+			if ok {
+				return res, nil
+			}
+			res, err := next.Fetch(ctx, u)
+			if err == nil {
+				mutex.Lock()
+				cache[u] = res
+				mutex.Unlock()
+			}
+			return res, err
+		})
+	}
+}
+
+// Logging returns a Middleware which logs the outcome and duration of each fetch. If the logger is nil the standard
+// logger is used.
+func Logging(l *log.Logger) Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+			start := time.Now()
+			res, err := next.Fetch(ctx, u)
+			if err != nil {
+				l.Printf("fetched URL %s in %v with error: %v", u, time.Since(start), err)
+			} else if res == nil {
+				l.Printf("fetched URL %s in %v with no response", u, time.Since(start))
+			} else {
+				l.Printf("fetched URL %s in %v with status %d", u, time.Since(start), res.StatusCode)
+			}
+			return res, err
+		})
+	}
+}
+
+// FetchStats summarizes the fetches seen by a FetchMetrics. Requests counts every fetch and Errors those which failed.
+// Bytes is the total content received, Duration the total time spent fetching, and StatusCodes counts the responses
+// received by status code.
+type FetchStats struct {
+	Requests    int
+	Errors      int
+	Bytes       int64
+	Duration    time.Duration
+	StatusCodes map[int]int
+}
+
+// FetchMetrics collects FetchStats using the Metrics middleware. A FetchMetrics is safe for concurrent use, and its
+// zero value is ready to use.
+type FetchMetrics struct {
+	mutex sync.Mutex
+	stats FetchStats
+}
+
+// Stats returns a copy of the statistics collected so far.
+func (m *FetchMetrics) Stats() FetchStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.stats
+	s.StatusCodes = make(map[int]int, len(m.stats.StatusCodes))
+	for k, v := range m.stats.StatusCodes {
+		s.StatusCodes[k] = v
+	}
+	return s
+}
+
+// record adds the outcome of a fetch to the statistics.
+func (m *FetchMetrics) record(res *Response, err error, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stats.Requests++
+	m.stats.Duration += d
+	if err != nil {
+		m.stats.Errors++
+	}
+	if res == nil {
+		return
+	}
+	m.stats.Bytes += int64(len(res.Content))
+	if res.StatusCode != 0 {
+		if m.stats.StatusCodes == nil {
+			m.stats.StatusCodes = map[int]int{}
+		}
+		m.stats.StatusCodes[res.StatusCode]++
+	}
+}
+
+// Metrics returns a Middleware which records each fetch in the FetchMetrics.
+func Metrics(m *FetchMetrics) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+			start := time.Now()
+			res, err := next.Fetch(ctx, u)
+			m.record(res, err, time.Since(start))
+			return res, err
+		})
+	}
+}
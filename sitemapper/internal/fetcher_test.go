@@ -0,0 +1,197 @@
+package sitemap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/matryer/is"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var testMapSite = MapFetcher{
+	"http://example.com/":      `<a href="/a">a</a><a href="/b">b</a>`,
+	"http://example.com/a":     `<a href="/b">b</a><a href="/c">c</a>`,
+	"http://example.com/b":     `<a href="/">home</a>`,
+	"http://example.com/c":     `<a href="/missing">missing</a>`,
+	"http://example.com/other": `not linked`,
+}
+
+func TestCrawlEngine_MapFetcher(t *testing.T) {
+	expected := map[string][]string{
+		"http://example.com/":        {"http://example.com/a", "http://example.com/b"},
+		"http://example.com/a":       {"http://example.com/b", "http://example.com/c"},
+		"http://example.com/b":       nil,
+		"http://example.com/c":       {"http://example.com/missing"},
+		"http://example.com/missing": nil,
+	}
+
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 5, "http://example.com/", NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			sm, _ := d.engine(NewSiteMap(), WithFetcher(testMapSite)).Run(context.Background())
+			is.Equal(len(sm.lm), len(expected))
+			for u, ls := range expected {
+				got, ok := sm.GetLinks(u)
+				is.True(ok)
+				is.Equal(len(got), len(ls))
+				for _, l := range ls {
+					_, ok := sm.lm[u][l]
+					is.True(ok)
+				}
+			}
+			status, _ := sm.GetStatus("http://example.com/missing")
+			is.Equal(status.StatusCode, http.StatusNotFound)
+			is.Equal(status.ErrorClass, ErrorClassHTTPStatus)
+		})
+	}
+}
+
+func TestCrawlEngine_NilResponse(t *testing.T) {
+	failing := FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+		if u == "http://example.com/c" {
+			return nil, errors.New("connection failed")
+		}
+		return testMapSite.Fetch(ctx, u)
+	})
+
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 5, "http://example.com/", NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			var b bytes.Buffer
+			sm, _ := d.engine(NewSiteMap(), WithFetcher(failing),
+				WithFetchMiddleware(Logging(log.New(&b, "", 0)))).Run(context.Background())
+			status, ok := sm.GetStatus("http://example.com/c")
+			is.True(ok)
+			is.Equal(status.StatusCode, 0)
+			is.Equal(status.ErrorClass, ErrorClassRequest)
+			_, ok = sm.GetLinks("http://example.com/missing")
+			is.True(!ok)
+			is.True(strings.Contains(b.String(), "fetched URL http://example.com/c in "))
+		})
+	}
+}
+
+func TestMapFetcher_Fetch(t *testing.T) {
+	is := is.New(t)
+	res, err := testMapSite.Fetch(context.Background(), "http://example.com/other")
+	is.NoErr(err)
+	is.Equal(res.StatusCode, http.StatusOK)
+	is.Equal(res.Content, "not linked")
+	is.Equal(res.URL.String(), "http://example.com/other")
+	is.Equal(res.status().ContentType, "text/html; charset=utf-8")
+
+	res, err = testMapSite.Fetch(context.Background(), "http://example.com/nothing")
+	is.Equal(classifyError(err), ErrorClassHTTPStatus)
+	is.Equal(res.StatusCode, http.StatusNotFound)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = testMapSite.Fetch(ctx, "http://example.com/")
+	is.Equal(classifyError(err), ErrorClassCancelled)
+}
+
+// countingFetcher counts the fetches of each URL, responding with the given status codes in turn and then 200 OK.
+type countingFetcher struct {
+	calls    map[string]int
+	statuses []int
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, u string) (*Response, error) {
+	n := f.calls[u]
+	f.calls[u]++
+	if n < len(f.statuses) {
+		return &Response{StatusCode: f.statuses[n]}, &statusError{url: u, code: f.statuses[n]}
+	}
+	return &Response{StatusCode: http.StatusOK, Content: "ok"}, nil
+}
+
+func TestCache(t *testing.T) {
+	is := is.New(t)
+	cf := &countingFetcher{calls: map[string]int{}, statuses: []int{500}}
+	f := Chain(cf, Cache())
+
+	// Failures are not cached, so the URL is fetched again until it succeeds
+	for i := 0; i < 3; i++ {
+		_, _ = f.Fetch(context.Background(), "http://example.com/")
+	}
+	is.Equal(cf.calls["http://example.com/"], 2)
+}
+
+func TestMetrics(t *testing.T) {
+	is := is.New(t)
+	var m FetchMetrics
+	f := Chain(testMapSite, Metrics(&m))
+	_, _ = f.Fetch(context.Background(), "http://example.com/other")
+	_, _ = f.Fetch(context.Background(), "http://example.com/nothing")
+
+	s := m.Stats()
+	is.Equal(s.Requests, 2)
+	is.Equal(s.Errors, 1)
+	is.Equal(s.Bytes, int64(len("not linked")))
+	is.Equal(s.StatusCodes, map[int]int{http.StatusOK: 1, http.StatusNotFound: 1})
+}
+
+func TestLogging(t *testing.T) {
+	is := is.New(t)
+	var b bytes.Buffer
+	f := Chain(testMapSite, Logging(log.New(&b, "", 0)))
+	_, _ = f.Fetch(context.Background(), "http://example.com/other")
+	is.True(strings.Contains(b.String(), "fetched URL http://example.com/other in "))
+	is.True(strings.Contains(b.String(), "with status 200"))
+
+	b.Reset()
+	f = Chain(FetcherFunc(func(ctx context.Context, u string) (*Response, error) { return nil, nil }),
+		Logging(log.New(&b, "", 0)))
+	_, _ = f.Fetch(context.Background(), "http://example.com/")
+	is.True(strings.Contains(b.String(), "with no response"))
+}
+
+func TestChain_Order(t *testing.T) {
+	is := is.New(t)
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Fetcher) Fetcher {
+			return FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+				order = append(order, name)
+				return next.Fetch(ctx, u)
+			})
+		}
+	}
+	sm := NewSiteMap()
+	NewSynchronousCrawlEngine(sm, 1, "http://example.com/other",
+		WithFetcher(testMapSite), WithFetchMiddleware(mw("first"), mw("second"))).Run(context.Background())
+	is.Equal(order, []string{"first", "second"})
+}
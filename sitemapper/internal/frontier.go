@@ -0,0 +1,89 @@
+package sitemap
+
+import (
+	"sync"
+)
+
+// frontierItem is a URL waiting to be crawled, along with the page it was found on and its depth.
+type frontierItem struct {
+	url    string
+	parent string
+	depth  int
+}
+
+// A frontier is a FIFO queue of URLs waiting to be crawled. As URLs are crawled in the order in which they are found,
+// a crawl driven by a frontier proceeds breadth first. Each URL is only ever queued once.
+// pending counts the URLs which are queued or being crawled, so that workers can tell when the crawl is complete.
+// active counts the URLs being crawled at each depth. A URL is not popped while shallower URLs are still being crawled,
+// as they may link to a URL already queued at a greater depth, so each URL is queued at its minimum depth however the
+// workers are scheduled.
+type frontier struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	items   []frontierItem
+	seen    map[string]struct{}
+	pending int
+	active  map[int]int
+}
+
+// newFrontier returns an empty frontier.
+func newFrontier() *frontier {
+	f := &frontier{seen: map[string]struct{}{}, active: map[int]int{}}
+	f.cond = sync.NewCond(&f.mutex)
+	return f
+}
+
+// push adds a URL to the back of the queue, unless it has been queued before.
+func (f *frontier) push(item frontierItem) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.seen[item.url]; ok {
+		return
+	}
+	f.seen[item.url] = struct{}{}
+	f.items = append(f.items, item)
+	f.pending++
+	f.cond.Signal()
+}
+
+// pop removes and returns the URL at the front of the queue, blocking until one is available and every shallower URL
+// has been crawled. When the queue is empty and no URLs are being crawled, false is returned to signal the crawl is
+// complete. Every successful pop must be followed by a call to done once the URL has been crawled.
+func (f *frontier) pop() (frontierItem, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for len(f.items) == 0 || f.shallowerActive(f.items[0].depth) {
+		if f.pending == 0 {
+			return frontierItem{}, false
+		}
+		f.cond.Wait()
+	}
+	item := f.items[0]
+	f.items[0] = frontierItem{}
+	f.items = f.items[1:]
+	f.active[item.depth]++
+	return item, true
+}
+
+// shallowerActive returns true if any URL shallower than the depth is being crawled. The caller must hold the mutex.
+func (f *frontier) shallowerActive(depth int) bool {
+	for d, n := range f.active {
+		if d < depth && n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// done marks a popped URL as crawled. Waiting workers are woken when the last URL at a depth completes, so that they
+// can move on to the next depth, or exit if it was the last URL of the crawl.
+func (f *frontier) done(item frontierItem) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pending--
+	f.active[item.depth]--
+	if f.active[item.depth] == 0 {
+		delete(f.active, item.depth)
+		f.cond.Broadcast()
+	}
+}
@@ -0,0 +1,418 @@
+package sitemap
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The formats supported by the GraphEncoder.
+const (
+	GraphFormatDOT     = "dot"
+	GraphFormatGraphML = "graphml"
+	GraphFormatGEXF    = "gexf"
+	GraphFormatCSV     = "csv"
+)
+
+// GraphFormats lists the formats supported by the GraphEncoder.
+var GraphFormats = []string{GraphFormatDOT, GraphFormatGraphML, GraphFormatGEXF, GraphFormatCSV}
+
+// A GraphNode is a crawled URL in the link graph. Depth is the minimum click depth from the start URL, or -1 if it is
+// not known, and StatusCode and ErrorClass are the outcome of fetching the URL where known.
+type GraphNode struct {
+	URL        string
+	Depth      int
+	StatusCode int
+	ErrorClass string
+}
+
+// A GraphEdge is a link from the Source URL to the Target URL.
+type GraphEdge struct {
+	Source string
+	Target string
+}
+
+// A Graph is the link graph of a crawl, with its nodes and edges sorted by URL.
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// NewGraph returns the link graph of a set of crawl results. As for Analyze, the graph holds a node for each crawled
+// URL and an edge for each link between two crawled URLs, ignoring links to URLs which were not crawled and links from
+// a page to itself.
+func NewGraph(startURL string, results []Result) Graph {
+	lg := newLinkGraph(results)
+	start, ok := lg.index[startURL]
+	if !ok {
+		start, ok = lg.index[NewNormalizer().Normalize(startURL)]
+	}
+	if !ok {
+		start = -1
+	}
+	depths := lg.depths(start)
+
+	status := make(map[string]URLStatus, len(results))
+	for _, r := range results {
+		status[r.URL] = r.URLStatus
+	}
+
+	g := Graph{Nodes: make([]GraphNode, len(lg.urls)), Edges: make([]GraphEdge, 0)}
+	for i, u := range lg.urls {
+		g.Nodes[i] = GraphNode{URL: u, Depth: depths[i], StatusCode: status[u].StatusCode, ErrorClass: status[u].ErrorClass}
+		for _, to := range lg.out[i] {
+			g.Edges = append(g.Edges, GraphEdge{Source: u, Target: lg.urls[to]})
+		}
+	}
+	return g
+}
+
+// Graph returns the link graph of the crawl. Links to URLs recorded under the final URL of their redirect chain are
+// links to the final URL.
+func (sm *SiteMap) Graph(startURL string) Graph {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	results := make([]Result, 0, len(sm.lm))
+	for u, ls := range sm.lm {
+		r := Result{URL: u, URLStatus: sm.status[u]}
+		for l := range sm.resolveRedirects(ls) {
+			r.Links = append(r.Links, l)
+		}
+		results = append(results, r)
+	}
+
+	return NewGraph(sm.key(startURL), results)
+}
+
+// A GraphEncoder writes a link graph as Graphviz DOT, GraphML, GEXF or a CSV edge list. The node attributes, depth,
+// status and error, are only written where they are known. With a ClusterDepth greater than zero, the nodes of DOT
+// output are grouped into clusters by host and the first ClusterDepth segments of their path.
+type GraphEncoder struct {
+	Format       string
+	ClusterDepth int
+}
+
+// NewGraphEncoder returns a GraphEncoder for the format.
+func NewGraphEncoder(format string) *GraphEncoder {
+	return &GraphEncoder{Format: format}
+}
+
+// Validate checks that the format is supported and the cluster depth is not negative.
+func (e *GraphEncoder) Validate() error {
+	if !IsGraphFormat(e.Format) {
+		return fmt.Errorf("unsupported graph format %q, expected one of %s", e.Format, strings.Join(GraphFormats, ", "))
+	}
+	if e.ClusterDepth < 0 {
+		return errors.New("cluster depth must not be negative")
+	}
+	return nil
+}
+
+// IsGraphFormat returns true if the format is supported by the GraphEncoder.
+func IsGraphFormat(format string) bool {
+	for _, f := range GraphFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode writes the graph to w in the configured format.
+func (e *GraphEncoder) Encode(w io.Writer, g Graph) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	switch e.Format {
+	case GraphFormatDOT:
+		return e.encodeDOT(w, g)
+	case GraphFormatGraphML:
+		return encodeGraphML(w, g)
+	case GraphFormatGEXF:
+		return encodeGEXF(w, g)
+	default:
+		return encodeEdgeList(w, g)
+	}
+}
+
+// encodeDOT writes the graph as a Graphviz digraph. Each node is labelled with its URL and carries its depth and
+// status as attributes, and nodes which are broken links are coloured red.
+func (e *GraphEncoder) encodeDOT(w io.Writer, g Graph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph sitemap {")
+	fmt.Fprintln(bw, "  node [shape=box];")
+
+	clusters := map[string][]GraphNode{}
+	var keys []string
+	for _, n := range g.Nodes {
+		key := ""
+		if e.ClusterDepth > 0 {
+			key = clusterKey(n.URL, e.ClusterDepth)
+		}
+		if key == "" {
+			fmt.Fprintf(bw, "  %s;\n", dotNode(n))
+			continue
+		}
+		if _, ok := clusters[key]; !ok {
+			keys = append(keys, key)
+		}
+		clusters[key] = append(clusters[key], n)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		fmt.Fprintf(bw, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(bw, "    label=%s;\n", dotQuote(key))
+		for _, n := range clusters[key] {
+			fmt.Fprintf(bw, "    %s;\n", dotNode(n))
+		}
+		fmt.Fprintln(bw, "  }")
+	}
+
+	for _, edge := range g.Edges {
+		fmt.Fprintf(bw, "  %s -> %s;\n", dotQuote(edge.Source), dotQuote(edge.Target))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotNode returns the DOT statement declaring a node with its attributes, without the terminating semicolon.
+func dotNode(n GraphNode) string {
+	attrs := []string{"label=" + dotQuote(n.URL)}
+	if n.Depth >= 0 {
+		attrs = append(attrs, "depth="+strconv.Itoa(n.Depth))
+	}
+	if n.StatusCode != 0 {
+		attrs = append(attrs, "status="+strconv.Itoa(n.StatusCode))
+	}
+	if n.ErrorClass != "" {
+		attrs = append(attrs, "error="+dotQuote(n.ErrorClass))
+	}
+	if (URLStatus{StatusCode: n.StatusCode, ErrorClass: n.ErrorClass}).Broken() {
+		attrs = append(attrs, "color=red")
+	}
+	return dotQuote(n.URL) + " [" + strings.Join(attrs, ", ") + "]"
+}
+
+// dotQuote returns the string as a quoted DOT identifier.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// clusterKey returns the host and the first segments of the path of a URL, up to the given depth, which identify the
+// cluster the URL belongs to. URLs with an empty path, such as the start URL, belong to no cluster.
+func clusterKey(u string, depth int) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	var segments []string
+	for _, s := range strings.Split(pu.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 0 {
+		return ""
+	}
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return pu.Host + "/" + strings.Join(segments, "/")
+}
+
+// graphMLKey is a GraphML <key> declaration.
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+// graphMLData is a GraphML <data> element.
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphMLNode is a GraphML <node> element.
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+// graphMLEdge is a GraphML <edge> element.
+type graphMLEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// graphML is the GraphML document for a link graph.
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// encodeGraphML writes the graph as a GraphML document. Nodes are identified by their position and carry the URL as
+// data, along with the depth, status and error where known.
+func encodeGraphML(w io.Writer, g Graph) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "url", For: "node", Name: "url", Type: "string"},
+			{ID: "depth", For: "node", Name: "depth", Type: "int"},
+			{ID: "status", For: "node", Name: "status", Type: "int"},
+			{ID: "error", For: "node", Name: "error", Type: "string"},
+		},
+	}
+	doc.Graph.ID = "sitemap"
+	doc.Graph.EdgeDefault = "directed"
+
+	ids := make(map[string]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		id := "n" + strconv.Itoa(i)
+		ids[n.URL] = id
+		node := graphMLNode{ID: id, Data: []graphMLData{{Key: "url", Value: n.URL}}}
+		if n.Depth >= 0 {
+			node.Data = append(node.Data, graphMLData{Key: "depth", Value: strconv.Itoa(n.Depth)})
+		}
+		if n.StatusCode != 0 {
+			node.Data = append(node.Data, graphMLData{Key: "status", Value: strconv.Itoa(n.StatusCode)})
+		}
+		if n.ErrorClass != "" {
+			node.Data = append(node.Data, graphMLData{Key: "error", Value: n.ErrorClass})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{ID: "e" + strconv.Itoa(i), Source: ids[e.Source], Target: ids[e.Target]})
+	}
+
+	return encodeXMLDocument(w, doc)
+}
+
+// gexfAttValue is a GEXF <attvalue> element.
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// gexfNode is a GEXF <node> element.
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue,omitempty"`
+}
+
+// gexfEdge is a GEXF <edge> element.
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// gexfAttribute is a GEXF <attribute> declaration.
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+// gexf is the GEXF document for a link graph.
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string `xml:"defaultedgetype,attr"`
+		Mode            string `xml:"mode,attr"`
+		Attributes      struct {
+			Class      string          `xml:"class,attr"`
+			Attributes []gexfAttribute `xml:"attribute"`
+		} `xml:"attributes"`
+		Nodes []gexfNode `xml:"nodes>node"`
+		Edges []gexfEdge `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+// encodeGEXF writes the graph as a GEXF 1.3 document for Gephi. Nodes are labelled with their URL and carry the depth,
+// status and error where known.
+func encodeGEXF(w io.Writer, g Graph) error {
+	doc := gexf{XMLNS: "http://gexf.net/1.3", Version: "1.3"}
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Mode = "static"
+	doc.Graph.Attributes.Class = "node"
+	doc.Graph.Attributes.Attributes = []gexfAttribute{
+		{ID: "depth", Title: "depth", Type: "integer"},
+		{ID: "status", Title: "status", Type: "integer"},
+		{ID: "error", Title: "error", Type: "string"},
+	}
+	doc.Graph.Nodes = make([]gexfNode, 0, len(g.Nodes))
+	doc.Graph.Edges = make([]gexfEdge, 0, len(g.Edges))
+
+	ids := make(map[string]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		id := strconv.Itoa(i)
+		ids[n.URL] = id
+		node := gexfNode{ID: id, Label: n.URL}
+		if n.Depth >= 0 {
+			node.AttValues = append(node.AttValues, gexfAttValue{For: "depth", Value: strconv.Itoa(n.Depth)})
+		}
+		if n.StatusCode != 0 {
+			node.AttValues = append(node.AttValues, gexfAttValue{For: "status", Value: strconv.Itoa(n.StatusCode)})
+		}
+		if n.ErrorClass != "" {
+			node.AttValues = append(node.AttValues, gexfAttValue{For: "error", Value: n.ErrorClass})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{ID: strconv.Itoa(i), Source: ids[e.Source], Target: ids[e.Target]})
+	}
+
+	return encodeXMLDocument(w, doc)
+}
+
+// encodeXMLDocument writes the XML declaration followed by the indented document.
+func encodeXMLDocument(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// encodeEdgeList writes the edges of the graph as CSV with a source,target header row.
+func encodeEdgeList(w io.Writer, g Graph) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"source", "target"}); err != nil {
+		return err
+	}
+	for _, e := range g.Edges {
+		if err := cw.Write([]string{e.Source, e.Target}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,159 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/matryer/is"
+	"strings"
+	"testing"
+)
+
+func testGraphResults() []Result {
+	results := make([]Result, len(testGraph))
+	copy(results, testGraph)
+	results[0].StatusCode = 200
+	results[3].URLStatus = URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus}
+	return results
+}
+
+func TestNewGraph(t *testing.T) {
+	is := is.New(t)
+	g := NewGraph("http://example.com/", testGraphResults())
+
+	is.Equal(len(g.Nodes), 6)
+	is.Equal(g.Nodes[0], GraphNode{URL: "http://example.com/", Depth: 0, StatusCode: 200})
+	is.Equal(g.Nodes[3], GraphNode{URL: "http://example.com/c", Depth: 2, StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+	is.Equal(g.Nodes[4].Depth, -1)
+
+	// Self links and links to URLs which were not crawled are ignored
+	is.Equal(len(g.Edges), 7)
+	is.Equal(g.Edges[0], GraphEdge{Source: "http://example.com/", Target: "http://example.com/a"})
+	for _, e := range g.Edges {
+		is.True(e.Source != e.Target)
+		is.True(e.Target != "http://example.com/uncrawled")
+	}
+}
+
+func TestSiteMap_Graph(t *testing.T) {
+	is := is.New(t)
+	sm := NewSiteMap()
+	sm.AddURL("http://example.com/")
+	sm.UpdateURLWithLinks("http://example.com/", []string{"http://example.com/a"})
+	sm.AddURL("http://example.com/a")
+
+	// The start URL is normalized in the same way as the crawled URLs
+	g := sm.Graph("HTTP://EXAMPLE.COM:80")
+	is.Equal(len(g.Nodes), 2)
+	is.Equal(g.Nodes[1].Depth, 1)
+	is.Equal(g.Edges, []GraphEdge{{Source: "http://example.com/", Target: "http://example.com/a"}})
+}
+
+func TestGraphEncoder_Validate(t *testing.T) {
+	data := []struct {
+		name    string
+		encoder GraphEncoder
+		valid   bool
+	}{
+		{"dot", GraphEncoder{Format: GraphFormatDOT, ClusterDepth: 2}, true},
+		{"graphml", GraphEncoder{Format: GraphFormatGraphML}, true},
+		{"gexf", GraphEncoder{Format: GraphFormatGEXF}, true},
+		{"csv", GraphEncoder{Format: GraphFormatCSV}, true},
+		{"unsupported", GraphEncoder{Format: "json"}, false},
+		{"negative cluster depth", GraphEncoder{Format: GraphFormatDOT, ClusterDepth: -1}, false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(d.encoder.Validate() == nil, d.valid)
+		})
+	}
+}
+
+func TestGraphEncoder_DOT(t *testing.T) {
+	is := is.New(t)
+	g := Graph{
+		Nodes: []GraphNode{
+			{URL: "http://example.com/", Depth: 0, StatusCode: 200},
+			{URL: "http://example.com/blog/a", Depth: 1, StatusCode: 200},
+			{URL: "http://example.com/blog/b", Depth: -1, StatusCode: 404, ErrorClass: ErrorClassHTTPStatus},
+			{URL: `http://example.com/q?x="y"`, Depth: 1},
+		},
+		Edges: []GraphEdge{
+			{Source: "http://example.com/", Target: "http://example.com/blog/a"},
+			{Source: "http://example.com/blog/a", Target: "http://example.com/blog/b"},
+		},
+	}
+
+	var b bytes.Buffer
+	is.NoErr(NewGraphEncoder(GraphFormatDOT).Encode(&b, g))
+	out := b.String()
+	is.True(strings.HasPrefix(out, "digraph sitemap {\n"))
+	is.True(strings.HasSuffix(out, "}\n"))
+	is.True(strings.Contains(out, `  "http://example.com/" [label="http://example.com/", depth=0, status=200];`))
+	is.True(strings.Contains(out, `  "http://example.com/blog/b" [label="http://example.com/blog/b", status=404, error="http-status", color=red];`))
+	is.True(strings.Contains(out, `"http://example.com/q?x=\"y\""`))
+	is.True(strings.Contains(out, `  "http://example.com/blog/a" -> "http://example.com/blog/b";`))
+	is.True(!strings.Contains(out, "subgraph"))
+
+	b.Reset()
+	e := NewGraphEncoder(GraphFormatDOT)
+	e.ClusterDepth = 1
+	is.NoErr(e.Encode(&b, g))
+	out = b.String()
+	is.True(strings.Contains(out, "  subgraph cluster_0 {\n    label=\"example.com/blog\";\n    \"http://example.com/blog/a\""))
+	is.True(strings.Contains(out, "  subgraph cluster_1 {\n    label=\"example.com/q\";"))
+	// The start URL has no path segments so it is not placed in a cluster
+	is.True(strings.Contains(out, "\n  \"http://example.com/\" ["))
+}
+
+func TestGraphEncoder_GraphML(t *testing.T) {
+	is := is.New(t)
+	var b bytes.Buffer
+	is.NoErr(NewGraphEncoder(GraphFormatGraphML).Encode(&b, NewGraph("http://example.com/", testGraphResults())))
+	is.True(strings.HasPrefix(b.String(), xml.Header))
+
+	var doc graphML
+	is.NoErr(xml.Unmarshal(b.Bytes(), &doc))
+	is.Equal(doc.Graph.EdgeDefault, "directed")
+	is.Equal(len(doc.Keys), 4)
+	is.Equal(len(doc.Graph.Nodes), 6)
+	is.Equal(len(doc.Graph.Edges), 7)
+	is.Equal(doc.Graph.Nodes[3].Data, []graphMLData{
+		{Key: "url", Value: "http://example.com/c"},
+		{Key: "depth", Value: "2"},
+		{Key: "status", Value: "404"},
+		{Key: "error", Value: "http-status"},
+	})
+	// The unreachable page has no depth
+	is.Equal(doc.Graph.Nodes[4].Data, []graphMLData{{Key: "url", Value: "http://example.com/d"}})
+	is.Equal(doc.Graph.Edges[0], graphMLEdge{ID: "e0", Source: "n0", Target: "n1"})
+}
+
+func TestGraphEncoder_GEXF(t *testing.T) {
+	is := is.New(t)
+	var b bytes.Buffer
+	is.NoErr(NewGraphEncoder(GraphFormatGEXF).Encode(&b, NewGraph("http://example.com/", testGraphResults())))
+	is.True(strings.Contains(b.String(), `<gexf xmlns="http://gexf.net/1.3" version="1.3">`))
+
+	var doc gexf
+	is.NoErr(xml.Unmarshal(b.Bytes(), &doc))
+	is.Equal(doc.Graph.DefaultEdgeType, "directed")
+	is.Equal(len(doc.Graph.Attributes.Attributes), 3)
+	is.Equal(len(doc.Graph.Nodes), 6)
+	is.Equal(len(doc.Graph.Edges), 7)
+	is.Equal(doc.Graph.Nodes[0].Label, "http://example.com/")
+	is.Equal(doc.Graph.Nodes[0].AttValues, []gexfAttValue{{For: "depth", Value: "0"}, {For: "status", Value: "200"}})
+	is.Equal(len(doc.Graph.Nodes[4].AttValues), 0)
+	is.Equal(doc.Graph.Edges[0], gexfEdge{ID: "0", Source: "0", Target: "1"})
+}
+
+func TestGraphEncoder_CSV(t *testing.T) {
+	is := is.New(t)
+	var b bytes.Buffer
+	is.NoErr(NewGraphEncoder(GraphFormatCSV).Encode(&b, NewGraph("http://example.com/", testGraphResults())))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	is.Equal(len(lines), 8)
+	is.Equal(lines[0], "source,target")
+	is.Equal(lines[1], "http://example.com/,http://example.com/a")
+}
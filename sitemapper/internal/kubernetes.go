@@ -15,6 +15,18 @@ import (
 	"strings"
 )
 
+// The environment variables through which a crawl job receives the client credentials held in the sitemap's Secret.
+const (
+	EnvBasicAuth   = "SITEMAPPER_BASIC_AUTH"
+	EnvBearerToken = "SITEMAPPER_BEARER_TOKEN"
+)
+
+// The keys of the client credentials in a sitemap's Secret.
+const (
+	secretKeyBasicAuth   = "basic-auth"
+	secretKeyBearerToken = "bearer-token"
+)
+
 type JobManager struct {
 	clientset *kubernetes.Clientset
 	jobImage  string
@@ -66,11 +78,177 @@ func getConfiguration() (string, int64, string) {
 	return ji, ttl, ns
 }
 
-func (jm *JobManager) CreateJob(crawlID uuid.UUID, url string) error {
+// jobCommand returns the command line for a crawl job, including the flags for any crawl options which are set. The
+// depth and parent of the URL within the sitemap are passed so that the job can record them with its results.
+func jobCommand(crawlID uuid.UUID, url string, depth int, parent string, opts CrawlOptions) []string {
+	cmd := []string{"/sitemapper", "-s", url, "--id", crawlID.String()}
+	if depth > 0 {
+		cmd = append(cmd, "--depth", strconv.Itoa(depth))
+	}
+	if parent != "" {
+		cmd = append(cmd, "--parent", parent)
+	}
+	if opts.RequestsPerSecond > 0 {
+		cmd = append(cmd, "--rps", strconv.FormatFloat(opts.RequestsPerSecond, 'f', -1, 64))
+	}
+	if opts.MinDelayMs > 0 {
+		cmd = append(cmd, "--min-delay", fmt.Sprintf("%dms", opts.MinDelayMs))
+	}
+	// The limit is always passed, as zero means no limit rather than the default
+	cmd = append(cmd, "--max-per-host", strconv.Itoa(opts.MaxInFlightPerHost))
+	if opts.RespectRobots {
+		cmd = append(cmd, "--respect-robots")
+	}
+	if opts.QueryMode != "" {
+		cmd = append(cmd, "--query", opts.QueryMode)
+	}
+	if len(opts.QueryParams) > 0 {
+		cmd = append(cmd, "--query-params", strings.Join(opts.QueryParams, ","))
+	}
+	if opts.MaxParamValues > 0 {
+		cmd = append(cmd, "--max-param-values", strconv.Itoa(opts.MaxParamValues))
+	}
+	if opts.HostMode != "" {
+		cmd = append(cmd, "--host-scope", opts.HostMode)
+	}
+	if len(opts.Hosts) > 0 {
+		cmd = append(cmd, "--hosts", strings.Join(opts.Hosts, ","))
+	}
+	// Scope rules may contain commas, so each rule is passed as a separate flag
+	for _, r := range opts.Include {
+		cmd = append(cmd, "--include", r)
+	}
+	for _, r := range opts.Exclude {
+		cmd = append(cmd, "--exclude", r)
+	}
+	if opts.Client != nil {
+		cmd = append(cmd, clientFlags(*opts.Client)...)
+	}
+	if opts.Retry != nil {
+		cmd = append(cmd, retryFlags(*opts.Retry)...)
+	}
+	return cmd
+}
+
+// retryFlags returns the crawl job flags for the retry policy settings which are set.
+func retryFlags(p RetryPolicy) []string {
+	var flags []string
+	if p.MaxAttempts > 0 {
+		flags = append(flags, "--max-attempts", strconv.Itoa(p.MaxAttempts))
+	}
+	if p.BaseDelay > 0 {
+		flags = append(flags, "--retry-delay", p.BaseDelay.String())
+	}
+	if p.MaxDelay > 0 {
+		flags = append(flags, "--retry-max-delay", p.MaxDelay.String())
+	}
+	if p.Requeue {
+		flags = append(flags, "--requeue")
+	}
+	return flags
+}
+
+// clientFlags returns the crawl job flags for the HTTP client settings which are set. Credentials are never passed as
+// flags, as the command line of a pod can be read by anyone able to read its spec; see jobEnv.
+func clientFlags(c ClientConfig) []string {
+	var flags []string
+	if c.UserAgent != "" {
+		flags = append(flags, "--user-agent", c.UserAgent)
+	}
+	for _, h := range c.Headers {
+		flags = append(flags, "--header", h)
+	}
+	for _, ck := range c.Cookies {
+		flags = append(flags, "--cookie", ck)
+	}
+	if c.Proxy != "" {
+		flags = append(flags, "--proxy", c.Proxy)
+	}
+	if c.CAFile != "" {
+		flags = append(flags, "--ca-file", c.CAFile)
+	}
+	if c.Insecure {
+		flags = append(flags, "--insecure")
+	}
+	if c.Timeout > 0 {
+		flags = append(flags, "--request-timeout", c.Timeout.String())
+	}
+	if c.MaxBodyBytes > 0 {
+		flags = append(flags, "--max-body-size", strconv.FormatInt(c.MaxBodyBytes, 10))
+	}
+	if len(c.ContentTypes) > 0 {
+		flags = append(flags, "--content-types", strings.Join(c.ContentTypes, ","))
+	}
+	if c.HeadCheck {
+		flags = append(flags, "--head-check")
+	}
+	if c.MaxRedirects > 0 {
+		flags = append(flags, "--max-redirects", strconv.Itoa(c.MaxRedirects))
+	}
+	if len(c.CredentialHosts) > 0 {
+		flags = append(flags, "--credential-hosts", strings.Join(c.CredentialHosts, ","))
+	}
+	return flags
+}
+
+// jobEnv returns the environment variables of a crawl job which read the client credentials from the sitemap's Secret,
+// if it has one. The keys are optional as only one of the credentials is set.
+func jobEnv(opts CrawlOptions) []v1.EnvVar {
+	if opts.CredentialsSecret == "" {
+		return nil
+	}
+	optional := true
+	ref := func(key string) *v1.EnvVarSource {
+		return &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: opts.CredentialsSecret},
+			Key:                  key,
+			Optional:             &optional,
+		}}
+	}
+	return []v1.EnvVar{
+		{Name: EnvBasicAuth, ValueFrom: ref(secretKeyBasicAuth)},
+		{Name: EnvBearerToken, ValueFrom: ref(secretKeyBearerToken)},
+	}
+}
+
+// credentialsSecretName returns the name of the Secret holding the client credentials of a sitemap's crawl jobs.
+func credentialsSecretName(sitemapID uuid.UUID) string {
+	return fmt.Sprintf("sitemap-credentials-%s", sitemapID)
+}
+
+// CreateCredentialsSecret stores the basic auth or bearer token credentials of the client settings in a Secret for the
+// sitemap, which crawl jobs read through environment variables. The name of the Secret is returned.
+func (jm *JobManager) CreateCredentialsSecret(sitemapID uuid.UUID, c ClientConfig) (string, error) {
+	name := credentialsSecretName(sitemapID)
+	data := map[string]string{}
+	if c.BasicAuth != "" {
+		data[secretKeyBasicAuth] = c.BasicAuth
+	}
+	if c.BearerToken != "" {
+		data[secretKeyBearerToken] = c.BearerToken
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: jm.namespace,
+			Labels: map[string]string{
+				"sitemap-id": sitemapID.String(),
+			},
+		},
+		Type:       v1.SecretTypeOpaque,
+		StringData: data,
+	}
+	if _, err := jm.clientset.CoreV1().Secrets(jm.namespace).Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+		return "", err
+	}
+	log.Printf("Created secret %s successfully", name)
+	return name, nil
+}
+
+func (jm *JobManager) CreateJob(crawlID uuid.UUID, url string, depth int, parent string, opts CrawlOptions) error {
 	cid := crawlID.String()
 	jobs := jm.clientset.BatchV1().Jobs(jm.namespace)
 	var backOffLimit int32 = 0
-	cmd := fmt.Sprintf("/sitemapper -s %s --id %s", url, crawlID)
 
 	jobSpec := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
@@ -96,7 +274,8 @@ func (jm *JobManager) CreateJob(crawlID uuid.UUID, url string) error {
 							Name:            "sitemapper",
 							Image:           jm.jobImage,
 							ImagePullPolicy: v1.PullIfNotPresent,
-							Command:         strings.Split(cmd, " "),
+							Command:         jobCommand(crawlID, url, depth, parent, opts),
+							Env:             jobEnv(opts),
 							EnvFrom: []v1.EnvFromSource{{
 								ConfigMapRef: &v1.ConfigMapEnvSource{
 									LocalObjectReference: v1.LocalObjectReference{
@@ -0,0 +1,53 @@
+package sitemap
+
+import (
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"strings"
+	"testing"
+)
+
+func TestJobCommand_Credentials(t *testing.T) {
+	is := is.New(t)
+	opts := CrawlOptions{
+		Client:            &ClientConfig{UserAgent: "test", BasicAuth: "user:secret", BearerToken: "token"},
+		CredentialsSecret: "sitemap-credentials-test",
+	}
+	cmd := strings.Join(jobCommand(uuid.New(), "http://example.com/", 0, "", opts), " ")
+	is.True(strings.Contains(cmd, "--user-agent test"))
+	is.True(!strings.Contains(cmd, "secret"))
+	is.True(!strings.Contains(cmd, "token"))
+
+	env := jobEnv(opts)
+	is.Equal(len(env), 2)
+	for _, e := range env {
+		is.Equal(e.Value, "")
+		is.Equal(e.ValueFrom.SecretKeyRef.Name, "sitemap-credentials-test")
+	}
+	is.Equal(jobEnv(CrawlOptions{}), nil)
+}
+
+func TestJobCommand_RespectRobots(t *testing.T) {
+	is := is.New(t)
+	cmd := strings.Join(jobCommand(uuid.New(), "http://example.com/", 0, "", CrawlOptions{}), " ")
+	is.True(!strings.Contains(cmd, "--respect-robots"))
+	cmd = strings.Join(jobCommand(uuid.New(), "http://example.com/", 0, "", CrawlOptions{RespectRobots: true}), " ")
+	is.True(strings.Contains(cmd, "--respect-robots"))
+}
+
+func TestJobCommand_DepthAndParent(t *testing.T) {
+	is := is.New(t)
+	cmd := strings.Join(jobCommand(uuid.New(), "http://example.com/a", 2, "http://example.com/", CrawlOptions{}), " ")
+	is.True(strings.Contains(cmd, "--depth 2 --parent http://example.com/"))
+	cmd = strings.Join(jobCommand(uuid.New(), "http://example.com/", 0, "", CrawlOptions{}), " ")
+	is.True(!strings.Contains(cmd, "--depth"))
+	is.True(!strings.Contains(cmd, "--parent"))
+}
+
+func TestJobCommand_MaxPerHost(t *testing.T) {
+	is := is.New(t)
+	cmd := strings.Join(jobCommand(uuid.New(), "http://example.com/", 0, "", CrawlOptions{}), " ")
+	is.True(strings.Contains(cmd, "--max-per-host 0")) // zero is passed so that it is not replaced by the default
+	cmd = strings.Join(jobCommand(uuid.New(), "http://example.com/", 0, "", CrawlOptions{MaxInFlightPerHost: DefaultMaxInFlightPerHost}), " ")
+	is.True(strings.Contains(cmd, "--max-per-host 5"))
+}
@@ -1,42 +1,177 @@
 package sitemap
 
 import (
-	"errors"
+	"context"
+	"net/url"
+	"sync"
+	"time"
 )
 
-// A Limiter provides a way of governing the number of concurrent goroutines using a buffered channel.
+// A Limiter sets the maximum number of concurrent crawl tasks, which the ConcurrentLimitedCrawlEngine uses as the
+// size of its worker pool.
 type Limiter struct {
-	ch    chan struct{}
 	limit int
 }
 
-// NewLimiter returns an instance of Limiter and initialises the limiter channel, filling it with empty structs.
+// NewLimiter returns an instance of Limiter for the given limit.
 func NewLimiter(limit int) *Limiter {
-	ch := make(chan struct{}, limit)
+	return &Limiter{limit: limit}
+}
+
+// Limit returns the maximum number of concurrent crawl tasks.
+func (l *Limiter) Limit() int {
+	return l.limit
+}
+
+// DefaultMaxInFlightPerHost is the maximum number of concurrent requests to each host used by the command line tools
+// and the API when no limit is given.
+const DefaultMaxInFlightPerHost = 5
+
+// Politeness holds the per-host request scheduling settings. A zero value for any setting disables that constraint.
+type Politeness struct {
+	// RequestsPerSecond is the rate at which tokens are added to each host's token bucket.
+	RequestsPerSecond float64
+	// Burst is the capacity of each host's token bucket. Values below 1 are treated as 1.
+	Burst int
+	// MinDelay is the minimum time between the start of consecutive requests to the same host.
+	MinDelay time.Duration
+	// MaxInFlight is the maximum number of concurrent requests to the same host.
+	MaxInFlight int
+}
 
-	for i := 0; i < limit; i++ {
-		ch <- struct{}{}
+// hostState tracks the token bucket and in-flight requests for a single host.
+type hostState struct {
+	tokens    float64
+	refilled  time.Time
+	lastStart time.Time
+	minDelay  time.Duration
+	inFlight  int
+	// released is closed and replaced whenever an in-flight request completes, waking any waiting goroutines
+	released chan struct{}
+}
+
+// A HostLimiter is a per-host politeness scheduler. Requests to each host are limited by a token bucket, a minimum
+// delay between requests and a maximum number of in-flight requests. If a RobotsChecker is provided, the Crawl-delay
+// from each host's robots.txt is used as that host's minimum delay whenever it is larger than the configured delay.
+type HostLimiter struct {
+	config Politeness
+	robots *RobotsChecker
+	mutex  sync.Mutex
+	hosts  map[string]*hostState
+}
+
+// NewHostLimiter returns a HostLimiter using the given politeness settings. The RobotsChecker may be nil.
+func NewHostLimiter(p Politeness, rc *RobotsChecker) *HostLimiter {
+	if p.Burst < 1 {
+		p.Burst = 1
 	}
+	return &HostLimiter{config: p, robots: rc, hosts: map[string]*hostState{}}
+}
+
+// host returns the state for the given host, creating it if required. The caller must hold the mutex.
+func (h *HostLimiter) host(key string, crawlDelay time.Duration) *hostState {
+	hs, ok := h.hosts[key]
+	if !ok {
+		hs = &hostState{
+			tokens:   float64(h.config.Burst),
+			refilled: time.Now(),
+			minDelay: h.config.MinDelay,
+			released: make(chan struct{}),
+		}
+		if crawlDelay > hs.minDelay {
+			hs.minDelay = crawlDelay
+		}
+		h.hosts[key] = hs
+	}
+	return hs
+}
+
+// Acquire blocks until a request to the host of the URL is permitted, and returns a function which must be called
+// once the request has completed. If the context is cancelled while waiting, the context's error is returned.
+func (h *HostLimiter) Acquire(ctx context.Context, u string) (func(), error) {
+	key := u
+	if pu, err := url.Parse(u); err == nil {
+		key = pu.Host
+	}
+
+	// The Crawl-delay is looked up before taking the mutex as it may require fetching robots.txt
+	var crawlDelay time.Duration
+	if h.robots != nil {
+		crawlDelay = h.robots.CrawlDelay(ctx, u)
+	}
+
+	for {
+		h.mutex.Lock()
+		hs := h.host(key, crawlDelay)
+
+		if h.config.MaxInFlight > 0 && hs.inFlight >= h.config.MaxInFlight {
+			released := hs.released
+			h.mutex.Unlock()
+			select {
+			case <-released:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		now := time.Now()
+		var wait time.Duration
+		if h.config.RequestsPerSecond > 0 {
+			hs.tokens += now.Sub(hs.refilled).Seconds() * h.config.RequestsPerSecond
+			if hs.tokens > float64(h.config.Burst) {
+				hs.tokens = float64(h.config.Burst)
+			}
+			hs.refilled = now
+			if hs.tokens < 1 {
+				wait = time.Duration((1 - hs.tokens) / h.config.RequestsPerSecond * float64(time.Second))
+			}
+		}
+		if next := hs.lastStart.Add(hs.minDelay); next.After(now) && next.Sub(now) > wait {
+			wait = next.Sub(now)
+		}
+
+		if wait > 0 {
+			h.mutex.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		if h.config.RequestsPerSecond > 0 {
+			hs.tokens--
+		}
+		hs.lastStart = now
+		hs.inFlight++
+		h.mutex.Unlock()
 
-	return &Limiter{
-		ch:    ch,
-		limit: limit,
+		return func() {
+			h.mutex.Lock()
+			defer h.mutex.Unlock()
+			hs.inFlight--
+			close(hs.released)
+			hs.released = make(chan struct{})
+		}, nil
 	}
 }
 
-// RunFunc checks to see if there is room to run an additional concurrent activity by reading from the Limiter's
-// buffered channel. If a struct is returned after reading from the channel the function is run.
-// When the function is complete a new empty struct is placed in the channel.
-// If there are no structs available to read from the channel it means we have no room to run any additional
-// activities, and an error is returned.
-func (l *Limiter) RunFunc(f func()) error {
-
-	select {
-	case <-l.ch:
-		f()
-		l.ch <- struct{}{}
-		return nil
-	default:
-		return errors.New("limit reached, try again later")
+// Limit returns a Middleware which acquires the host limiter before each fetch and releases it once the fetch has
+// completed. The crawl engines place it inside the Retry middleware, so that each retry also waits for the host's
+// request rate, minimum delay and Crawl-delay.
+func (h *HostLimiter) Limit() Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+			release, err := h.Acquire(ctx, u)
+			if err != nil {
+				return &Response{}, err
+			}
+			defer release()
+			return next.Fetch(ctx, u)
+		})
 	}
 }
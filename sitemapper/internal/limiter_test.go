@@ -0,0 +1,129 @@
+package sitemap
+
+import (
+	"context"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter_MaxInFlight(t *testing.T) {
+	is := is.New(t)
+	h := NewHostLimiter(Politeness{MaxInFlight: 2}, nil)
+
+	var mutex sync.Mutex
+	inFlight, maxSeen := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := h.Acquire(context.Background(), "https://example.com/page.html")
+			is.NoErr(err)
+			mutex.Lock()
+			inFlight++
+			if inFlight > maxSeen {
+				maxSeen = inFlight
+			}
+			mutex.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+
+	is.Equal(maxSeen, 2)
+}
+
+func TestHostLimiter_Spacing(t *testing.T) {
+	data := []struct {
+		name     string
+		config   Politeness
+		requests int
+		minTotal time.Duration
+	}{
+		{"min delay", Politeness{MinDelay: 20 * time.Millisecond}, 4, 60 * time.Millisecond},
+		{"requests per second", Politeness{RequestsPerSecond: 50}, 4, 60 * time.Millisecond},
+		{"requests per second with burst", Politeness{RequestsPerSecond: 50, Burst: 3}, 4, 20 * time.Millisecond},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			h := NewHostLimiter(d.config, nil)
+			start := time.Now()
+			for i := 0; i < d.requests; i++ {
+				acquire(t, h, "https://example.com/")
+			}
+			elapsed := time.Since(start)
+			is.True(elapsed >= d.minTotal)
+		})
+	}
+}
+
+func TestHostLimiter_HostsAreIndependent(t *testing.T) {
+	is := is.New(t)
+	h := NewHostLimiter(Politeness{MinDelay: time.Second}, nil)
+
+	start := time.Now()
+	acquire(t, h, "https://one.example.com/")
+	acquire(t, h, "https://two.example.com/")
+	is.True(time.Since(start) < time.Second)
+}
+
+func TestHostLimiter_CrawlDelay(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte("User-agent: *\nCrawl-delay: 0.03"))
+		is.NoErr(err)
+	}))
+	defer srv.Close()
+
+	h := NewHostLimiter(Politeness{MinDelay: 10 * time.Millisecond}, NewRobotsChecker(DefaultUserAgent))
+	acquire(t, h, srv.URL+"/a")
+	start := time.Now()
+	acquire(t, h, srv.URL+"/b")
+	is.True(time.Since(start) >= 30*time.Millisecond) // the larger Crawl-delay replaces the configured delay
+}
+
+// acquire acquires and immediately releases a request slot for the URL.
+func acquire(t *testing.T, h *HostLimiter, u string) {
+	release, err := h.Acquire(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestHostLimiter_Cancelled(t *testing.T) {
+	data := []struct {
+		name   string
+		config Politeness
+	}{
+		{"waiting for delay", Politeness{MinDelay: time.Minute}},
+		{"waiting for in-flight request", Politeness{MaxInFlight: 1}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			h := NewHostLimiter(d.config, nil)
+			_, err := h.Acquire(context.Background(), "https://example.com/")
+			is.NoErr(err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			release, err := h.Acquire(ctx, "https://example.com/")
+			is.Equal(err, context.DeadlineExceeded)
+			is.Equal(release, nil)
+		})
+	}
+}
@@ -5,23 +5,73 @@ import (
 	"github.com/nats-io/nats.go"
 	"log"
 	"os"
+	"time"
 )
 
 type CrawlMessageHandlerFunc func(c *CrawlMessage)
 type ResultsMessageHandlerFunc func(c *ResultsMessage)
 type StartMessageHandlerFunc func(c *StartMessage)
 
+// A CrawlMessage requests a crawl job for a URL of a sitemap. CurrentDepth counts from 1 for the start URL, and Parent
+// is the page on which the URL was found, which is empty for the start URL.
 type CrawlMessage struct {
 	CrawlID      string
 	SitemapID    string
 	URL          string
 	CurrentDepth int
+	Parent       string `json:",omitempty"`
+	Options      CrawlOptions
 }
 
 type StartMessage struct {
 	SitemapID string
 	URL       string
 	MaxDepth  int
+	Options   CrawlOptions
+}
+
+// CrawlOptions carries the crawl settings for a sitemap from the API through to each crawl job.
+type CrawlOptions struct {
+	RequestsPerSecond  float64
+	MinDelayMs         int
+	MaxInFlightPerHost int
+	QueryMode          string        `json:",omitempty"`
+	QueryParams        []string      `json:",omitempty"`
+	MaxParamValues     int           `json:",omitempty"`
+	Include            []string      `json:",omitempty"`
+	Exclude            []string      `json:",omitempty"`
+	HostMode           string        `json:",omitempty"`
+	Hosts              []string      `json:",omitempty"`
+	Client             *ClientConfig `json:",omitempty"`
+	Retry              *RetryPolicy  `json:",omitempty"`
+	RespectRobots      bool          `json:",omitempty"`
+	// CredentialsSecret names the Kubernetes Secret holding the client credentials, which are removed from Client once
+	// the Secret has been created so that they are never stored or passed on a job's command line
+	CredentialsSecret string `json:",omitempty"`
+}
+
+// Politeness returns the per-host politeness settings for the crawl.
+func (o CrawlOptions) Politeness() Politeness {
+	return Politeness{
+		RequestsPerSecond: o.RequestsPerSecond,
+		MinDelay:          time.Duration(o.MinDelayMs) * time.Millisecond,
+		MaxInFlight:       o.MaxInFlightPerHost,
+	}
+}
+
+// QueryPolicy returns the query parameter policy for the crawl.
+func (o CrawlOptions) QueryPolicy() QueryPolicy {
+	return QueryPolicy{Mode: o.QueryMode, Params: o.QueryParams, MaxValues: o.MaxParamValues}
+}
+
+// Scope returns the crawl scope for the include and exclude rules. An error is returned if any rule is invalid.
+func (o CrawlOptions) Scope() (*Scope, error) {
+	return NewScope(o.Include, o.Exclude)
+}
+
+// HostScope returns the hosts which are crawled.
+func (o CrawlOptions) HostScope() HostScope {
+	return HostScope{Mode: o.HostMode, Hosts: o.Hosts}
 }
 
 type ResultContainer struct {
@@ -30,8 +80,16 @@ type ResultContainer struct {
 }
 
 type Result struct {
-	URL   string
-	Links []string
+	URL          string
+	Depth        int        `json:",omitempty"`
+	Parent       string     `json:",omitempty"`
+	Discovered   *time.Time `json:",omitempty"`
+	LastModified *time.Time `json:",omitempty"`
+	Links        []string
+	External     []string `json:",omitempty"`
+	Robots       []string `json:",omitempty"`
+	Canonical    string   `json:",omitempty"`
+	URLStatus
 }
 
 type ResultsMessage struct {
@@ -117,14 +175,14 @@ func (n *NATS) Stop() {
 	n.conn.Close()
 }
 
-func (n *NATS) SendCrawlMessage(crawlID, sitemapID uuid.UUID, URL string, depth int) error {
-	if err := n.encodedConn.Publish(n.crawlSubject, &CrawlMessage{CrawlID: crawlID.String(), URL: URL, CurrentDepth: depth, SitemapID: sitemapID.String()}); err != nil {
+func (n *NATS) SendCrawlMessage(crawlID, sitemapID uuid.UUID, URL string, depth int, parent string, opts CrawlOptions) error {
+	if err := n.encodedConn.Publish(n.crawlSubject, &CrawlMessage{CrawlID: crawlID.String(), URL: URL, CurrentDepth: depth, Parent: parent, SitemapID: sitemapID.String(), Options: opts}); err != nil {
 		return err
 	}
 	return nil
 }
-func (n *NATS) SendStartMessage(sitemapID uuid.UUID, URL string, maxDepth int) error {
-	if err := n.encodedConn.Publish(n.startSubject, &StartMessage{URL: URL, MaxDepth: maxDepth, SitemapID: sitemapID.String()}); err != nil {
+func (n *NATS) SendStartMessage(sitemapID uuid.UUID, URL string, maxDepth int, opts CrawlOptions) error {
+	if err := n.encodedConn.Publish(n.startSubject, &StartMessage{URL: URL, MaxDepth: maxDepth, SitemapID: sitemapID.String(), Options: opts}); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,58 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+)
+
+// resultStream writes the result of each crawled URL as a line of newline delimited JSON once the crawl has finished
+// with it. The first error writing a result is logged and no further results are written.
+type resultStream struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+	err   error
+}
+
+// newResultStream returns a resultStream writing to w.
+func newResultStream(w io.Writer) *resultStream {
+	return &resultStream{enc: json.NewEncoder(w)}
+}
+
+// write writes the result of a crawled URL. Nothing is written if the stream is nil or the URL has not been crawled.
+func (s *resultStream) write(sm *SiteMap, u string) {
+	if s == nil || u == "" {
+		return
+	}
+	r, ok := sm.result(u)
+	if !ok {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err := s.enc.Encode(r); err != nil {
+		s.err = err
+		log.Printf("error writing result for URL %s: %v", u, err)
+	}
+}
+
+// An NDJSONDecoder reads crawl results written as newline delimited JSON, one result at a time, so that the results of
+// a large crawl can be processed without holding them all in memory.
+type NDJSONDecoder struct {
+	dec *json.Decoder
+}
+
+// NewNDJSONDecoder returns an NDJSONDecoder reading from r.
+func NewNDJSONDecoder(r io.Reader) *NDJSONDecoder {
+	return &NDJSONDecoder{dec: json.NewDecoder(r)}
+}
+
+// Decode reads the next result into r, replacing its contents. io.EOF is returned once there are no more results.
+func (d *NDJSONDecoder) Decode(r *Result) error {
+	*r = Result{}
+	return d.dec.Decode(r)
+}
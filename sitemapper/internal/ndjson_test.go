@@ -0,0 +1,123 @@
+package sitemap
+
+import (
+	"bytes"
+	"context"
+	"github.com/matryer/is"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlEngine_ResultStream(t *testing.T) {
+	expected := map[string]struct {
+		depth  int
+		status int
+	}{
+		"http://example.com/":        {0, http.StatusOK},
+		"http://example.com/a":       {1, http.StatusOK},
+		"http://example.com/b":       {1, http.StatusOK},
+		"http://example.com/c":       {2, http.StatusOK},
+		"http://example.com/missing": {3, http.StatusNotFound},
+	}
+
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 5, "http://example.com/", NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			var b bytes.Buffer
+			d.engine(NewSiteMap(), WithFetcher(testMapSite), WithResultStream(&b)).Run(context.Background())
+
+			is.Equal(strings.Count(b.String(), "\n"), len(expected))
+			dec := NewNDJSONDecoder(&b)
+			seen := map[string]bool{}
+			var r Result
+			for {
+				err := dec.Decode(&r)
+				if err == io.EOF {
+					break
+				}
+				is.NoErr(err)
+				e, ok := expected[r.URL]
+				is.True(ok)
+				is.True(!seen[r.URL])
+				seen[r.URL] = true
+				is.Equal(r.StatusCode, e.status)
+				// Depths are only final for the breadth first crawl, as the others may find a shorter path later
+				if d.name == "Concurrent Limited crawl engine" {
+					is.Equal(r.Depth, e.depth)
+				}
+			}
+			is.Equal(len(seen), len(expected))
+		})
+	}
+}
+
+func TestCrawlEngine_ResultStream_Requeue(t *testing.T) {
+	is := is.New(t)
+	var b bytes.Buffer
+	f := &flakyFetcher{MapFetcher: testMapSite, failures: map[string]int{"http://example.com/a": 2, "http://example.com/b": 10}}
+	p := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, Requeue: true}
+	NewSynchronousCrawlEngine(NewSiteMap(), 5, "http://example.com/", WithFetcher(f), WithRetryPolicy(p), WithResultStream(&b)).Run(context.Background())
+
+	results, err := ReadResults(&b)
+	is.NoErr(err)
+	statuses := map[string]URLStatus{}
+	for _, r := range results {
+		_, dup := statuses[r.URL]
+		is.True(!dup)
+		statuses[r.URL] = r.URLStatus
+	}
+	// The requeued URLs are written once, with the outcome of their final visit
+	is.Equal(statuses["http://example.com/a"].StatusCode, http.StatusOK)
+	is.Equal(statuses["http://example.com/a"].Attempts, 3)
+	is.Equal(statuses["http://example.com/b"].StatusCode, http.StatusServiceUnavailable)
+	is.Equal(statuses["http://example.com/b"].Attempts, 4)
+}
+
+func TestNDJSONDecoder(t *testing.T) {
+	is := is.New(t)
+	input := `{"URL":"http://example.com/","Links":["http://example.com/a"],"StatusCode":200}
+{"URL":"http://example.com/a","Depth":1,"Parent":"http://example.com/","Links":[]}
+`
+	dec := NewNDJSONDecoder(strings.NewReader(input))
+	var r Result
+	is.NoErr(dec.Decode(&r))
+	is.Equal(r.URL, "http://example.com/")
+	is.Equal(r.Links, []string{"http://example.com/a"})
+	// Each result replaces the previous one rather than being merged with it
+	is.NoErr(dec.Decode(&r))
+	is.Equal(r, Result{URL: "http://example.com/a", Depth: 1, Parent: "http://example.com/", Links: []string{}})
+	is.Equal(dec.Decode(&r), io.EOF)
+
+	is.True(NewNDJSONDecoder(strings.NewReader("not json")).Decode(&r) != nil)
+}
+
+func TestReadResults_NDJSON(t *testing.T) {
+	is := is.New(t)
+	var b bytes.Buffer
+	NewSynchronousCrawlEngine(NewSiteMap(), 5, "http://example.com/", WithFetcher(testMapSite), WithResultStream(&b)).Run(context.Background())
+
+	results, err := ReadResults(&b)
+	is.NoErr(err)
+	is.Equal(len(results), 5)
+	stats := Analyze("http://example.com/", results)
+	is.Equal(stats.Count, 5)
+	is.Equal(stats.MaxDepth, 3)
+}
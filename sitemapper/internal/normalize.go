@@ -0,0 +1,166 @@
+package sitemap
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Trailing slash policies for a Normalizer.
+const (
+	TrailingSlashKeep   = "keep"
+	TrailingSlashAdd    = "add"
+	TrailingSlashRemove = "remove"
+)
+
+// DefaultDropParams are the query parameters removed by the default Normalizer. They are used for tracking and do not
+// change the page which is returned.
+var DefaultDropParams = []string{"utm_*", "gclid", "fbclid"}
+
+// A Normalizer converts URLs to a canonical form, so that equivalent URLs are only crawled and recorded once.
+// The scheme and host are always converted to lower case and default ports are removed.
+//
+// TrailingSlash sets the policy for a trailing slash on the path: keep it as found, add it to paths whose last segment
+// has no file extension, or remove it from all paths other than the root. Fragments are removed unless KeepFragment
+// is true. Query parameters are sorted by name when SortQuery is true. If KeepParams is set only the listed query
+// parameters are kept, and any parameters listed in DropParams are removed. Parameter names may include glob
+// wildcards, as in "utm_*". If DropQuery is true the query string is removed entirely.
+type Normalizer struct {
+	TrailingSlash string
+	KeepFragment  bool
+	SortQuery     bool
+	KeepParams    []string
+	DropParams    []string
+	DropQuery     bool
+}
+
+// NewNormalizer returns a Normalizer which keeps trailing slashes as found, removes fragments, sorts query parameters
+// and removes the DefaultDropParams tracking parameters.
+func NewNormalizer() *Normalizer {
+	return &Normalizer{TrailingSlash: TrailingSlashKeep, SortQuery: true, DropParams: DefaultDropParams}
+}
+
+// Normalize returns the normalized form of the URL. If the URL cannot be parsed it is returned unchanged.
+func (n *Normalizer) Normalize(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return n.NormalizeURL(pu).String()
+}
+
+// NormalizeURL returns a normalized copy of the URL.
+func (n *Normalizer) NormalizeURL(u *url.URL) *url.URL {
+	nu := *u
+	nu.Scheme = strings.ToLower(nu.Scheme)
+	nu.Host = strings.ToLower(nu.Host)
+	if port := nu.Port(); (nu.Scheme == "http" && port == "80") || (nu.Scheme == "https" && port == "443") {
+		nu.Host = strings.TrimSuffix(nu.Host, ":"+port)
+	}
+
+	// The path is normalized in its escaped form so that encoded reserved characters, such as %2F, are kept
+	p := upperPercentEncoding(nu.EscapedPath())
+	if p == "" && nu.Host != "" {
+		p = "/"
+	}
+	switch n.TrailingSlash {
+	case TrailingSlashAdd:
+		if p != "" && !strings.HasSuffix(p, "/") && path.Ext(p) == "" {
+			p += "/"
+		}
+	case TrailingSlashRemove:
+		if len(p) > 1 {
+			p = strings.TrimRight(p, "/")
+			if p == "" {
+				p = "/"
+			}
+		}
+	}
+	if up, err := url.PathUnescape(p); err == nil {
+		nu.Path, nu.RawPath = up, p
+	}
+
+	if !n.KeepFragment {
+		nu.Fragment = ""
+		nu.RawFragment = ""
+	}
+
+	nu.RawQuery = n.normalizeQuery(nu.RawQuery)
+	// An empty query, as in "/a?", is the same as no query
+	nu.ForceQuery = false
+
+	return &nu
+}
+
+// upperPercentEncoding returns the escaped path with the hex digits of each percent-encoding in upper case, so that
+// %2f and %2F are treated as the same path.
+func upperPercentEncoding(p string) string {
+	if !strings.Contains(p, "%") {
+		return p
+	}
+	b := []byte(p)
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] == '%' {
+			b[i+1], b[i+2] = upperHex(b[i+1]), upperHex(b[i+2])
+			i += 2
+		}
+	}
+	return string(b)
+}
+
+// upperHex returns the upper case form of a lower case hex digit, and any other byte unchanged.
+func upperHex(c byte) byte {
+	if c >= 'a' && c <= 'f' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// normalizeQuery applies the parameter lists to a raw query string, and sorts the parameters if required.
+func (n *Normalizer) normalizeQuery(rawQuery string) string {
+	if rawQuery == "" || n.DropQuery {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	for k := range values {
+		if (len(n.KeepParams) > 0 && !matchParam(n.KeepParams, k)) || matchParam(n.DropParams, k) {
+			delete(values, k)
+		}
+	}
+	if n.SortQuery {
+		// Encode sorts by key, and the values of each key are sorted so that their order does not matter either
+		for _, v := range values {
+			sort.Strings(v)
+		}
+		return values.Encode()
+	}
+
+	// Without sorting the original parameter order is preserved, only removing the unwanted parameters
+	var kept []string
+	for _, p := range strings.Split(rawQuery, "&") {
+		k := p
+		if i := strings.Index(p, "="); i >= 0 {
+			k = p[:i]
+		}
+		if k, err := url.QueryUnescape(k); err == nil {
+			if _, ok := values[k]; ok {
+				kept = append(kept, p)
+			}
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// matchParam returns true if the parameter name matches any of the patterns.
+func matchParam(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package sitemap
+
+import (
+	"github.com/matryer/is"
+	"testing"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	data := []struct {
+		name       string
+		normalizer *Normalizer
+		input      string
+		expected   string
+	}{
+		{"case folding", NewNormalizer(), "HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"default http port", NewNormalizer(), "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", NewNormalizer(), "https://example.com:443/a", "https://example.com/a"},
+		{"other port", NewNormalizer(), "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"fragment", NewNormalizer(), "https://example.com/a#section", "https://example.com/a"},
+		{"keep fragment", &Normalizer{KeepFragment: true}, "https://example.com/a#section", "https://example.com/a#section"},
+		{"empty query", NewNormalizer(), "https://example.com/a?", "https://example.com/a"},
+		{"sorted query", NewNormalizer(), "https://example.com/a?b=2&a=1&b=1", "https://example.com/a?a=1&b=1&b=2"},
+		{"unsorted query", &Normalizer{}, "https://example.com/a?b=2&a=1", "https://example.com/a?b=2&a=1"},
+		{"tracking params", NewNormalizer(), "https://example.com/a?utm_source=x&id=3&utm_medium=y&gclid=z", "https://example.com/a?id=3"},
+		{"drop params unsorted", &Normalizer{DropParams: []string{"session*"}}, "https://example.com/a?z=1&sessionid=2&a=3", "https://example.com/a?z=1&a=3"},
+		{"keep params", &Normalizer{KeepParams: []string{"page", "id"}, SortQuery: true}, "https://example.com/a?sort=asc&page=2&id=7", "https://example.com/a?id=7&page=2"},
+		{"trailing slash keep", NewNormalizer(), "https://example.com/a/", "https://example.com/a/"},
+		{"trailing slash add", &Normalizer{TrailingSlash: TrailingSlashAdd}, "https://example.com/a", "https://example.com/a/"},
+		{"trailing slash add file", &Normalizer{TrailingSlash: TrailingSlashAdd}, "https://example.com/a.html", "https://example.com/a.html"},
+		{"trailing slash remove", &Normalizer{TrailingSlash: TrailingSlashRemove}, "https://example.com/a/", "https://example.com/a"},
+		{"trailing slash remove root", &Normalizer{TrailingSlash: TrailingSlashRemove}, "https://example.com/", "https://example.com/"},
+		{"empty path", NewNormalizer(), "https://example.com/", "https://example.com/"},
+		{"empty path with query", NewNormalizer(), "https://example.com?id=1", "https://example.com/?id=1"},
+		{"encoded slash", NewNormalizer(), "https://example.com/a%2Fb/c", "https://example.com/a%2Fb/c"},
+		{"encoded slash lower case", NewNormalizer(), "https://example.com/a%2fb", "https://example.com/a%2Fb"},
+		{"encoded slash trailing slash remove", &Normalizer{TrailingSlash: TrailingSlashRemove}, "https://example.com/a%2Fb/", "https://example.com/a%2Fb"},
+		{"encoded space", NewNormalizer(), "https://example.com/a%20b", "https://example.com/a%20b"},
+		{"unparseable", NewNormalizer(), string([]byte{0x7f}), string([]byte{0x7f})},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(d.normalizer.Normalize(d.input), d.expected)
+		})
+	}
+}
+
+func TestSiteMap_NormalizedURLs(t *testing.T) {
+	is := is.New(t)
+	sm := NewSiteMap()
+	sm.AddURL("http://Example.com/a?utm_source=feed")
+	sm.SetStatus("http://example.com/a", URLStatus{StatusCode: 200})
+	sm.UpdateURLWithLinks("http://example.com:80/a#top", []string{"http://example.com/b?y=2&x=1"})
+
+	links, ok := sm.GetLinks("http://example.com/a")
+	is.True(ok)
+	is.Equal(links, []string{"http://example.com/b?x=1&y=2"})
+	is.Equal(len(sm.SitemapURLs()), 1)
+}
+
+func TestSiteMap_Canonical(t *testing.T) {
+	is := is.New(t)
+	sm := NewSiteMap()
+	sm.AddURL("https://example.com/a?ref=nav")
+	sm.SetStatus("https://example.com/a?ref=nav", URLStatus{StatusCode: 200})
+	sm.SetCanonical("https://example.com/a?ref=nav", "https://example.com/a")
+	sm.AddURL("https://example.com/a")
+	sm.SetStatus("https://example.com/a", URLStatus{StatusCode: 200})
+	sm.SetCanonical("https://example.com/a", "https://example.com/a#self")
+
+	urls := sm.SitemapURLs()
+	is.Equal(len(urls), 1)
+	is.Equal(urls[0].Loc, "https://example.com/a")
+}
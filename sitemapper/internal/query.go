@@ -0,0 +1,107 @@
+package sitemap
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+)
+
+// Query policy modes, selecting which query parameters are kept on the links found while crawling.
+const (
+	QueryKeepAll    = "all"
+	QueryKeepListed = "keep"
+	QueryDropListed = "drop"
+	QueryDropAll    = "none"
+)
+
+// SkipReasonQueryLimit is recorded against URLs which were not visited because a query parameter has already been
+// seen with the maximum number of distinct values.
+const SkipReasonQueryLimit = "query parameter limit"
+
+// A QueryPolicy decides which query parameters are kept on crawled URLs. Mode is one of QueryKeepAll (the default),
+// QueryKeepListed or QueryDropListed, which apply to the parameter names in Params, or QueryDropAll to remove query
+// strings entirely. Parameter names may include glob wildcards, as in "utm_*".
+//
+// If MaxValues is greater than zero, links are not followed once a query parameter on the same path has been seen
+// with that many distinct values. This avoids crawler traps such as calendars with an endless "?date=" parameter.
+type QueryPolicy struct {
+	Mode      string
+	Params    []string
+	MaxValues int
+}
+
+// Validate returns an error if the mode is not supported, or if MaxValues is negative.
+func (p QueryPolicy) Validate() error {
+	switch p.Mode {
+	case "", QueryKeepAll, QueryDropAll:
+	case QueryKeepListed, QueryDropListed:
+		if len(p.Params) == 0 {
+			return errors.New("query parameters must be listed for the keep and drop query modes")
+		}
+	default:
+		return errors.New("unsupported query mode")
+	}
+	if p.MaxValues < 0 {
+		return errors.New("max query parameter values must not be negative")
+	}
+	return nil
+}
+
+// normalizer returns a copy of the Normalizer with its query parameter settings replaced by the policy.
+// The tracking parameters dropped by the Normalizer are still dropped when all parameters are kept.
+func (p QueryPolicy) normalizer(n *Normalizer) *Normalizer {
+	nn := *n
+	switch p.Mode {
+	case QueryKeepListed:
+		nn.KeepParams = p.Params
+	case QueryDropListed:
+		nn.DropParams = append(append([]string{}, n.DropParams...), p.Params...)
+	case QueryDropAll:
+		nn.DropQuery = true
+	}
+	return &nn
+}
+
+// A queryLimiter records the distinct values seen for each query parameter on each path, and rejects URLs which would
+// take a parameter beyond the maximum number of values.
+type queryLimiter struct {
+	mutex  sync.Mutex
+	max    int
+	values map[string]map[string]struct{}
+}
+
+// newQueryLimiter returns a queryLimiter allowing up to max distinct values for each parameter.
+func newQueryLimiter(max int) *queryLimiter {
+	return &queryLimiter{max: max, values: map[string]map[string]struct{}{}}
+}
+
+// allow returns true if each query parameter of the URL has a value which has been seen before, or if there is room to
+// record a new value. Values are only recorded when the URL is allowed.
+func (q *queryLimiter) allow(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil || pu.RawQuery == "" {
+		return true
+	}
+	query := pu.Query()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for name, vs := range query {
+		seen := q.values[pu.Host+pu.Path+"?"+name]
+		for _, v := range vs {
+			if _, ok := seen[v]; !ok && len(seen) >= q.max {
+				return false
+			}
+		}
+	}
+	for name, vs := range query {
+		key := pu.Host + pu.Path + "?" + name
+		if q.values[key] == nil {
+			q.values[key] = map[string]struct{}{}
+		}
+		for _, v := range vs {
+			q.values[key][v] = struct{}{}
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryPolicy_Validate(t *testing.T) {
+	data := []struct {
+		name   string
+		policy QueryPolicy
+		valid  bool
+	}{
+		{"default", QueryPolicy{}, true},
+		{"keep all", QueryPolicy{Mode: QueryKeepAll, MaxValues: 10}, true},
+		{"keep listed", QueryPolicy{Mode: QueryKeepListed, Params: []string{"page"}}, true},
+		{"keep nothing listed", QueryPolicy{Mode: QueryKeepListed}, false},
+		{"drop all", QueryPolicy{Mode: QueryDropAll}, true},
+		{"unknown mode", QueryPolicy{Mode: "some"}, false},
+		{"negative max values", QueryPolicy{MaxValues: -1}, false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(d.policy.Validate() == nil, d.valid)
+		})
+	}
+}
+
+func TestQueryPolicy_normalizer(t *testing.T) {
+	u := "https://example.com/list?page=2&sort=asc&utm_source=feed"
+	data := []struct {
+		name     string
+		policy   QueryPolicy
+		expected string
+	}{
+		{"keep all", QueryPolicy{Mode: QueryKeepAll}, "https://example.com/list?page=2&sort=asc"},
+		{"keep listed", QueryPolicy{Mode: QueryKeepListed, Params: []string{"page"}}, "https://example.com/list?page=2"},
+		{"drop listed", QueryPolicy{Mode: QueryDropListed, Params: []string{"so*"}}, "https://example.com/list?page=2"},
+		{"drop all", QueryPolicy{Mode: QueryDropAll}, "https://example.com/list"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			n := NewNormalizer()
+			is.Equal(d.policy.normalizer(n).Normalize(u), d.expected)
+			is.Equal(n.DropParams, DefaultDropParams) // the original Normalizer is unchanged
+		})
+	}
+}
+
+func Test_queryLimiter(t *testing.T) {
+	is := is.New(t)
+	q := newQueryLimiter(2)
+	is.True(q.allow("https://example.com/cal?date=1"))
+	is.True(q.allow("https://example.com/cal?date=2"))
+	is.True(q.allow("https://example.com/cal?date=1")) // seen before
+	is.True(!q.allow("https://example.com/cal?date=3"))
+	is.True(q.allow("https://example.com/other?date=3")) // values are counted per path
+	is.True(q.allow("https://example.com/cal"))
+}
+
+func TestCrawlEngine_QueryLimit(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every calendar page links to the next day, an endless crawler trap
+		var day int
+		fmt.Sscanf(r.URL.Query().Get("day"), "%d", &day)
+		fmt.Fprintf(w, `<a href="/calendar?day=%d">next</a>`, day+1)
+	}))
+	defer srv.Close()
+
+	sm := NewSiteMap()
+	c := NewSynchronousCrawlEngine(sm, 10, srv.URL+"/calendar?day=1", WithQueryPolicy(QueryPolicy{MaxValues: 3}))
+	c.Run(context.Background())
+
+	is.Equal(len(sm.SitemapURLs()), 3)
+	reason, skipped := sm.GetSkipped(srv.URL + "/calendar?day=4")
+	is.True(skipped)
+	is.Equal(reason, SkipReasonQueryLimit)
+}
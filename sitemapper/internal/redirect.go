@@ -0,0 +1,183 @@
+package sitemap
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// LongRedirectChain is the number of hops beyond which a redirect chain is reported as long.
+const LongRedirectChain = 3
+
+// errRedirectLoop is returned when a redirect leads back to a URL already visited in the same chain.
+var errRedirectLoop = errors.New("redirect loop")
+
+// errTooManyRedirects is returned when a request is redirected more times than the client allows.
+var errTooManyRedirects = errors.New("too many redirects")
+
+// A Redirect is a single hop of a redirect chain: the URL requested, the redirect status code received and the
+// Location it pointed to.
+type Redirect struct {
+	URL        string
+	StatusCode int
+	Location   string
+}
+
+// redirectsKey is the context key under which a request's redirect chain is collected.
+type redirectsKey struct{}
+
+// withRedirects returns a context which collects the redirect chain of requests made with it into hops.
+func withRedirects(ctx context.Context, hops *[]Redirect) context.Context {
+	return context.WithValue(ctx, redirectsKey{}, hops)
+}
+
+// checkRedirect is the CheckRedirect function of the HTTPClient. Each hop is recorded in the request's context, and
+// redirect loops and chains longer than MaxRedirects are stopped.
+func (h *HTTPClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if hops, ok := req.Context().Value(redirectsKey{}).(*[]Redirect); ok && req.Response != nil {
+		*hops = append(*hops, Redirect{
+			URL:        via[len(via)-1].URL.String(),
+			StatusCode: req.Response.StatusCode,
+			Location:   req.Response.Header.Get("Location"),
+		})
+	}
+	// The headers of the first request are copied to each redirect, so credentials are removed on leaving the
+	// credential hosts
+	if !h.sendsCredentials(req.URL.Host) {
+		for k := range h.credentials {
+			req.Header.Del(k)
+		}
+	}
+	for _, v := range via {
+		if v.URL.String() == req.URL.String() {
+			return errRedirectLoop
+		}
+	}
+	if len(via) > h.config.MaxRedirects {
+		return errTooManyRedirects
+	}
+	return nil
+}
+
+// A RedirectChain reports a crawled URL which redirected, along with the pages which link to it. Hops lists each
+// redirect in turn, and FinalURL is where the chain ended. Loop is set for chains which lead back to a URL already
+// visited, and Long for chains of more than LongRedirectChain hops or which exceeded the redirect limit.
+type RedirectChain struct {
+	URL      string
+	FinalURL string `json:",omitempty"`
+	Hops     []Redirect
+	Loop     bool `json:",omitempty"`
+	Long     bool `json:",omitempty"`
+	Sources  []string
+}
+
+// RedirectChains returns the redirect chains found during the crawl, sorted by URL.
+func (sm *SiteMap) RedirectChains() []RedirectChain {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return FindRedirectChains(sm.redirectResults())
+}
+
+// redirectResults returns a Result for each crawled URL holding its links and status, along with a Result for each
+// URL replaced by the final URL of its redirect chain. The caller must hold the mutex.
+func (sm *SiteMap) redirectResults() []Result {
+	results := make([]Result, 0, len(sm.lm)+len(sm.redirected))
+	for u, ls := range sm.lm {
+		r := Result{URL: u, URLStatus: sm.status[u]}
+		for l := range ls {
+			r.Links = append(r.Links, l)
+		}
+		results = append(results, r)
+	}
+	for u, s := range sm.redirected {
+		results = append(results, Result{URL: u, URLStatus: s})
+	}
+	return results
+}
+
+// FindRedirectChains returns the redirect chains in a set of crawl results, sorted by URL. The sources of each chain
+// are the crawled URLs which link to the redirected URL, also sorted by URL.
+func FindRedirectChains(results []Result) []RedirectChain {
+	sources := map[string][]string{}
+	for _, r := range results {
+		for _, l := range r.Links {
+			sources[l] = append(sources[l], r.URL)
+		}
+	}
+
+	chains := make([]RedirectChain, 0)
+	for _, r := range results {
+		if len(r.Redirects) == 0 {
+			continue
+		}
+		c := RedirectChain{
+			URL:      r.URL,
+			FinalURL: r.FinalURL,
+			Hops:     r.Redirects,
+			Loop:     r.ErrorClass == ErrorClassRedirectLoop,
+			Long:     len(r.Redirects) > LongRedirectChain || r.ErrorClass == ErrorClassTooManyRedirects,
+		}
+		s := sources[c.URL]
+		if s == nil {
+			s = make([]string, 0)
+		}
+		sort.Strings(s)
+		c.Sources = s
+		chains = append(chains, c)
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].URL < chains[j].URL })
+
+	return chains
+}
+
+// flags returns the problems found with the chain, separated by commas.
+func (c RedirectChain) flags() string {
+	var f []string
+	if c.Loop {
+		f = append(f, "loop")
+	}
+	if c.Long {
+		f = append(f, "long")
+	}
+	return strings.Join(f, ",")
+}
+
+// WriteRedirectChainsTable writes a human-readable report of the redirect chains, with one row per chain giving the
+// number of hops, where it ended and any problems found.
+func WriteRedirectChainsTable(w io.Writer, chains []RedirectChain) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "URL\tHOPS\tFINAL URL\tFLAGS"); err != nil {
+		return err
+	}
+	for _, c := range chains {
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", c.URL, len(c.Hops), c.FinalURL, c.flags()); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteRedirectChainsCSV writes the redirect chains as CSV with a header row, and one row per hop of each chain.
+func WriteRedirectChainsCSV(w io.Writer, chains []RedirectChain) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"url", "hop", "hop_url", "status_code", "location", "final_url", "flags"}); err != nil {
+		return err
+	}
+	for _, c := range chains {
+		for i, h := range c.Hops {
+			row := []string{c.URL, strconv.Itoa(i + 1), h.URL, strconv.Itoa(h.StatusCode), h.Location, c.FinalURL, c.flags()}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,246 @@
+package sitemap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// redirectSite serves a small site with a two hop redirect, a redirect loop and a long redirect chain. Pages are
+// served as HTML, and any path of the form /hopN redirects to /hopN-1 until /hop0 is reached.
+func redirectSite() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/old">old</a><a href="/loop-a">loop</a><a href="/hop5">long</a><a href="/new">new</a>`)
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/new":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/">home</a>`)
+		case "/loop-a":
+			http.Redirect(w, r, "/loop-b", http.StatusFound)
+		case "/loop-b":
+			http.Redirect(w, r, "/loop-a", http.StatusFound)
+		case "/hop0":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<p>end</p>`)
+		default:
+			var n int
+			if _, err := fmt.Sscanf(r.URL.Path, "/hop%d", &n); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, fmt.Sprintf("/hop%d", n-1), http.StatusMovedPermanently)
+		}
+	}))
+}
+
+func TestHTTPClient_Redirects(t *testing.T) {
+	srv := redirectSite()
+	defer srv.Close()
+
+	data := []struct {
+		name         string
+		path         string
+		maxRedirects int
+		hops         []Redirect
+		final        string
+		errorClass   string
+	}{
+		{"not redirected", "/new", 0, nil, "/new", ""},
+		{"two hops", "/old", 0, []Redirect{
+			{URL: srv.URL + "/old", StatusCode: http.StatusMovedPermanently, Location: "/moved"},
+			{URL: srv.URL + "/moved", StatusCode: http.StatusFound, Location: "/new"},
+		}, "/new", ""},
+		{"loop", "/loop-a", 0, []Redirect{
+			{URL: srv.URL + "/loop-a", StatusCode: http.StatusFound, Location: "/loop-b"},
+			{URL: srv.URL + "/loop-b", StatusCode: http.StatusFound, Location: "/loop-a"},
+		}, "/loop-b", ErrorClassRedirectLoop},
+		{"within limit", "/hop2", 2, []Redirect{
+			{URL: srv.URL + "/hop2", StatusCode: http.StatusMovedPermanently, Location: "/hop1"},
+			{URL: srv.URL + "/hop1", StatusCode: http.StatusMovedPermanently, Location: "/hop0"},
+		}, "/hop0", ""},
+		{"too many", "/hop3", 2, []Redirect{
+			{URL: srv.URL + "/hop3", StatusCode: http.StatusMovedPermanently, Location: "/hop2"},
+			{URL: srv.URL + "/hop2", StatusCode: http.StatusMovedPermanently, Location: "/hop1"},
+			{URL: srv.URL + "/hop1", StatusCode: http.StatusMovedPermanently, Location: "/hop0"},
+		}, "/hop1", ErrorClassTooManyRedirects},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			h, err := NewHTTPClient(ClientConfig{MaxRedirects: d.maxRedirects})
+			is.NoErr(err)
+			res, err := h.Fetch(context.Background(), srv.URL+d.path)
+			is.Equal(res.Redirects, d.hops)
+			is.Equal(res.URL.String(), srv.URL+d.final)
+			if d.errorClass == "" {
+				is.NoErr(err)
+				return
+			}
+			status := res.status()
+			status.ErrorClass = classifyError(err)
+			is.Equal(status.ErrorClass, d.errorClass)
+			is.True(status.Broken())
+		})
+	}
+}
+
+func TestCrawlEngine_Redirects(t *testing.T) {
+	srv := redirectSite()
+	defer srv.Close()
+
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 3, srv.URL, opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 3, srv.URL, opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 3, srv.URL, NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			sm, _ := d.engine(NewSiteMap()).Run(context.Background())
+
+			status, _ := sm.GetStatus(srv.URL + "/old")
+			is.True(status.OK())
+			is.Equal(len(status.Redirects), 2)
+			is.Equal(status.FinalURL, srv.URL+"/new")
+
+			chains := sm.RedirectChains()
+			is.Equal(len(chains), 3)
+			is.Equal(chains[0].URL, srv.URL+"/hop5")
+			is.True(chains[0].Long)
+			is.Equal(chains[1].URL, srv.URL+"/loop-a")
+			is.True(chains[1].Loop)
+			is.Equal(chains[2].URL, srv.URL+"/old")
+			is.Equal(chains[2].FinalURL, srv.URL+"/new")
+			is.True(!chains[2].Loop && !chains[2].Long)
+			is.Equal(chains[2].Sources, []string{srv.URL + "/"})
+
+			broken := sm.BrokenLinks()
+			is.Equal(len(broken), 1)
+			is.Equal(broken[0].ErrorClass, ErrorClassRedirectLoop)
+		})
+	}
+}
+
+func TestCrawlEngine_FinalURLs(t *testing.T) {
+	srv := redirectSite()
+	defer srv.Close()
+
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 3, srv.URL, opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 3, srv.URL, opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 3, srv.URL, NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			sm, _ := d.engine(NewSiteMap(), WithFinalURLs()).Run(context.Background())
+
+			// The redirected URLs are recorded under their final URLs
+			_, ok := sm.lm[srv.URL+"/old"]
+			is.True(!ok)
+			_, ok = sm.lm[srv.URL+"/hop5"]
+			is.True(!ok)
+			_, ok = sm.lm[srv.URL+"/hop0"]
+			is.True(ok)
+			status, _ := sm.GetStatus(srv.URL + "/new")
+			is.True(status.OK())
+			is.Equal(len(status.Redirects), 0)
+			// Looking up a redirected URL finds its final URL, so it is not visited again
+			_, ok = sm.GetLinks(srv.URL + "/old")
+			is.True(ok)
+
+			// The chains are still reported, and the URLs linking to a redirected URL link to its final URL
+			chains := sm.RedirectChains()
+			is.Equal(len(chains), 3)
+			is.Equal(chains[2].URL, srv.URL+"/old")
+			is.Equal(chains[2].FinalURL, srv.URL+"/new")
+
+			var out struct {
+				Results []struct {
+					URL   string
+					Links []string
+				}
+				Redirects []RedirectChain
+			}
+			b, err := json.Marshal(sm)
+			is.NoErr(err)
+			is.NoErr(json.Unmarshal(b, &out))
+			is.Equal(len(out.Redirects), 3)
+			for _, r := range out.Results {
+				if r.URL == srv.URL {
+					is.Equal(r.Links, []string{srv.URL + "/hop0", srv.URL + "/loop-a", srv.URL + "/new"})
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRedirectChains(t *testing.T) {
+	chains := []RedirectChain{
+		{
+			URL:      "http://example.com/old",
+			FinalURL: "http://example.com/new",
+			Hops: []Redirect{
+				{URL: "http://example.com/old", StatusCode: 301, Location: "/moved"},
+				{URL: "http://example.com/moved", StatusCode: 302, Location: "/new"},
+			},
+			Sources: []string{"http://example.com/"},
+		},
+		{
+			URL:      "http://example.com/loop",
+			FinalURL: "http://example.com/loop",
+			Hops:     []Redirect{{URL: "http://example.com/loop", StatusCode: 302, Location: "/loop"}},
+			Loop:     true,
+			Sources:  []string{},
+		},
+	}
+
+	is := is.New(t)
+	var b bytes.Buffer
+	is.NoErr(WriteRedirectChainsCSV(&b, chains))
+	is.Equal(b.String(), `url,hop,hop_url,status_code,location,final_url,flags
+http://example.com/old,1,http://example.com/old,301,/moved,http://example.com/new,
+http://example.com/old,2,http://example.com/moved,302,/new,http://example.com/new,
+http://example.com/loop,1,http://example.com/loop,302,/loop,http://example.com/loop,loop
+`)
+
+	b.Reset()
+	is.NoErr(WriteRedirectChainsTable(&b, chains))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	is.Equal(len(lines), 3)
+	is.True(strings.HasPrefix(lines[0], "URL"))
+	is.True(strings.Contains(lines[2], "loop"))
+}
@@ -0,0 +1,177 @@
+package sitemap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryBaseDelay is the delay before the first retry when no base delay is configured.
+const DefaultRetryBaseDelay = 500 * time.Millisecond
+
+// DefaultRetryMaxDelay is the longest delay between retries when no maximum delay is configured.
+const DefaultRetryMaxDelay = 30 * time.Second
+
+// A RetryPolicy configures how fetches which fail with a transient error are retried. A transient error is a timeout,
+// a connection failure, an error reading the response, or a 429 or 5xx response. Each URL is fetched up to MaxAttempts
+// times, so a MaxAttempts of zero or one disables retries. The delay before each retry doubles from BaseDelay up to
+// MaxDelay, with random jitter so that retries from concurrent workers are spread out. When a 429 or 503 response
+// carries a Retry-After header, the delay it asks for is used instead, again limited to MaxDelay.
+//
+// With Requeue, URLs which still fail with a transient error once their attempts are exhausted are crawled once more
+// when the rest of the crawl has finished.
+type RetryPolicy struct {
+	MaxAttempts int           `json:",omitempty"`
+	BaseDelay   time.Duration `json:",omitempty"`
+	MaxDelay    time.Duration `json:",omitempty"`
+	Requeue     bool          `json:",omitempty"`
+}
+
+// UnmarshalJSON is provided so that the delays may be given either as duration strings or as nanoseconds.
+func (p *RetryPolicy) UnmarshalJSON(b []byte) error {
+	type policy RetryPolicy
+	aux := struct {
+		*policy
+		BaseDelay json.RawMessage `json:",omitempty"`
+		MaxDelay  json.RawMessage `json:",omitempty"`
+	}{policy: (*policy)(p)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if err := unmarshalDuration(aux.BaseDelay, &p.BaseDelay); err != nil {
+		return fmt.Errorf("invalid base delay: %w", err)
+	}
+	if err := unmarshalDuration(aux.MaxDelay, &p.MaxDelay); err != nil {
+		return fmt.Errorf("invalid max delay: %w", err)
+	}
+	return nil
+}
+
+// Validate returns an error if any of the settings are out of range.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return errors.New("max attempts must not be negative")
+	}
+	if p.BaseDelay < 0 || p.MaxDelay < 0 {
+		return errors.New("retry delays must not be negative")
+	}
+	if p.BaseDelay > 0 && p.MaxDelay > 0 && p.BaseDelay > p.MaxDelay {
+		return errors.New("retry base delay must not exceed the max delay")
+	}
+	return nil
+}
+
+// withDefaults returns the policy with the default delays in place of any which are not set.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.BaseDelay == 0 {
+		p.BaseDelay = DefaultRetryBaseDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = DefaultRetryMaxDelay
+	}
+	if p.BaseDelay > p.MaxDelay {
+		p.BaseDelay = p.MaxDelay
+	}
+	return p
+}
+
+// backoff returns the delay before the given retry, counting from one. Half of the delay is fixed and half is random.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MaxDelay
+	if retry <= 30 {
+		if b := p.BaseDelay << (retry - 1); b > 0 && b < d {
+			d = b
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// delay returns the delay before the given retry of a fetch which failed with the response. A Retry-After header on a
+// 429 or 503 response takes precedence over the backoff.
+func (p RetryPolicy) delay(retry int, res *Response) time.Duration {
+	if res != nil && (res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := retryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			if d > p.MaxDelay {
+				d = p.MaxDelay
+			}
+			return d
+		}
+	}
+	return p.backoff(retry)
+}
+
+// retryAfter parses a Retry-After header value, given either as a number of seconds or as an HTTP date, into the delay
+// it asks for.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// Retry returns a Middleware which retries fetches failing with a transient error according to the RetryPolicy. The
+// Response returned records the number of attempts made. Retries stop once the context is cancelled.
+func Retry(p RetryPolicy) Middleware {
+	p = p.withDefaults()
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, u string) (*Response, error) {
+			res, err := next.Fetch(ctx, u)
+			attempts := 1
+			for ; attempts < p.MaxAttempts && err != nil && retryable(err); attempts++ {
+				d := p.delay(attempts, res)
+				log.Printf("retrying URL %s in %v after error: %v", u, d, err)
+				t := time.NewTimer(d)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return withAttempts(res, attempts), err
+				case <-t.C:
+				}
+				res, err = next.Fetch(ctx, u)
+			}
+			return withAttempts(res, attempts), err
+		})
+	}
+}
+
+// withAttempts returns a copy of the Response recording the number of attempts made, as the Response may be shared.
+func withAttempts(res *Response, attempts int) *Response {
+	if res == nil {
+		return nil
+	}
+	r := *res
+	r.Attempts = attempts
+	return &r
+}
+
+// retryable returns true if a fetch failing with the error may succeed if it is retried.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	switch classifyError(err) {
+	case ErrorClassTimeout, ErrorClassConnection, ErrorClassRead:
+		return true
+	}
+	return false
+}
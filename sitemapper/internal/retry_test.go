@@ -0,0 +1,214 @@
+package sitemap
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/matryer/is"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	data := []struct {
+		name     string
+		attempts int
+		statuses []int
+		calls    int
+		status   int
+	}{
+		{"success", 3, nil, 1, http.StatusOK},
+		{"retried 503", 3, []int{503}, 2, http.StatusOK},
+		{"retried 429", 3, []int{429, 429}, 3, http.StatusOK},
+		{"attempts exhausted", 2, []int{500, 500, 500}, 2, 500},
+		{"not retried 404", 3, []int{404}, 1, 404},
+		{"retries disabled", 0, []int{500}, 1, 500},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			cf := &countingFetcher{calls: map[string]int{}, statuses: d.statuses}
+			f := Chain(cf, Retry(RetryPolicy{MaxAttempts: d.attempts, BaseDelay: time.Millisecond}))
+			res, _ := f.Fetch(context.Background(), "http://example.com/")
+			is.Equal(cf.calls["http://example.com/"], d.calls)
+			is.Equal(res.StatusCode, d.status)
+			is.Equal(res.Attempts, d.calls)
+		})
+	}
+}
+
+func TestRetry_Cancelled(t *testing.T) {
+	is := is.New(t)
+	cf := &countingFetcher{calls: map[string]int{}, statuses: []int{500, 500}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	f := Chain(cf, Retry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Minute}))
+	start := time.Now()
+	res, err := f.Fetch(ctx, "http://example.com/")
+	is.True(err != nil)
+	is.True(time.Since(start) < time.Second)
+	is.Equal(res.Attempts, 1)
+}
+
+func TestRetry_HostLimiter(t *testing.T) {
+	is := is.New(t)
+	cf := &countingFetcher{calls: map[string]int{}, statuses: []int{500, 500}}
+	hl := NewHostLimiter(Politeness{MinDelay: 50 * time.Millisecond}, nil)
+	f := Chain(cf, Retry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}), hl.Limit())
+	start := time.Now()
+	res, err := f.Fetch(context.Background(), "http://example.com/")
+	is.NoErr(err)
+	is.Equal(res.Attempts, 3)
+	// Each retry waits for the minimum delay between requests to the host rather than the much shorter backoff
+	is.True(time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	is := is.New(t)
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}.withDefaults()
+
+	data := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		{64, 500 * time.Millisecond, time.Second},
+	}
+	for _, d := range data {
+		for i := 0; i < 20; i++ {
+			b := p.backoff(d.retry)
+			is.True(b >= d.min && b <= d.max)
+		}
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Second}
+	date := time.Now().Add(3 * time.Second).UTC().Format(http.TimeFormat)
+
+	data := []struct {
+		name     string
+		status   int
+		header   string
+		min, max time.Duration
+	}{
+		{"seconds on 429", 429, "2", 2 * time.Second, 2 * time.Second},
+		{"seconds on 503", 503, "1", time.Second, time.Second},
+		{"date on 503", 503, date, time.Second, 3 * time.Second},
+		{"capped at max delay", 429, "3600", 5 * time.Second, 5 * time.Second},
+		{"ignored on 500", 500, "2", 0, time.Millisecond},
+		{"invalid header", 503, "soon", 0, time.Millisecond},
+		{"no header", 503, "", 0, time.Millisecond},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			res := &Response{StatusCode: d.status, Header: http.Header{}}
+			if d.header != "" {
+				res.Header.Set("Retry-After", d.header)
+			}
+			delay := p.delay(1, res)
+			is.True(delay >= d.min && delay <= d.max)
+		})
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	data := []struct {
+		name   string
+		policy RetryPolicy
+		valid  bool
+	}{
+		{"empty", RetryPolicy{}, true},
+		{"full", RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Requeue: true}, true},
+		{"negative attempts", RetryPolicy{MaxAttempts: -1}, false},
+		{"negative delay", RetryPolicy{BaseDelay: -time.Second}, false},
+		{"base exceeds max", RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Second}, false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(d.policy.Validate() == nil, d.valid)
+		})
+	}
+}
+
+func TestRetryPolicy_UnmarshalJSON(t *testing.T) {
+	is := is.New(t)
+	var p RetryPolicy
+	is.NoErr(json.Unmarshal([]byte(`{"MaxAttempts": 3, "BaseDelay": "250ms", "MaxDelay": 10000000000, "Requeue": true}`), &p))
+	is.Equal(p, RetryPolicy{MaxAttempts: 3, BaseDelay: 250 * time.Millisecond, MaxDelay: 10 * time.Second, Requeue: true})
+	is.True(json.Unmarshal([]byte(`{"BaseDelay": "soon"}`), &p) != nil)
+}
+
+// flakyFetcher serves the MapFetcher pages, failing every fetch of the listed URLs with a 503 response until the
+// number of failures given has been reached.
+type flakyFetcher struct {
+	MapFetcher
+	mutex    sync.Mutex
+	failures map[string]int
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, u string) (*Response, error) {
+	f.mutex.Lock()
+	n := f.failures[u]
+	if n > 0 {
+		f.failures[u]--
+	}
+	f.mutex.Unlock()
+	if n > 0 {
+		return &Response{StatusCode: http.StatusServiceUnavailable}, &statusError{url: u, code: http.StatusServiceUnavailable}
+	}
+	return f.MapFetcher.Fetch(ctx, u)
+}
+
+func TestCrawlEngine_Requeue(t *testing.T) {
+	data := []struct {
+		name   string
+		engine func(sm *SiteMap, opts ...Option) CrawlEngine
+	}{
+		{"Synchronous crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewSynchronousCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentCrawlEngine(sm, 5, "http://example.com/", opts...)
+		}},
+		{"Concurrent Limited crawl engine", func(sm *SiteMap, opts ...Option) CrawlEngine {
+			return NewConcurrentLimitedCrawlEngine(sm, 5, "http://example.com/", NewLimiter(2), opts...)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			// Page a fails both attempts of the first visit and then succeeds when requeued, while page b never
+			// succeeds
+			f := &flakyFetcher{MapFetcher: testMapSite, failures: map[string]int{"http://example.com/a": 2, "http://example.com/b": 10}}
+			p := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, Requeue: true}
+			sm, _ := d.engine(NewSiteMap(), WithFetcher(f), WithRetryPolicy(p)).Run(context.Background())
+
+			status, _ := sm.GetStatus("http://example.com/a")
+			is.True(status.OK())
+			is.Equal(status.Attempts, 3)
+			links, _ := sm.GetLinks("http://example.com/a")
+			is.Equal(len(links), 2)
+
+			// The links found on the requeued page are crawled
+			status, _ = sm.GetStatus("http://example.com/c")
+			is.True(status.OK())
+
+			status, _ = sm.GetStatus("http://example.com/b")
+			is.Equal(status.StatusCode, http.StatusServiceUnavailable)
+			is.Equal(status.Attempts, 4)
+			_, exists := sm.GetLinks("http://example.com/b")
+			is.True(exists)
+		})
+	}
+}
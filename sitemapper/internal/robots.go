@@ -0,0 +1,324 @@
+package sitemap
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserAgent is the user agent sent with each request and used to select the applicable robots.txt group.
+const DefaultUserAgent = "sitemapper"
+
+// robotsRule is a single Allow or Disallow line from a robots.txt group.
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsGroup is the set of rules which apply to one or more user agents.
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// Robots holds the parsed contents of a robots.txt file.
+// Rule matching follows RFC 9309: the most specific (longest) matching rule wins, and an Allow rule wins a tie.
+type Robots struct {
+	groups      []*robotsGroup
+	disallowAll bool
+	// Sitemaps contains the URLs of any "Sitemap:" lines found in the file.
+	Sitemaps []string
+}
+
+// ParseRobots reads a robots.txt document and returns the parsed groups and rules.
+// Unknown or malformed lines are ignored, as per RFC 9309.
+func ParseRobots(r io.Reader) (*Robots, error) {
+	rb := &Robots{}
+	var current *robotsGroup
+	// inAgents is true while we are reading consecutive User-agent lines which all belong to the same group.
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &robotsGroup{}
+				rb.groups = append(rb.groups, current)
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			// An empty Disallow matches nothing, so the rule can be dropped
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs < 0 {
+				continue
+			}
+			current.crawlDelay = time.Duration(secs * float64(time.Second))
+		case "sitemap":
+			if value != "" {
+				rb.Sitemaps = append(rb.Sitemaps, value)
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rb, nil
+}
+
+// productToken returns the lower case product name from a user agent string, e.g. "sitemapper" for "sitemapper/1.0".
+func productToken(userAgent string) string {
+	token := strings.ToLower(strings.TrimSpace(userAgent))
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return token
+}
+
+// rulesFor returns the rules applicable to the given user agent. Rules from all groups naming the user agent are
+// combined. If no group names the user agent the rules from the "*" groups are returned.
+func (r *Robots) rulesFor(userAgent string) ([]robotsRule, time.Duration) {
+	token := productToken(userAgent)
+	var matched, wildcard []robotsRule
+	var matchedDelay, wildcardDelay time.Duration
+	foundMatch := false
+
+	for _, g := range r.groups {
+		isMatch, isWildcard := false, false
+		for _, a := range g.agents {
+			switch a {
+			case token:
+				isMatch = true
+			case "*":
+				isWildcard = true
+			}
+		}
+		switch {
+		case isMatch:
+			foundMatch = true
+			matched = append(matched, g.rules...)
+			if g.crawlDelay > matchedDelay {
+				matchedDelay = g.crawlDelay
+			}
+		case isWildcard:
+			wildcard = append(wildcard, g.rules...)
+			if g.crawlDelay > wildcardDelay {
+				wildcardDelay = g.crawlDelay
+			}
+		}
+	}
+
+	if foundMatch {
+		return matched, matchedDelay
+	}
+	return wildcard, wildcardDelay
+}
+
+// Allowed reports whether the given user agent may fetch the URL. The URL may be absolute or just a path.
+func (r *Robots) Allowed(userAgent, u string) bool {
+	if r.disallowAll {
+		return false
+	}
+
+	p := "/"
+	if pu, err := url.Parse(u); err == nil {
+		p = pu.EscapedPath()
+		if p == "" {
+			p = "/"
+		}
+		if pu.RawQuery != "" {
+			p += "?" + pu.RawQuery
+		}
+	}
+	if p == "/robots.txt" {
+		return true
+	}
+
+	rules, _ := r.rulesFor(userAgent)
+	allowed := true
+	longest := -1
+	for _, rule := range rules {
+		if !matchRobotsPattern(rule.pattern, p) {
+			continue
+		}
+		l := len(rule.pattern)
+		if l > longest || (l == longest && rule.allow) {
+			longest = l
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay returns the Crawl-delay requested for the given user agent, or zero if none was specified.
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	_, d := r.rulesFor(userAgent)
+	return d
+}
+
+// matchRobotsPattern reports whether a robots.txt path pattern matches the path. A "*" in the pattern matches any
+// sequence of characters and a trailing "$" anchors the pattern to the end of the path. Patterns without a "$" are
+// prefix matches.
+func matchRobotsPattern(pattern, p string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+	// The first part must match at the start of the path
+	if !strings.HasPrefix(p, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+
+	for i := 1; i < len(parts); i++ {
+		part := parts[i]
+		if i == len(parts)-1 && anchored {
+			return len(p)-pos >= len(part) && strings.HasSuffix(p, part)
+		}
+		idx := strings.Index(p[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+
+	return !anchored || pos == len(p)
+}
+
+// robotsEntry is the cache entry for a single host. The sync.Once ensures robots.txt is only fetched once per host,
+// even when many goroutines ask for it at the same time.
+type robotsEntry struct {
+	once   sync.Once
+	robots *Robots
+}
+
+// A RobotsChecker fetches, parses and caches the robots.txt file for each host encountered during a crawl.
+type RobotsChecker struct {
+	userAgent string
+	client    *HTTPClient
+	mutex     sync.Mutex
+	cache     map[string]*robotsEntry
+}
+
+// NewRobotsChecker returns a RobotsChecker which evaluates rules for the given user agent.
+func NewRobotsChecker(userAgent string) *RobotsChecker {
+	return NewRobotsCheckerWithClient(defaultHTTPClient(userAgent))
+}
+
+// NewRobotsCheckerWithClient returns a RobotsChecker which fetches robots.txt files using the HTTPClient, and
+// evaluates rules for the client's user agent.
+func NewRobotsCheckerWithClient(h *HTTPClient) *RobotsChecker {
+	return &RobotsChecker{
+		userAgent: h.UserAgent(),
+		client:    h,
+		cache:     map[string]*robotsEntry{},
+	}
+}
+
+// Get returns the robots.txt rules for the host of the provided URL, fetching the file if it has not yet been seen.
+// If the context is cancelled while the file is fetched, the host is treated as disallowed.
+func (rc *RobotsChecker) Get(ctx context.Context, u string) (*Robots, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	key := pu.Scheme + "://" + pu.Host
+
+	rc.mutex.Lock()
+	e, ok := rc.cache[key]
+	if !ok {
+		e = &robotsEntry{}
+		rc.cache[key] = e
+	}
+	rc.mutex.Unlock()
+
+	e.once.Do(func() {
+		e.robots = rc.fetch(ctx, key+"/robots.txt")
+	})
+	return e.robots, nil
+}
+
+// Allowed reports whether the URL may be crawled according to the robots.txt of its host.
+func (rc *RobotsChecker) Allowed(ctx context.Context, u string) bool {
+	r, err := rc.Get(ctx, u)
+	if err != nil {
+		log.Printf("error checking robots.txt for URL %s: %v", u, err)
+		return false
+	}
+	return r.Allowed(rc.userAgent, u)
+}
+
+// CrawlDelay returns the Crawl-delay for the host of the provided URL, or zero if none was specified.
+func (rc *RobotsChecker) CrawlDelay(ctx context.Context, u string) time.Duration {
+	r, err := rc.Get(ctx, u)
+	if err != nil {
+		return 0
+	}
+	return r.CrawlDelay(rc.userAgent)
+}
+
+// fetch retrieves and parses a robots.txt file. As per RFC 9309 a 4xx response means there are no restrictions,
+// while a server error or an unreachable host means the whole site is treated as disallowed.
+func (rc *RobotsChecker) fetch(ctx context.Context, u string) *Robots {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		log.Printf("error creating robots.txt request for %s: %v", u, err)
+		return &Robots{disallowAll: true}
+	}
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		log.Printf("error retrieving %s, treating site as disallowed: %v", u, err)
+		return &Robots{disallowAll: true}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		r, err := ParseRobots(io.LimitReader(resp.Body, 500*1024))
+		if err != nil {
+			log.Printf("error parsing %s: %v", u, err)
+			return &Robots{}
+		}
+		return r
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &Robots{}
+	default:
+		log.Printf("received HTTP response code %d for %s, treating site as disallowed", resp.StatusCode, u)
+		return &Robots{disallowAll: true}
+	}
+}
@@ -0,0 +1,178 @@
+package sitemap
+
+import (
+	"context"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobots = `# example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public.html
+Disallow: /*.pdf$
+Disallow: /search?
+Crawl-delay: 2
+
+User-agent: otherbot
+User-agent: SiteMapper
+Disallow: /aubergine
+Allow: /aubergine/lemon.html
+Crawl-delay: 0.5
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestParseRobots(t *testing.T) {
+	is := is.New(t)
+	r, err := ParseRobots(strings.NewReader(testRobots))
+	is.NoErr(err)
+	is.Equal(len(r.groups), 2)
+	is.Equal(r.groups[1].agents, []string{"otherbot", "sitemapper"})
+	is.Equal(r.Sitemaps, []string{"https://example.com/sitemap.xml"})
+	is.Equal(r.CrawlDelay("sitemapper/1.0"), 500*time.Millisecond)
+	is.Equal(r.CrawlDelay("anotherbot"), 2*time.Second)
+}
+
+func TestParseRobots_WildcardBeforeAgent(t *testing.T) {
+	is := is.New(t)
+	r, err := ParseRobots(strings.NewReader(`User-agent: *
+User-agent: sitemapper
+Disallow: /shared/
+Crawl-delay: 1
+
+User-agent: *
+Disallow: /everyone/
+Crawl-delay: 3
+`))
+	is.NoErr(err)
+	is.Equal(r.groups[0].agents, []string{"*", "sitemapper"})
+	// The first group names sitemapper, so the second group's wildcard rules do not apply to it
+	is.True(!r.Allowed("sitemapper/1.0", "/shared/page.html"))
+	is.True(r.Allowed("sitemapper/1.0", "/everyone/page.html"))
+	is.Equal(r.CrawlDelay("sitemapper/1.0"), time.Second)
+	is.True(!r.Allowed("anotherbot", "/shared/page.html"))
+	is.True(!r.Allowed("anotherbot", "/everyone/page.html"))
+	is.Equal(r.CrawlDelay("anotherbot"), 3*time.Second)
+}
+
+func TestRobots_Allowed(t *testing.T) {
+	r, err := ParseRobots(strings.NewReader(testRobots))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		name      string
+		userAgent string
+		url       string
+		allowed   bool
+	}{
+		{"no matching rule", "anotherbot", "https://example.com/index.html", true},
+		{"disallowed prefix", "anotherbot", "https://example.com/private/secret.html", false},
+		{"longer allow wins", "anotherbot", "https://example.com/private/public.html", true},
+		{"wildcard with end anchor", "anotherbot", "https://example.com/docs/manual.pdf", false},
+		{"end anchor not matched", "anotherbot", "https://example.com/docs/manual.pdf.html", true},
+		{"query string", "anotherbot", "https://example.com/search?q=foo", false},
+		{"robots.txt always allowed", "anotherbot", "https://example.com/robots.txt", true},
+		{"specific group replaces wildcard", "sitemapper", "https://example.com/private/secret.html", true},
+		{"specific group disallow", "sitemapper", "https://example.com/aubergine/cabbage/banana.html", false},
+		{"specific group allow", "Sitemapper/2.0", "https://example.com/aubergine/lemon.html", true},
+		{"path only", "anotherbot", "/private/", false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(r.Allowed(d.userAgent, d.url), d.allowed)
+		})
+	}
+}
+
+func Test_matchRobotsPattern(t *testing.T) {
+	data := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.html", false},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fish/", false},
+		{"/*.php", "/folder/filename.php?parameters", true},
+		{"/*.php$", "/filename.php?parameters", false},
+		{"/fish*.php", "/fishheads/catfish.php", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"*", "/", true},
+	}
+
+	is := is.New(t)
+	for _, d := range data {
+		is.Equal(matchRobotsPattern(d.pattern, d.path), d.matches)
+	}
+}
+
+func TestRobotsChecker_Allowed(t *testing.T) {
+	data := []struct {
+		name       string
+		statusCode int
+		body       string
+		allowed    bool
+	}{
+		{"disallowed by rules", 200, "User-agent: *\nDisallow: /page", false},
+		{"not found allows all", 404, "", true},
+		{"server error disallows all", 503, "", false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				is.Equal(r.URL.Path, "/robots.txt")
+				is.Equal(r.Header.Get("User-Agent"), DefaultUserAgent)
+				requests++
+				w.WriteHeader(d.statusCode)
+				_, err := w.Write([]byte(d.body))
+				is.NoErr(err)
+			}))
+			defer srv.Close()
+
+			rc := NewRobotsChecker(DefaultUserAgent)
+			is.Equal(rc.Allowed(context.Background(), srv.URL+"/page"), d.allowed)
+			is.Equal(rc.Allowed(context.Background(), srv.URL+"/page"), d.allowed)
+			is.Equal(requests, 1) // robots.txt should only be fetched once per host
+		})
+	}
+}
+
+func Test_getLinks_RobotsDisallowed(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			_, err := w.Write([]byte("User-agent: *\nDisallow: /private"))
+			is.NoErr(err)
+			return
+		}
+		t.Errorf("unexpected request for disallowed path %s", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	sm := NewSiteMap()
+	c := NewSynchronousCrawlEngine(sm, 1, srv.URL, WithRobots(NewRobotsChecker(DefaultUserAgent)))
+	u := srv.URL + "/private/page.html"
+	links, exists := c.getLinks(context.Background(), u, srv.URL, srv.URL, 0)
+
+	is.Equal(links, nil)
+	is.True(!exists)
+	_, visited := sm.GetLinks(u)
+	is.True(!visited)
+	reason, skipped := sm.GetSkipped(u)
+	is.True(skipped)
+	is.Equal(reason, SkipReasonRobots)
+}
@@ -0,0 +1,228 @@
+package sitemap
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// SkipReasonScope is recorded against URLs which were not visited because they are outside the crawl scope.
+const SkipReasonScope = "out of scope"
+
+// Prefixes selecting the type of a scope rule. Rules without a prefix are glob patterns.
+const (
+	ScopeRegex  = "regex:"
+	ScopePrefix = "prefix:"
+	ScopeExt    = "ext:"
+)
+
+// Host scope modes, selecting which hosts are crawled in addition to the host of the start URL.
+const (
+	HostScopeExact  = "host"
+	HostScopeDomain = "domain"
+	HostScopeList   = "hosts"
+)
+
+// A HostScope decides which hosts may be crawled. In the HostScopeExact mode (the default) only the host of the start
+// URL is crawled. In the HostScopeDomain mode the registrable domain of the start URL, found using the public suffix
+// list, is crawled along with all of its subdomains, so crawling "www.example.com" also crawls "example.com" and
+// "docs.example.com". In the HostScopeList mode the hosts in Hosts are crawled as well as the host of the start URL.
+// A listed host of the form "*.example.com" matches all subdomains of example.com.
+type HostScope struct {
+	Mode  string
+	Hosts []string
+}
+
+// Validate returns an error if the mode is not supported, or if no hosts are listed for the HostScopeList mode.
+func (h HostScope) Validate() error {
+	switch h.Mode {
+	case "", HostScopeExact, HostScopeDomain:
+	case HostScopeList:
+		if len(h.Hosts) == 0 {
+			return errors.New("hosts must be listed for the hosts scope mode")
+		}
+	default:
+		return errors.New("unsupported host scope")
+	}
+	return nil
+}
+
+// matcher returns a function which reports whether a host, normalized to lower case without a default port, is within
+// the scope for the root URL.
+func (h HostScope) matcher(root *url.URL) func(host string) bool {
+	rootHost := strings.ToLower(root.Host)
+	switch h.Mode {
+	case HostScopeDomain:
+		// IP addresses and hosts such as localhost have no registrable domain, so only the host itself is crawled
+		domain, ok := registrableDomain(root.Hostname())
+		if !ok {
+			break
+		}
+		return func(host string) bool {
+			name := hostname(host)
+			return name == domain || strings.HasSuffix(name, "."+domain)
+		}
+	case HostScopeList:
+		hosts := make([]string, 0, len(h.Hosts))
+		for _, l := range h.Hosts {
+			hosts = append(hosts, strings.ToLower(strings.TrimSpace(l)))
+		}
+		return func(host string) bool {
+			if host == rootHost {
+				return true
+			}
+			for _, l := range hosts {
+				if host == l || (strings.HasPrefix(l, "*.") && strings.HasSuffix(host, l[1:])) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(host string) bool { return host == rootHost }
+}
+
+// registrableDomain returns the registrable domain (eTLD+1) of a host name, found using the public suffix list, such as
+// "example.co.uk" for "www.example.co.uk". False is returned for IP addresses and hosts without a registrable domain.
+func registrableDomain(hostname string) (string, bool) {
+	if net.ParseIP(hostname) != nil {
+		return "", false
+	}
+	d, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(hostname))
+	if err != nil {
+		return "", false
+	}
+	return d, true
+}
+
+// hostname returns the host without any port.
+func hostname(host string) string {
+	return (&url.URL{Host: host}).Hostname()
+}
+
+// A Scope decides which URLs may be crawled using include and exclude rules. A URL is in scope if it matches at least
+// one include rule, or there are no include rules, and it matches none of the exclude rules.
+//
+// Each rule is one of:
+//   - a glob pattern matched against the URL path, such as "/docs/**" or "/admin". "*" matches within a path segment
+//     and "**" matches across segments. A pattern also matches everything below the paths it matches, so "/admin"
+//     matches "/admin/users". Patterns without a "/", such as "*.pdf", are matched against the last path segment.
+//   - "regex:" followed by a regular expression, matched against the full URL.
+//   - "prefix:" followed by a path prefix, such as "prefix:/docs/", or a full URL prefix including the scheme.
+//   - "ext:" followed by a file extension, such as "ext:pdf". Extensions are not case sensitive.
+type Scope struct {
+	include []scopeRule
+	exclude []scopeRule
+}
+
+// A scopeRule returns true if the URL matches the rule.
+type scopeRule func(u *url.URL) bool
+
+// NewScope returns a Scope for the include and exclude rules. An error is returned if any rule is invalid.
+func NewScope(include, exclude []string) (*Scope, error) {
+	s := &Scope{}
+	for _, r := range include {
+		rule, err := parseScopeRule(r)
+		if err != nil {
+			return nil, err
+		}
+		s.include = append(s.include, rule)
+	}
+	for _, r := range exclude {
+		rule, err := parseScopeRule(r)
+		if err != nil {
+			return nil, err
+		}
+		s.exclude = append(s.exclude, rule)
+	}
+	return s, nil
+}
+
+// Allowed returns true if the URL is in scope. URLs which cannot be parsed are not in scope.
+func (s *Scope) Allowed(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	if len(s.include) > 0 && !matchRules(s.include, pu) {
+		return false
+	}
+	return !matchRules(s.exclude, pu)
+}
+
+// matchRules returns true if the URL matches any of the rules.
+func matchRules(rules []scopeRule, u *url.URL) bool {
+	for _, r := range rules {
+		if r(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopeRule converts the text of a scope rule into a scopeRule.
+func parseScopeRule(rule string) (scopeRule, error) {
+	switch {
+	case rule == "":
+		return nil, fmt.Errorf("empty scope rule")
+	case strings.HasPrefix(rule, ScopeRegex):
+		re, err := regexp.Compile(strings.TrimPrefix(rule, ScopeRegex))
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope rule %q: %w", rule, err)
+		}
+		return func(u *url.URL) bool { return re.MatchString(u.String()) }, nil
+	case strings.HasPrefix(rule, ScopePrefix):
+		prefix := strings.TrimPrefix(rule, ScopePrefix)
+		if strings.Contains(prefix, "://") {
+			return func(u *url.URL) bool { return strings.HasPrefix(u.String(), prefix) }, nil
+		}
+		return func(u *url.URL) bool { return strings.HasPrefix(pathOf(u), prefix) }, nil
+	case strings.HasPrefix(rule, ScopeExt):
+		ext := "." + strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(rule, ScopeExt), "."))
+		return func(u *url.URL) bool { return strings.ToLower(path.Ext(u.Path)) == ext }, nil
+	}
+
+	re, err := regexp.Compile(globPattern(rule))
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope rule %q: %w", rule, err)
+	}
+	if !strings.Contains(rule, "/") {
+		return func(u *url.URL) bool { return re.MatchString(path.Base(pathOf(u))) }, nil
+	}
+	return func(u *url.URL) bool { return re.MatchString(pathOf(u)) }, nil
+}
+
+// globPattern converts a glob pattern into an anchored regular expression which also matches any path below the
+// paths matched by the glob.
+func globPattern(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("(/.*)?$")
+	return b.String()
+}
+
+// pathOf returns the path of the URL, which is "/" for a URL without a path.
+func pathOf(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
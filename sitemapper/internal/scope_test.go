@@ -0,0 +1,148 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestScope_Allowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		url     string
+		want    bool
+	}{
+		{"no rules", nil, nil, "https://example.com/a", true},
+		{"glob subtree", []string{"/docs/**"}, nil, "https://example.com/docs/guide/intro", true},
+		{"glob subtree outside", []string{"/docs/**"}, nil, "https://example.com/blog/post", false},
+		{"glob segment", []string{"/docs/*"}, nil, "https://example.com/docs/guide", true},
+		{"glob matches below", nil, []string{"/admin"}, "https://example.com/admin/users", false},
+		{"glob not a prefix", nil, []string{"/admin"}, "https://example.com/administration", true},
+		{"glob base name", nil, []string{"*.pdf"}, "https://example.com/files/report.pdf", false},
+		{"glob base name other", nil, []string{"*.pdf"}, "https://example.com/files/report.html", true},
+		{"glob single character", nil, []string{"/page?"}, "https://example.com/page2", false},
+		{"regex", []string{"regex:^https://example\\.com/(en|fr)/"}, nil, "https://example.com/fr/page", true},
+		{"regex outside", []string{"regex:^https://example\\.com/(en|fr)/"}, nil, "https://example.com/de/page", false},
+		{"regex query", nil, []string{"regex:[?&]sort="}, "https://example.com/list?page=2&sort=asc", false},
+		{"path prefix", []string{"prefix:/docs"}, nil, "https://example.com/docs-v2/a", true},
+		{"url prefix", []string{"prefix:https://example.com/docs/"}, nil, "http://example.com/docs/a", false},
+		{"root path", []string{"prefix:/"}, nil, "https://example.com/", true},
+		{"extension", nil, []string{"ext:pdf", "ext:.zip"}, "https://example.com/a/B.ZIP", false},
+		{"extension other", nil, []string{"ext:pdf"}, "https://example.com/a/pdf", true},
+		{"exclude wins", []string{"/docs/**"}, []string{"/docs/private"}, "https://example.com/docs/private/a", false},
+		{"any include", []string{"/docs/**", "/blog/**"}, nil, "https://example.com/blog/post", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			s, err := NewScope(tt.include, tt.exclude)
+			is.NoErr(err)
+			is.Equal(s.Allowed(tt.url), tt.want)
+		})
+	}
+}
+
+func TestNewScope_Invalid(t *testing.T) {
+	is := is.New(t)
+	_, err := NewScope([]string{"regex:("}, nil)
+	is.True(err != nil)
+	_, err = NewScope(nil, []string{""})
+	is.True(err != nil)
+}
+
+func TestCrawlEngine_Scope(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<a href="/docs/a">a</a><a href="/docs/private/b">b</a><a href="/blog/c">c</a>`)
+	}))
+	defer srv.Close()
+
+	s, err := NewScope([]string{"/docs/**"}, []string{"/docs/private"})
+	is.NoErr(err)
+	sm := NewSiteMap()
+	c := NewSynchronousCrawlEngine(sm, 3, srv.URL+"/docs/", WithScope(s))
+	c.Run(context.Background())
+
+	_, visited := sm.GetLinks(srv.URL + "/docs/a")
+	is.True(visited)
+	for _, u := range []string{srv.URL + "/docs/private/b", srv.URL + "/blog/c"} {
+		_, visited := sm.GetLinks(u)
+		is.True(!visited)
+		reason, skipped := sm.GetSkipped(u)
+		is.True(skipped)
+		is.Equal(reason, SkipReasonScope)
+	}
+}
+
+func TestHostScope_matcher(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope HostScope
+		root  string
+		host  string
+		want  bool
+	}{
+		{"exact", HostScope{}, "https://www.example.com/", "www.example.com", true},
+		{"exact other", HostScope{Mode: HostScopeExact}, "https://www.example.com/", "example.com", false},
+		{"domain apex", HostScope{Mode: HostScopeDomain}, "https://www.example.com/", "example.com", true},
+		{"domain subdomain", HostScope{Mode: HostScopeDomain}, "https://www.example.com/", "docs.example.com", true},
+		{"domain other", HostScope{Mode: HostScopeDomain}, "https://www.example.com/", "badexample.com", false},
+		{"domain public suffix", HostScope{Mode: HostScopeDomain}, "https://www.example.co.uk", "shop.example.co.uk", true},
+		{"domain sibling", HostScope{Mode: HostScopeDomain}, "https://www.example.co.uk", "other.co.uk", false},
+		{"domain ip", HostScope{Mode: HostScopeDomain}, "http://127.0.0.1:8080", "127.0.0.1:8080", true},
+		{"domain other ip", HostScope{Mode: HostScopeDomain}, "http://127.0.0.1:8080", "10.0.0.1:8080", false},
+		{"list root", HostScope{Mode: HostScopeList, Hosts: []string{"blog.example.org"}}, "https://example.com/", "example.com", true},
+		{"list host", HostScope{Mode: HostScopeList, Hosts: []string{"Blog.Example.org"}}, "https://example.com/", "blog.example.org", true},
+		{"list wildcard", HostScope{Mode: HostScopeList, Hosts: []string{"*.example.org"}}, "https://example.com/", "a.b.example.org", true},
+		{"list other", HostScope{Mode: HostScopeList, Hosts: []string{"*.example.org"}}, "https://example.com/", "example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.NoErr(tt.scope.Validate())
+			r, err := url.Parse(tt.root)
+			is.NoErr(err)
+			is.Equal(tt.scope.matcher(r)(tt.host), tt.want)
+		})
+	}
+}
+
+func TestHostScope_Validate(t *testing.T) {
+	is := is.New(t)
+	is.True(HostScope{Mode: "subdomains"}.Validate() != nil)
+	is.True(HostScope{Mode: HostScopeList}.Validate() != nil)
+}
+
+func TestCrawlEngine_ExternalLinks(t *testing.T) {
+	is := is.New(t)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<a href="/b">b</a>`)
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="/a">a</a><a href="%s/x">x</a><a href="https://example.com/#top">e</a>`, other.URL)
+	}))
+	defer srv.Close()
+
+	sm := NewSiteMap()
+	c := NewSynchronousCrawlEngine(sm, 3, srv.URL, WithExternalLinks())
+	c.Run(context.Background())
+	is.Equal(sm.GetExternalLinks(srv.URL), []string{other.URL + "/x", "https://example.com/"})
+	_, visited := sm.GetLinks(other.URL + "/x")
+	is.True(!visited)
+
+	// The other server is crawled when its host is allowed
+	otherURL, err := url.Parse(other.URL)
+	is.NoErr(err)
+	sm = NewSiteMap()
+	c = NewSynchronousCrawlEngine(sm, 4, srv.URL, WithHostScope(HostScope{Mode: HostScopeList, Hosts: []string{otherURL.Host}}))
+	c.Run(context.Background())
+	_, visited = sm.GetLinks(other.URL + "/b")
+	is.True(visited)
+}
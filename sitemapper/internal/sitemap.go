@@ -2,8 +2,11 @@ package sitemap
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"sort"
 	"sync"
+	"time"
 )
 
 // links is a type definition which is used internally as a list of links found at a URL.
@@ -13,24 +16,98 @@ type links map[string]struct{}
 // linkMap is the collection of all unique URLs and the links found at each URL
 type linkMap map[string]links
 
+// SkipReasonRobots is recorded against URLs which were not visited because robots.txt disallows them.
+const SkipReasonRobots = "robots.txt"
+
+// skippedMap is the collection of URLs which were deliberately not visited, along with the reason for skipping.
+type skippedMap map[string]string
+
 // A SiteMap is the data structure used to store a list of links found at crawled URLs. A sync.RWMutex provides
 // access control to the internal map.
 type SiteMap struct {
-	mutex sync.RWMutex
-	lm    linkMap
+	mutex        sync.RWMutex
+	lm           linkMap
+	assets       linkMap
+	external     linkMap
+	skipped      skippedMap
+	lastModified map[string]time.Time
+	status       map[string]URLStatus
+	robots       map[string][]string
+	canonical    map[string]string
+	redirected   map[string]URLStatus
+	discovered   map[string]Discovered
+	inSitemap    links
+	truncated    bool
+	normalizer   *Normalizer
+	order        string
 }
 
 // NewSiteMap returns an SiteMap instance with an empty sitemap map, ready for URLs and links to be added.
+// URLs are normalized using the default Normalizer, so equivalent URLs share a single entry.
 func NewSiteMap() *SiteMap {
-	return &SiteMap{lm: linkMap{}}
+	return &SiteMap{
+		lm:           linkMap{},
+		assets:       linkMap{},
+		external:     linkMap{},
+		skipped:      skippedMap{},
+		lastModified: map[string]time.Time{},
+		status:       map[string]URLStatus{},
+		robots:       map[string][]string{},
+		canonical:    map[string]string{},
+		redirected:   map[string]URLStatus{},
+		discovered:   map[string]Discovered{},
+		inSitemap:    links{},
+		normalizer:   NewNormalizer(),
+	}
+}
+
+// The orders in which the results of a SiteMap can be sorted when it is written as JSON. Results sorted by depth are
+// sorted by URL within each depth.
+const (
+	SortByURL   = "url"
+	SortByDepth = "depth"
+)
+
+// SetSortOrder sets the order of the results when the SiteMap is written as JSON, which is SortByURL by default.
+func (sm *SiteMap) SetSortOrder(order string) error {
+	switch order {
+	case SortByURL, SortByDepth:
+	default:
+		return fmt.Errorf("unsupported sort order %q", order)
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.order = order
+	return nil
+}
+
+// SetNormalizer replaces the Normalizer used for the URLs recorded in the SiteMap. It must be called before any URLs
+// are added.
+func (sm *SiteMap) SetNormalizer(n *Normalizer) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.normalizer = n
+}
+
+// key returns the normalized form of a URL used as the key for each of the internal maps.
+func (sm *SiteMap) key(u string) string {
+	if sm.normalizer == nil {
+		return u
+	}
+	return sm.normalizer.Normalize(u)
 }
 
 // GetLinks returns the slice of links available for a given URL key. If the URL exists in the internal map the links
-// are returned to the caller along with a boolean with a value of true.
+// are returned to the caller along with a boolean with a value of true. The links of a URL which was recorded under the
+// final URL of its redirect chain are those of the final URL.
 // If the key is not found in the map a nil slice is returned along with a boolean value of false.
 func (sm *SiteMap) GetLinks(u string) ([]string, bool) {
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	if s, ok := sm.redirected[u]; ok {
+		u = sm.key(s.FinalURL)
+	}
 	urlMap, exists := sm.lm[u]
 	if !exists {
 		return nil, false
@@ -49,42 +126,408 @@ func (sm *SiteMap) GetLinks(u string) ([]string, bool) {
 func (sm *SiteMap) AddURL(u string) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+	u = sm.key(u)
 	sm.lm[u] = links{}
 }
 
+// removeURL removes a visited URL, along with its links, so that it may be visited again.
+func (sm *SiteMap) removeURL(u string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	delete(sm.lm, u)
+}
+
+// AddRedirect records that a URL redirected to a final URL, which is crawled in its place. The URL is removed along
+// with its links, and the status holding its redirect chain is kept for the redirect report. Links to the URL are
+// reported as links to the final URL.
+func (sm *SiteMap) AddRedirect(u string, s URLStatus) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	delete(sm.lm, u)
+	sm.redirected[u] = s
+}
+
+// AddSkippedURL records a URL which was found but deliberately not visited, along with the reason it was skipped.
+func (sm *SiteMap) AddSkippedURL(u, reason string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	sm.skipped[u] = reason
+}
+
+// GetSkipped returns the reason a URL was skipped. If the URL was not skipped an empty string and false are returned.
+func (sm *SiteMap) GetSkipped(u string) (string, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	reason, exists := sm.skipped[u]
+	return reason, exists
+}
+
+// SetLastModified records the Last-Modified time reported by the server for a URL.
+func (sm *SiteMap) SetLastModified(u string, t time.Time) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	sm.lastModified[u] = t
+}
+
+// SetStatus records the outcome of fetching a crawled URL.
+func (sm *SiteMap) SetStatus(u string, s URLStatus) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	sm.status[u] = s
+}
+
+// GetStatus returns the outcome of fetching a crawled URL. If no status has been recorded for the URL an empty
+// URLStatus and false are returned.
+func (sm *SiteMap) GetStatus(u string) (URLStatus, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	s, exists := sm.status[u]
+	return s, exists
+}
+
+// Discovered records how a crawled URL was found: the minimum depth at which the crawl reached it, the Parent page
+// which first linked to it at that depth, and the Time it was first found at any depth. URLs crawled from depth zero,
+// such as the start URL and sitemap seeds, have no parent. Following the Parent of each URL reconstructs the tree of
+// pages the crawl visited, as opposed to the full link graph.
+type Discovered struct {
+	Depth  int
+	Parent string
+	Time   time.Time
+}
+
+// SetDiscovered records that the crawl reached a URL from the parent at the given depth. Only the shallowest depth
+// and its first parent are kept, along with the time the URL was first reached.
+func (sm *SiteMap) SetDiscovered(u, parent string, depth int) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	if depth == 0 {
+		parent = ""
+	} else {
+		parent = sm.key(parent)
+	}
+	d, exists := sm.discovered[u]
+	if !exists {
+		sm.discovered[u] = Discovered{Depth: depth, Parent: parent, Time: time.Now()}
+		return
+	}
+	if depth < d.Depth {
+		d.Depth, d.Parent = depth, parent
+		sm.discovered[u] = d
+	}
+}
+
+// GetDiscovered returns how a URL was found. If the URL has not been reached a zero Discovered and false are returned.
+func (sm *SiteMap) GetDiscovered(u string) (Discovered, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	d, exists := sm.discovered[u]
+	return d, exists
+}
+
+// SetRobotsDirectives records the robots directives, such as noindex and nofollow, carried by a crawled URL in its
+// robots meta elements and X-Robots-Tag headers.
+func (sm *SiteMap) SetRobotsDirectives(u string, directives []string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	sm.robots[u] = directives
+}
+
+// GetRobotsDirectives returns the robots directives recorded for a crawled URL.
+func (sm *SiteMap) GetRobotsDirectives(u string) []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	return sm.robots[u]
+}
+
+// SetCanonical records the canonical URL declared by a crawled URL using <link rel="canonical">, when it differs from
+// the crawled URL.
+func (sm *SiteMap) SetCanonical(u, canonical string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u, canonical = sm.key(u), sm.key(canonical)
+	if u != canonical {
+		sm.canonical[u] = canonical
+	}
+}
+
+// SetTruncated marks the SiteMap as holding partial results because the crawl was cancelled or timed out.
+func (sm *SiteMap) SetTruncated() {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.truncated = true
+}
+
+// Truncated returns true if the crawl was cancelled or timed out before it was complete.
+func (sm *SiteMap) Truncated() bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.truncated
+}
+
+// AddSitemapURLs records URLs which were listed in the site's own sitemap files.
+func (sm *SiteMap) AddSitemapURLs(urls []string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	for _, u := range urls {
+		sm.inSitemap[sm.key(u)] = struct{}{}
+	}
+}
+
+// A Discovery report compares the URLs listed in the site's sitemap files with the URLs found by following links.
+// SitemapOnly contains URLs which no crawled page links to (orphan pages), and LinkOnly contains linked URLs which are
+// missing from the sitemap files.
+type Discovery struct {
+	SitemapOnly []string
+	LinkOnly    []string
+}
+
+// Discovery returns a report of the URLs found only via sitemap files and only via links, each sorted by URL.
+func (sm *SiteMap) Discovery() Discovery {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.discovery()
+}
+
+// discovery builds the Discovery report. The caller must hold the mutex.
+func (sm *SiteMap) discovery() Discovery {
+	linked := links{}
+	for _, ls := range sm.lm {
+		for l := range ls {
+			linked[l] = struct{}{}
+		}
+	}
+
+	d := Discovery{SitemapOnly: make([]string, 0), LinkOnly: make([]string, 0)}
+	for u := range sm.inSitemap {
+		if _, ok := linked[u]; !ok {
+			d.SitemapOnly = append(d.SitemapOnly, u)
+		}
+	}
+	for u := range linked {
+		if _, ok := sm.inSitemap[u]; !ok {
+			d.LinkOnly = append(d.LinkOnly, u)
+		}
+	}
+	sort.Strings(d.SitemapOnly)
+	sort.Strings(d.LinkOnly)
+
+	return d
+}
+
+// SitemapURLs returns an entry for each crawled URL, sorted by URL, suitable for encoding as an XML sitemap.
+// Only URLs which are Indexable are included, and URLs carrying a noindex robots directive or declaring a different
+// canonical URL are excluded.
+func (sm *SiteMap) SitemapURLs() []SitemapURL {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	urls := make([]SitemapURL, 0, len(sm.lm))
+	for u := range sm.lm {
+		if listed(sm.status[u], sm.robots[u], sm.canonical[u]) {
+			urls = append(urls, SitemapURL{Loc: u, LastMod: sm.lastModified[u]})
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].Loc < urls[j].Loc })
+
+	return urls
+}
+
+// ResultSitemapURLs returns an entry for each of the results, sorted by URL, suitable for encoding as an XML sitemap.
+// The results are filtered in the same way as by SiteMap.SitemapURLs.
+func ResultSitemapURLs(results []Result) []SitemapURL {
+	urls := make([]SitemapURL, 0, len(results))
+	for _, r := range results {
+		if !listed(r.URLStatus, r.Robots, r.Canonical) {
+			continue
+		}
+		u := SitemapURL{Loc: r.URL}
+		if r.LastModified != nil {
+			u.LastMod = *r.LastModified
+		}
+		urls = append(urls, u)
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].Loc < urls[j].Loc })
+
+	return urls
+}
+
+// listed returns true if a crawled URL belongs in an XML sitemap: it is Indexable, carries no noindex robots directive
+// and declares no canonical URL other than itself.
+func listed(s URLStatus, robots []string, canonical string) bool {
+	return s.Indexable() && !hasDirective(robots, DirectiveNoIndex) && canonical == ""
+}
+
 // UpdateURLWithLinks associates the provided slice of links with the given parent URL.
 func (sm *SiteMap) UpdateURLWithLinks(u string, newLinks []string) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+	u = sm.key(u)
 	linkMap := sm.lm[u]
 
 	for _, nl := range newLinks {
-		linkMap[nl] = struct{}{}
+		linkMap[sm.key(nl)] = struct{}{}
 	}
 
 	sm.lm[u] = linkMap
 }
 
-// MarshalJSON is provided to aid the marshalling of the internal linkMap structure to a more JSON friendly format
-func (lm *linkMap) MarshalJSON() ([]byte, error) {
-	type urlLinks struct {
-		URL   string
-		Links links
+// UpdateURLWithAssets associates the provided slice of asset URLs (images, scripts and stylesheets) with the given
+// parent URL.
+func (sm *SiteMap) UpdateURLWithAssets(u string, assets []string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	assetMap, exists := sm.assets[u]
+	if !exists {
+		assetMap = links{}
+	}
+
+	for _, a := range assets {
+		assetMap[a] = struct{}{}
+	}
+
+	sm.assets[u] = assetMap
+}
+
+// GetAssets returns the asset URLs recorded for a given URL.
+func (sm *SiteMap) GetAssets(u string) []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	var assets []string
+	for k := range sm.assets[u] {
+		assets = append(assets, k)
 	}
+	sort.Strings(assets)
+	return assets
+}
 
-	urls := make([]urlLinks, 0)
+// UpdateURLWithExternalLinks associates the provided slice of links to hosts outside the crawl with the given parent
+// URL.
+func (sm *SiteMap) UpdateURLWithExternalLinks(u string, external []string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	u = sm.key(u)
+	linkMap, exists := sm.external[u]
+	if !exists {
+		linkMap = links{}
+	}
 
-	for k, v := range *lm {
-		ul := &urlLinks{URL: k, Links: v}
-		urls = append(urls, *ul)
+	for _, l := range external {
+		linkMap[l] = struct{}{}
 	}
 
-	j, err := json.Marshal(urls)
-	if err != nil {
-		return nil, err
+	sm.external[u] = linkMap
+}
+
+// GetExternalLinks returns the links to hosts outside the crawl recorded for a given URL.
+func (sm *SiteMap) GetExternalLinks(u string) []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	var external []string
+	for k := range sm.external[u] {
+		external = append(external, k)
 	}
+	sort.Strings(external)
+	return external
+}
 
-	return j, nil
+// urlResult is the JSON representation of a crawled URL, the links found at the URL, how the URL was discovered and the
+// outcome of fetching it.
+type urlResult struct {
+	URL          string
+	Depth        int
+	Parent       string     `json:",omitempty"`
+	Discovered   *time.Time `json:",omitempty"`
+	LastModified *time.Time `json:",omitempty"`
+	Links        links
+	Assets       links    `json:",omitempty"`
+	External     links    `json:",omitempty"`
+	Robots       []string `json:",omitempty"`
+	Canonical    string   `json:",omitempty"`
+	URLStatus
+}
+
+// results returns an entry for each crawled URL, in the sort order of the SiteMap. The caller must hold the mutex.
+func (sm *SiteMap) results() []urlResult {
+	urls := make([]urlResult, 0, len(sm.lm))
+
+	for k := range sm.lm {
+		urls = append(urls, sm.urlResult(k))
+	}
+
+	byURL := func(i, j int) bool { return urls[i].URL < urls[j].URL }
+	if sm.order == SortByDepth {
+		sort.Slice(urls, func(i, j int) bool {
+			if urls[i].Depth != urls[j].Depth {
+				return urls[i].Depth < urls[j].Depth
+			}
+			return byURL(i, j)
+		})
+	} else {
+		sort.Slice(urls, byURL)
+	}
+
+	return urls
+}
+
+// result returns the entry for a crawled URL, and false if the URL has not been crawled.
+func (sm *SiteMap) result(u string) (urlResult, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	u = sm.key(u)
+	if _, exists := sm.lm[u]; !exists {
+		return urlResult{}, false
+	}
+	return sm.urlResult(u), true
+}
+
+// urlResult returns the entry for a crawled URL. The caller must hold the mutex.
+func (sm *SiteMap) urlResult(u string) urlResult {
+	r := urlResult{
+		URL:       u,
+		Links:     sm.resolveRedirects(sm.lm[u]),
+		Assets:    sm.assets[u],
+		External:  sm.external[u],
+		Robots:    sm.robots[u],
+		Canonical: sm.canonical[u],
+		URLStatus: sm.status[u],
+	}
+	if d, ok := sm.discovered[u]; ok {
+		r.Depth, r.Parent, r.Discovered = d.Depth, d.Parent, &d.Time
+	}
+	if lm, ok := sm.lastModified[u]; ok {
+		r.LastModified = &lm
+	}
+	return r
+}
+
+// resolveRedirects returns the links with any URL which was recorded under the final URL of its redirect chain replaced
+// by the final URL. The caller must hold the mutex.
+func (sm *SiteMap) resolveRedirects(ls links) links {
+	if len(sm.redirected) == 0 {
+		return ls
+	}
+	resolved := make(links, len(ls))
+	for l := range ls {
+		if s, ok := sm.redirected[l]; ok {
+			l = sm.key(s.FinalURL)
+		}
+		resolved[l] = struct{}{}
+	}
+	return resolved
 }
 
 // MarshalJSON is provided to aid the marshalling of the internal map structure for a parent URL to a slice of link strings.
@@ -103,14 +546,54 @@ func (ls links) MarshalJSON() ([]byte, error) {
 	return j, nil
 }
 
+// MarshalJSON is provided to aid the marshalling of the skipped URLs to a slice sorted by URL.
+func (s skippedMap) MarshalJSON() ([]byte, error) {
+	type skippedURL struct {
+		URL    string
+		Reason string
+	}
+
+	urls := make([]skippedURL, 0, len(s))
+	for k, v := range s {
+		urls = append(urls, skippedURL{URL: k, Reason: v})
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].URL < urls[j].URL })
+
+	return json.Marshal(urls)
+}
+
 func (sm *SiteMap) MarshalJSON() ([]byte, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
 
 	jsm := struct {
-		Count   int
-		Results *linkMap
+		Count     int
+		Truncated bool `json:",omitempty"`
+		Results   []urlResult
+		Skipped   skippedMap `json:",omitempty"`
+		Discovery *Discovery `json:",omitempty"`
+		// ExternalDomains aggregates the links to other hosts recorded in each result
+		ExternalDomains []ExternalDomain `json:",omitempty"`
+		// Redirects reports the redirect chains, including those of URLs recorded under their final URL
+		Redirects []RedirectChain `json:",omitempty"`
 	}{
-		Count:   len(sm.lm),
-		Results: &sm.lm,
+		Count:     len(sm.lm),
+		Truncated: sm.truncated,
+		Results:   sm.results(),
+		Skipped:   sm.skipped,
+	}
+	// The discovery report is only meaningful when sitemap files were used to seed the crawl
+	if len(sm.inSitemap) > 0 {
+		d := sm.discovery()
+		jsm.Discovery = &d
+	}
+
+	if len(sm.external) > 0 {
+		jsm.ExternalDomains = FindExternalDomains(sm.externalResults())
+	}
+
+	if chains := FindRedirectChains(sm.redirectResults()); len(chains) > 0 {
+		jsm.Redirects = chains
 	}
 
 	j, err := json.Marshal(jsm)
@@ -120,3 +603,40 @@ func (sm *SiteMap) MarshalJSON() ([]byte, error) {
 
 	return j, nil
 }
+
+// ReadResults reads the crawl results from a SiteMap written as JSON, such as the saved output of sm, or from results
+// streamed as newline delimited JSON.
+func ReadResults(r io.Reader) ([]Result, error) {
+	dec := json.NewDecoder(r)
+	var first json.RawMessage
+	if err := dec.Decode(&first); err != nil {
+		return nil, err
+	}
+	var saved struct {
+		URL     string
+		Results []Result
+	}
+	if err := json.Unmarshal(first, &saved); err != nil {
+		return nil, err
+	}
+	if saved.URL == "" {
+		return saved.Results, nil
+	}
+
+	// Newline delimited JSON holds a single result on each line
+	var result Result
+	if err := json.Unmarshal(first, &result); err != nil {
+		return nil, err
+	}
+	results := []Result{result}
+	nd := &NDJSONDecoder{dec: dec}
+	for {
+		var result Result
+		if err := nd.Decode(&result); err == io.EOF {
+			return results, nil
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+}
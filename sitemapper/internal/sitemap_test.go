@@ -5,38 +5,39 @@ import (
 	"encoding/json"
 	is2 "github.com/matryer/is"
 	"testing"
+	"time"
 )
 
 func TestSiteMap_AddUrl(t *testing.T) {
 	sm := NewSiteMap()
-	sm.AddURL("https://www.example.com")
+	sm.AddURL("https://www.example.com/")
 
 	is := is2.New(t)
 
-	is.Equal(sm.lm["https://www.example.com"], links{})
+	is.Equal(sm.lm["https://www.example.com/"], links{})
 }
 
 func TestSiteMap_UpdateUrlWithLinks(t *testing.T) {
 	sm := NewSiteMap()
-	u := "https://www.example.com"
+	u := "https://www.example.com/"
 	sm.AddURL(u)
 
-	l := []string{"https://link.one/", "https://link.two"}
+	l := []string{"https://link.one/", "https://link.two/"}
 
 	is := is2.New(t)
 	sm.UpdateURLWithLinks(u, l)
 
 	expectedMap := links{
 		"https://link.one/": struct{}{},
-		"https://link.two":  struct{}{},
+		"https://link.two/": struct{}{},
 	}
 
-	is.Equal(sm.lm["https://www.example.com"], expectedMap)
+	is.Equal(sm.lm["https://www.example.com/"], expectedMap)
 }
 
 func TestSiteMap_EncodeOutput(t *testing.T) {
 	sm := NewSiteMap()
-	u := "https://www.example.com"
+	u := "https://www.example.com/"
 
 	type result struct {
 		URL   string
@@ -52,10 +53,10 @@ func TestSiteMap_EncodeOutput(t *testing.T) {
 		Count: 1,
 		Results: []result{
 			{
-				URL: "https://www.example.com",
+				URL: "https://www.example.com/",
 				Links: []string{
 					"https://link.one/",
-					"https://link.two",
+					"https://link.two/",
 				},
 			},
 		},
@@ -74,3 +75,156 @@ func TestSiteMap_EncodeOutput(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(actual, expected)
 }
+
+func TestSiteMap_SitemapURLs(t *testing.T) {
+	sm := NewSiteMap()
+	lm := time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+	sm.AddURL("https://www.example.com/b")
+	sm.SetStatus("https://www.example.com/b", URLStatus{StatusCode: 200})
+	sm.AddURL("https://www.example.com/a")
+	sm.SetStatus("https://www.example.com/a", URLStatus{StatusCode: 204})
+	sm.SetLastModified("https://www.example.com/b", lm)
+	sm.AddSkippedURL("https://www.example.com/c", SkipReasonRobots)
+	sm.AddURL("https://www.example.com/missing")
+	sm.SetStatus("https://www.example.com/missing", URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+	sm.AddURL("https://www.example.com/error")
+	sm.SetStatus("https://www.example.com/error", URLStatus{StatusCode: 500, ErrorClass: ErrorClassHTTPStatus})
+	sm.AddURL("https://www.example.com/timeout")
+	sm.SetStatus("https://www.example.com/timeout", URLStatus{ErrorClass: ErrorClassTimeout})
+	sm.AddURL("https://www.example.com/unparsed")
+	sm.SetStatus("https://www.example.com/unparsed", URLStatus{StatusCode: 200, ErrorClass: ErrorClassParse})
+
+	is := is2.New(t)
+	is.Equal(sm.SitemapURLs(), []SitemapURL{
+		{Loc: "https://www.example.com/a"},
+		{Loc: "https://www.example.com/b", LastMod: lm},
+	})
+}
+
+func TestResultSitemapURLs(t *testing.T) {
+	is := is2.New(t)
+	sm := NewSiteMap()
+	sm.AddURL("https://www.example.com/b")
+	sm.SetStatus("https://www.example.com/b", URLStatus{StatusCode: 200})
+	sm.AddURL("https://www.example.com/a")
+	sm.SetStatus("https://www.example.com/a", URLStatus{StatusCode: 200})
+	lm := time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+	sm.SetLastModified("https://www.example.com/b", lm)
+	sm.AddURL("https://www.example.com/hidden")
+	sm.SetStatus("https://www.example.com/hidden", URLStatus{StatusCode: 200})
+	sm.SetRobotsDirectives("https://www.example.com/hidden", []string{DirectiveNoIndex})
+	sm.AddURL("https://www.example.com/copy")
+	sm.SetStatus("https://www.example.com/copy", URLStatus{StatusCode: 200})
+	sm.SetCanonical("https://www.example.com/copy", "https://www.example.com/a")
+	sm.AddURL("https://www.example.com/missing")
+	sm.SetStatus("https://www.example.com/missing", URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+
+	// Results sent by crawl jobs are decoded from the SiteMap's JSON, so the directives, canonical URL and Last-Modified
+	// time are kept
+	b, err := json.Marshal(sm)
+	is.NoErr(err)
+	var rc ResultContainer
+	is.NoErr(json.Unmarshal(b, &rc))
+	expected := []SitemapURL{{Loc: "https://www.example.com/a"}, {Loc: "https://www.example.com/b", LastMod: lm}}
+	is.Equal(ResultSitemapURLs(rc.Results), expected)
+	is.Equal(sm.SitemapURLs(), expected)
+}
+
+func TestSiteMap_EncodeStatus(t *testing.T) {
+	is := is2.New(t)
+	sm := NewSiteMap()
+	sm.AddURL("https://www.example.com/")
+	sm.SetStatus("https://www.example.com/", URLStatus{StatusCode: 200, FinalURL: "https://www.example.com/", ContentType: "text/html", DurationMs: 12})
+	sm.AddURL("https://www.example.com/missing.html")
+	sm.SetStatus("https://www.example.com/missing.html", URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+
+	b, err := json.Marshal(sm)
+	is.NoErr(err)
+	var rc ResultContainer
+	is.NoErr(json.Unmarshal(b, &rc))
+	is.Equal(rc.Count, 2)
+
+	statuses := map[string]URLStatus{}
+	for _, r := range rc.Results {
+		statuses[r.URL] = r.URLStatus
+	}
+	is.Equal(statuses["https://www.example.com/"], URLStatus{StatusCode: 200, FinalURL: "https://www.example.com/", ContentType: "text/html", DurationMs: 12})
+	is.Equal(statuses["https://www.example.com/missing.html"], URLStatus{StatusCode: 404, ErrorClass: ErrorClassHTTPStatus})
+	is.True(!statuses["https://www.example.com/missing.html"].OK())
+}
+
+func TestSiteMap_SetDiscovered(t *testing.T) {
+	is := is2.New(t)
+	sm := NewSiteMap()
+	sm.SetDiscovered("https://www.example.com/", "https://www.example.com/ignored", 0)
+	sm.SetDiscovered("https://www.example.com/c", "https://www.example.com/b", 2)
+	first, _ := sm.GetDiscovered("https://www.example.com/c")
+
+	// A shallower depth replaces the parent but keeps the time first found, while a deeper or equal one is ignored
+	sm.SetDiscovered("https://www.example.com/c", "https://www.example.com/", 1)
+	sm.SetDiscovered("https://www.example.com/c", "https://www.example.com/a", 1)
+	sm.SetDiscovered("https://www.example.com/c", "https://www.example.com/d", 3)
+
+	d, ok := sm.GetDiscovered("https://www.example.com/")
+	is.True(ok)
+	is.Equal(d.Depth, 0)
+	is.Equal(d.Parent, "")
+	d, ok = sm.GetDiscovered("https://www.example.com/c")
+	is.True(ok)
+	is.Equal(d, Discovered{Depth: 1, Parent: "https://www.example.com/", Time: first.Time})
+	_, ok = sm.GetDiscovered("https://www.example.com/missing")
+	is.True(!ok)
+
+	sm.AddURL("https://www.example.com/c")
+	b, err := json.Marshal(sm)
+	is.NoErr(err)
+	var rc ResultContainer
+	is.NoErr(json.Unmarshal(b, &rc))
+	is.Equal(len(rc.Results), 1)
+	is.Equal(rc.Results[0].Depth, 1)
+	is.Equal(rc.Results[0].Parent, "https://www.example.com/")
+	is.True(rc.Results[0].Discovered != nil && rc.Results[0].Discovered.Equal(first.Time))
+}
+
+func TestSiteMap_SetSortOrder(t *testing.T) {
+	data := []struct {
+		order    string
+		expected []string
+	}{
+		{"", []string{"https://www.example.com/", "https://www.example.com/a", "https://www.example.com/a/b", "https://www.example.com/z"}},
+		{SortByURL, []string{"https://www.example.com/", "https://www.example.com/a", "https://www.example.com/a/b", "https://www.example.com/z"}},
+		{SortByDepth, []string{"https://www.example.com/", "https://www.example.com/a", "https://www.example.com/z", "https://www.example.com/a/b"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.order, func(t *testing.T) {
+			is := is2.New(t)
+			sm := NewSiteMap()
+			if d.order != "" {
+				is.NoErr(sm.SetSortOrder(d.order))
+			}
+			for u, depth := range map[string]int{"https://www.example.com/z": 1, "https://www.example.com/a/b": 2, "https://www.example.com/": 0, "https://www.example.com/a": 1} {
+				sm.AddURL(u)
+				sm.SetDiscovered(u, "https://www.example.com/", depth)
+			}
+
+			b, err := json.Marshal(sm)
+			is.NoErr(err)
+			var rc ResultContainer
+			is.NoErr(json.Unmarshal(b, &rc))
+			var urls []string
+			for _, r := range rc.Results {
+				urls = append(urls, r.URL)
+			}
+			is.Equal(urls, d.expected)
+
+			// The output is identical each time it is written
+			again, err := json.Marshal(sm)
+			is.NoErr(err)
+			is.Equal(string(again), string(b))
+		})
+	}
+
+	is := is2.New(t)
+	is.True(NewSiteMap().SetSortOrder("random") != nil)
+}
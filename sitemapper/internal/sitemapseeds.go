@@ -0,0 +1,99 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxSitemapNesting limits how many levels of sitemap index files are followed, protecting against index loops.
+const maxSitemapNesting = 3
+
+// DiscoverSitemapURLs finds the sitemap files published for the site at root and returns the page URLs they list.
+// Sitemap files are located using the "Sitemap:" lines in robots.txt, falling back to /sitemap.xml if there are none.
+// Nested sitemap index files and gzip compressed sitemaps are followed. Only URLs on the hosts within the crawl's
+// HostScope are returned, as only those would be crawled. A sitemap file which cannot be retrieved or parsed is logged
+// and ignored.
+func DiscoverSitemapURLs(ctx context.Context, root string, hs HostScope, rc *RobotsChecker) ([]string, error) {
+	rootUrl, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	if rc == nil {
+		rc = NewRobotsChecker(DefaultUserAgent)
+	}
+	normalizer := &Normalizer{}
+	inHost := hs.matcher(normalizer.NormalizeURL(rootUrl))
+
+	robots, err := rc.Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	pending := robots.Sitemaps
+	if len(pending) == 0 {
+		pending = []string{rootUrl.Scheme + "://" + rootUrl.Host + "/sitemap.xml"}
+	}
+
+	// Sitemap files can be large, so more time is allowed to fetch them than for a page
+	client := rc.client.withMinTimeout(30 * time.Second)
+	seenSitemaps := map[string]struct{}{}
+	seenURLs := map[string]struct{}{}
+	var urls []string
+
+	for level := 0; level <= maxSitemapNesting && len(pending) > 0; level++ {
+		var next []string
+		for _, s := range pending {
+			if _, ok := seenSitemaps[s]; ok {
+				continue
+			}
+			seenSitemaps[s] = struct{}{}
+
+			pageURLs, nested, err := fetchSitemap(ctx, client, s)
+			if err != nil {
+				log.Printf("error reading sitemap %s: %v", s, err)
+				continue
+			}
+			log.Printf("found %d URLs and %d nested sitemaps in sitemap %s", len(pageURLs), len(nested), s)
+			next = append(next, nested...)
+
+			for _, p := range pageURLs {
+				pu, err := url.Parse(p)
+				if err != nil || !inHost(normalizer.NormalizeURL(pu).Host) {
+					continue
+				}
+				if _, ok := seenURLs[p]; ok {
+					continue
+				}
+				seenURLs[p] = struct{}{}
+				urls = append(urls, p)
+			}
+		}
+		pending = next
+	}
+
+	return urls, nil
+}
+
+// fetchSitemap retrieves and parses a single sitemap or sitemap index file.
+func fetchSitemap(ctx context.Context, client *HTTPClient, u string) ([]string, []string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("received HTTP response code %d for sitemap %s", resp.StatusCode, u)
+	}
+
+	return ParseSitemap(io.LimitReader(resp.Body, MaxSitemapBytes))
+}
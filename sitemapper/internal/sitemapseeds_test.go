@@ -0,0 +1,89 @@
+package sitemap
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscoverSitemapURLs(t *testing.T) {
+	is := is.New(t)
+	var srvURL string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "User-agent: *\nDisallow:\nSitemap: %s/sitemap_index.xml\n", srvURL)
+	})
+	mux.HandleFunc("/sitemap_index.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<sitemapindex>
+<sitemap><loc>%[1]s/sitemap-1.xml.gz</loc></sitemap>
+<sitemap><loc>%[1]s/sitemap_index.xml</loc></sitemap>
+<sitemap><loc>%[1]s/missing.xml</loc></sitemap>
+</sitemapindex>`, srvURL)
+	})
+	mux.HandleFunc("/sitemap-1.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		gw := gzip.NewWriter(w)
+		fmt.Fprintf(gw, `<urlset>
+<url><loc>%[1]s/linked.html</loc></url>
+<url><loc>%[1]s/orphan.html</loc></url>
+<url><loc>%[1]s/orphan.html</loc></url>
+<url><loc>https://external.example.com/page.html</loc></url>
+</urlset>`, srvURL)
+		is.NoErr(gw.Close())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/linked.html">linked</a><a href="/unlisted.html">unlisted</a>`)
+		case "/linked.html", "/orphan.html", "/unlisted.html":
+			fmt.Fprint(w, "no links")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	srvURL = srv.URL
+
+	seeds, err := DiscoverSitemapURLs(context.Background(), srv.URL, HostScope{}, nil)
+	is.NoErr(err)
+	is.Equal(seeds, []string{srv.URL + "/linked.html", srv.URL + "/orphan.html"})
+
+	// URLs on other hosts are included when the host scope covers them
+	hs := HostScope{Mode: HostScopeList, Hosts: []string{"*.example.com"}}
+	scoped, err := DiscoverSitemapURLs(context.Background(), srv.URL, hs, nil)
+	is.NoErr(err)
+	is.Equal(scoped, []string{srv.URL + "/linked.html", srv.URL + "/orphan.html", "https://external.example.com/page.html"})
+
+	sm := NewSiteMap()
+	c := NewSynchronousCrawlEngine(sm, 2, srv.URL, WithSitemapSeeds(seeds))
+	c.Run(context.Background())
+
+	_, visited := sm.GetLinks(srv.URL + "/orphan.html")
+	is.True(visited) // sitemap seeds are crawled even if nothing links to them
+	is.Equal(sm.Discovery(), Discovery{
+		SitemapOnly: []string{srv.URL + "/orphan.html"},
+		LinkOnly:    []string{srv.URL + "/unlisted.html"},
+	})
+}
+
+func TestDiscoverSitemapURLs_DefaultLocation(t *testing.T) {
+	is := is.New(t)
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sitemap.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<urlset><url><loc>%s/page.html</loc></url></urlset>`, srvURL)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	seeds, err := DiscoverSitemapURLs(context.Background(), srv.URL, HostScope{}, NewRobotsChecker(DefaultUserAgent))
+	is.NoErr(err)
+	is.Equal(seeds, []string{srv.URL + "/page.html"})
+}
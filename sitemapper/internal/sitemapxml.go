@@ -0,0 +1,371 @@
+package sitemap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The limits for a single sitemap file as defined by the sitemaps.org protocol.
+const (
+	MaxSitemapURLs  = 50000
+	MaxSitemapBytes = 50 * 1024 * 1024
+)
+
+const (
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	urlsetOpen       = xml.Header + `<urlset xmlns="` + sitemapNamespace + `">` + "\n"
+	urlsetClose      = "</urlset>\n"
+	indexOpen        = xml.Header + `<sitemapindex xmlns="` + sitemapNamespace + `">` + "\n"
+	indexClose       = "</sitemapindex>\n"
+)
+
+// validChangeFreqs are the values permitted for the <changefreq> element.
+var validChangeFreqs = map[string]struct{}{
+	"always": {}, "hourly": {}, "daily": {}, "weekly": {}, "monthly": {}, "yearly": {}, "never": {},
+}
+
+// ErrSitemapTooLarge is returned when URLs do not fit into a single sitemap file and must be split across files.
+var ErrSitemapTooLarge = fmt.Errorf("sitemap exceeds the limit of %d URLs or %d bytes for a single file", MaxSitemapURLs, MaxSitemapBytes)
+
+// A SitemapURL is a single <url> entry in a sitemaps.org XML sitemap.
+// A zero LastMod, empty ChangeFreq or zero Priority is omitted from the output.
+type SitemapURL struct {
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+}
+
+// xmlURL is the XML representation of a SitemapURL.
+type xmlURL struct {
+	XMLName    xml.Name `xml:"url"`
+	Loc        string   `xml:"loc"`
+	LastMod    string   `xml:"lastmod,omitempty"`
+	ChangeFreq string   `xml:"changefreq,omitempty"`
+	Priority   string   `xml:"priority,omitempty"`
+}
+
+// xmlSitemap is the XML representation of a <sitemap> entry within a <sitemapindex>.
+type xmlSitemap struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+}
+
+// An XMLEncoder writes SitemapURL entries as sitemaps.org XML documents.
+// ChangeFreq and Priority are applied to any entry which does not specify its own value.
+// MaxURLs and MaxBytes default to the protocol limits and are only lowered for testing purposes.
+type XMLEncoder struct {
+	ChangeFreq string
+	Priority   float64
+	Gzip       bool
+	MaxURLs    int
+	MaxBytes   int
+}
+
+// NewXMLEncoder returns an XMLEncoder using the sitemaps.org size limits.
+func NewXMLEncoder() *XMLEncoder {
+	return &XMLEncoder{MaxURLs: MaxSitemapURLs, MaxBytes: MaxSitemapBytes}
+}
+
+// Validate checks that the ChangeFreq and Priority values are permitted by the sitemaps.org protocol.
+func (e *XMLEncoder) Validate() error {
+	if e.ChangeFreq != "" {
+		if _, ok := validChangeFreqs[e.ChangeFreq]; !ok {
+			return fmt.Errorf("invalid changefreq %q", e.ChangeFreq)
+		}
+	}
+	if e.Priority < 0 || e.Priority > 1 {
+		return fmt.Errorf("invalid priority %v, must be between 0.0 and 1.0", e.Priority)
+	}
+	return nil
+}
+
+// Encode writes all URLs as a single <urlset> document. If the URLs exceed the size limits for a single file
+// ErrSitemapTooLarge is returned and nothing is written.
+func (e *XMLEncoder) Encode(w io.Writer, urls []SitemapURL) error {
+	chunks, err := e.chunk(urls)
+	if err != nil {
+		return err
+	}
+	if len(chunks) > 1 {
+		return ErrSitemapTooLarge
+	}
+	var chunk [][]byte
+	if len(chunks) == 1 {
+		chunk = chunks[0].entries
+	}
+	return e.write(w, urlsetOpen, chunk, urlsetClose)
+}
+
+// Pages returns the number of sitemap files needed to hold the URLs, which is 1 if they fit into a single file.
+func (e *XMLEncoder) Pages(urls []SitemapURL) (int, error) {
+	chunks, err := e.chunk(urls)
+	if err != nil {
+		return 0, err
+	}
+	if len(chunks) == 0 {
+		return 1, nil
+	}
+	return len(chunks), nil
+}
+
+// EncodePage writes the URLs of a single sitemap file as a <urlset> document, where the files are numbered from 1 in
+// the same way as by WriteFiles. An error is returned if there is no such page.
+func (e *XMLEncoder) EncodePage(w io.Writer, urls []SitemapURL, page int) error {
+	chunks, err := e.chunk(urls)
+	if err != nil {
+		return err
+	}
+	if page == 1 && len(chunks) == 0 {
+		return e.write(w, urlsetOpen, nil, urlsetClose)
+	}
+	if page < 1 || page > len(chunks) {
+		return fmt.Errorf("sitemap page %d not found, there are %d pages", page, len(chunks))
+	}
+	return e.write(w, urlsetOpen, chunks[page-1].entries, urlsetClose)
+}
+
+// EncodeIndex writes a <sitemapindex> document referencing each sitemap file needed to hold the URLs, with the
+// location of each file given by loc for its page number.
+func (e *XMLEncoder) EncodeIndex(w io.Writer, urls []SitemapURL, loc func(page int) string) error {
+	chunks, err := e.chunk(urls)
+	if err != nil {
+		return err
+	}
+	var index [][]byte
+	for i, c := range chunks {
+		b, err := indexEntry(c, loc(i+1))
+		if err != nil {
+			return err
+		}
+		index = append(index, b)
+	}
+	return e.write(w, indexOpen, index, indexClose)
+}
+
+// WriteFiles writes the URLs to sitemap files in the given directory. If all URLs fit into a single file it is named
+// sitemap.xml. Otherwise the URLs are split into sitemap-1.xml, sitemap-2.xml, etc. and a sitemap_index.xml file
+// referencing each of them is written, with each location prefixed by baseURL.
+// A ".gz" suffix is added to each file name when gzip compression is enabled.
+// The paths of all files written are returned, with the index file (if any) first.
+func (e *XMLEncoder) WriteFiles(dir, baseURL string, urls []SitemapURL) ([]string, error) {
+	chunks, err := e.chunk(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := ".xml"
+	if e.Gzip {
+		ext += ".gz"
+	}
+
+	if len(chunks) <= 1 {
+		var entries [][]byte
+		if len(chunks) == 1 {
+			entries = chunks[0].entries
+		}
+		p := filepath.Join(dir, "sitemap"+ext)
+		return []string{p}, e.writeFile(p, urlsetOpen, entries, urlsetClose)
+	}
+
+	indexPath := filepath.Join(dir, "sitemap_index"+ext)
+	paths := []string{indexPath}
+	var index [][]byte
+	for i, c := range chunks {
+		name := fmt.Sprintf("sitemap-%d%s", i+1, ext)
+		p := filepath.Join(dir, name)
+		if err := e.writeFile(p, urlsetOpen, c.entries, urlsetClose); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+
+		b, err := indexEntry(c, strings.TrimSuffix(baseURL, "/")+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, b)
+	}
+
+	if err := e.writeFile(indexPath, indexOpen, index, indexClose); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// indexEntry marshals the <sitemap> entry of a sitemap index for the chunk stored at loc.
+func indexEntry(c sitemapChunk, loc string) ([]byte, error) {
+	s := xmlSitemap{Loc: loc}
+	if !c.lastMod.IsZero() {
+		s.LastMod = c.lastMod.UTC().Format(time.RFC3339)
+	}
+	return xml.Marshal(s)
+}
+
+// sitemapChunk is a group of encoded <url> entries which fit into a single sitemap file.
+type sitemapChunk struct {
+	entries [][]byte
+	lastMod time.Time
+}
+
+// chunk encodes each URL and groups the encoded entries so that no group exceeds the URL count or byte limits.
+func (e *XMLEncoder) chunk(urls []SitemapURL) ([]sitemapChunk, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	maxURLs, maxBytes := e.MaxURLs, e.MaxBytes
+	if maxURLs <= 0 {
+		maxURLs = MaxSitemapURLs
+	}
+	if maxBytes <= 0 {
+		maxBytes = MaxSitemapBytes
+	}
+	overhead := len(urlsetOpen) + len(urlsetClose)
+
+	var chunks []sitemapChunk
+	var current *sitemapChunk
+	size := 0
+	for _, u := range urls {
+		b, err := e.encodeURL(u)
+		if err != nil {
+			return nil, err
+		}
+		if overhead+len(b) > maxBytes {
+			return nil, fmt.Errorf("URL %s is too large to fit in a sitemap file", u.Loc)
+		}
+		if current == nil || len(current.entries) == maxURLs || size+len(b) > maxBytes {
+			chunks = append(chunks, sitemapChunk{})
+			current = &chunks[len(chunks)-1]
+			size = overhead
+		}
+		current.entries = append(current.entries, b)
+		size += len(b)
+		if u.LastMod.After(current.lastMod) {
+			current.lastMod = u.LastMod
+		}
+	}
+
+	return chunks, nil
+}
+
+// encodeURL marshals a single SitemapURL to its XML form, followed by a newline.
+func (e *XMLEncoder) encodeURL(u SitemapURL) ([]byte, error) {
+	x := xmlURL{Loc: u.Loc, ChangeFreq: u.ChangeFreq}
+	if !u.LastMod.IsZero() {
+		x.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+	}
+	if x.ChangeFreq == "" {
+		x.ChangeFreq = e.ChangeFreq
+	}
+	priority := u.Priority
+	if priority == 0 {
+		priority = e.Priority
+	}
+	if priority != 0 {
+		x.Priority = strconv.FormatFloat(priority, 'f', -1, 64)
+	}
+
+	b, err := xml.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// writeFile creates the file at path p and writes the document to it.
+func (e *XMLEncoder) writeFile(p, head string, entries [][]byte, tail string) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if err := e.write(f, head, entries, tail); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// write writes the opening element, each entry and the closing element, compressing the output if gzip is enabled.
+func (e *XMLEncoder) write(w io.Writer, head string, entries [][]byte, tail string) error {
+	var gw *gzip.Writer
+	if e.Gzip {
+		gw = gzip.NewWriter(w)
+		w = gw
+	}
+
+	if _, err := io.WriteString(w, head); err != nil {
+		return err
+	}
+	for _, b := range entries {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, tail); err != nil {
+		return err
+	}
+
+	if gw != nil {
+		return gw.Close()
+	}
+	return nil
+}
+
+// ParseSitemap reads a sitemaps.org <urlset> or <sitemapindex> document, which may be gzip compressed.
+// The page locations from a <urlset> are returned as urls, and the nested sitemap locations from a <sitemapindex>
+// are returned as sitemaps.
+func ParseSitemap(r io.Reader) (urls []string, sitemaps []string, err error) {
+	br := bufio.NewReader(r)
+	// Compressed sitemaps are detected using the gzip magic number, as servers do not reliably set Content-Type
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gr.Close()
+		r = io.LimitReader(gr, MaxSitemapBytes)
+	} else {
+		r = br
+	}
+
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "url" && se.Name.Local != "sitemap") {
+			continue
+		}
+		var entry struct {
+			Loc string `xml:"loc"`
+		}
+		if err := dec.DecodeElement(&entry, &se); err != nil {
+			return nil, nil, err
+		}
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+		if se.Name.Local == "url" {
+			urls = append(urls, loc)
+		} else {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+
+	return urls, sitemaps, nil
+}
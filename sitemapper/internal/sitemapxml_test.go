@@ -0,0 +1,266 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/matryer/is"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testURLSet struct {
+	URLs []struct {
+		Loc        string `xml:"loc"`
+		LastMod    string `xml:"lastmod"`
+		ChangeFreq string `xml:"changefreq"`
+		Priority   string `xml:"priority"`
+	} `xml:"url"`
+}
+
+type testSitemapIndex struct {
+	Sitemaps []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"sitemap"`
+}
+
+func testSitemapURLs(n int) []SitemapURL {
+	urls := make([]SitemapURL, n)
+	for i := range urls {
+		urls[i] = SitemapURL{Loc: fmt.Sprintf("https://example.com/page%d.html", i)}
+	}
+	return urls
+}
+
+func TestXMLEncoder_Encode(t *testing.T) {
+	is := is.New(t)
+	lm := time.Date(2022, 1, 3, 12, 20, 53, 0, time.UTC)
+	urls := []SitemapURL{
+		{Loc: "https://example.com/", LastMod: lm},
+		{Loc: "https://example.com/a?b=1&c=2", ChangeFreq: "daily", Priority: 0.8},
+	}
+
+	xe := NewXMLEncoder()
+	xe.ChangeFreq = "weekly"
+	xe.Priority = 0.5
+	var b bytes.Buffer
+	is.NoErr(xe.Encode(&b, urls))
+	is.True(bytes.HasPrefix(b.Bytes(), []byte(xml.Header+`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)))
+
+	var us testURLSet
+	is.NoErr(xml.Unmarshal(b.Bytes(), &us))
+	is.Equal(len(us.URLs), 2)
+	is.Equal(us.URLs[0].Loc, "https://example.com/")
+	is.Equal(us.URLs[0].LastMod, "2022-01-03T12:20:53Z")
+	is.Equal(us.URLs[0].ChangeFreq, "weekly")
+	is.Equal(us.URLs[0].Priority, "0.5")
+	is.Equal(us.URLs[1].Loc, "https://example.com/a?b=1&c=2")
+	is.Equal(us.URLs[1].LastMod, "")
+	is.Equal(us.URLs[1].ChangeFreq, "daily")
+	is.Equal(us.URLs[1].Priority, "0.8")
+}
+
+func TestXMLEncoder_EncodeTooLarge(t *testing.T) {
+	data := []struct {
+		name     string
+		maxURLs  int
+		maxBytes int
+	}{
+		{"too many URLs", 2, MaxSitemapBytes},
+		{"too many bytes", MaxSitemapURLs, 250},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			xe := &XMLEncoder{MaxURLs: d.maxURLs, MaxBytes: d.maxBytes}
+			var b bytes.Buffer
+			err := xe.Encode(&b, testSitemapURLs(3))
+			is.True(errors.Is(err, ErrSitemapTooLarge))
+			is.Equal(b.Len(), 0)
+		})
+	}
+}
+
+func TestXMLEncoder_Validate(t *testing.T) {
+	data := []struct {
+		name       string
+		changeFreq string
+		priority   float64
+		valid      bool
+	}{
+		{"defaults", "", 0, true},
+		{"valid values", "monthly", 1, true},
+		{"invalid changefreq", "fortnightly", 0, false},
+		{"invalid priority", "", 1.5, false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			xe := &XMLEncoder{ChangeFreq: d.changeFreq, Priority: d.priority}
+			is.Equal(xe.Validate() == nil, d.valid)
+		})
+	}
+}
+
+func TestXMLEncoder_WriteFiles(t *testing.T) {
+	data := []struct {
+		name          string
+		urls          int
+		gzip          bool
+		expectedFiles []string
+	}{
+		{"single file", 2, false, []string{"sitemap.xml"}},
+		{"split with index", 5, false, []string{"sitemap_index.xml", "sitemap-1.xml", "sitemap-2.xml", "sitemap-3.xml"}},
+		{"split with index gzip", 3, true, []string{"sitemap_index.xml.gz", "sitemap-1.xml.gz", "sitemap-2.xml.gz"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			dir := t.TempDir()
+			xe := &XMLEncoder{MaxURLs: 2, Gzip: d.gzip}
+
+			urls := testSitemapURLs(d.urls)
+			urls[0].LastMod = time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+			files, err := xe.WriteFiles(dir, "https://example.com/", urls)
+			is.NoErr(err)
+
+			is.Equal(len(files), len(d.expectedFiles))
+			for i, f := range files {
+				is.Equal(f, filepath.Join(dir, d.expectedFiles[i]))
+			}
+
+			if len(files) == 1 {
+				var us testURLSet
+				is.NoErr(xml.Unmarshal(readTestFile(t, files[0], d.gzip), &us))
+				is.Equal(len(us.URLs), d.urls)
+				return
+			}
+
+			var idx testSitemapIndex
+			is.NoErr(xml.Unmarshal(readTestFile(t, files[0], d.gzip), &idx))
+			is.Equal(len(idx.Sitemaps), len(files)-1)
+			is.Equal(idx.Sitemaps[0].Loc, "https://example.com/"+d.expectedFiles[1])
+			is.Equal(idx.Sitemaps[0].LastMod, "2022-01-03T00:00:00Z")
+			is.Equal(idx.Sitemaps[1].LastMod, "")
+
+			total := 0
+			for _, f := range files[1:] {
+				var us testURLSet
+				is.NoErr(xml.Unmarshal(readTestFile(t, f, d.gzip), &us))
+				is.True(len(us.URLs) <= 2)
+				total += len(us.URLs)
+			}
+			is.Equal(total, d.urls)
+		})
+	}
+}
+
+func TestXMLEncoder_Pages(t *testing.T) {
+	is := is.New(t)
+	xe := &XMLEncoder{MaxURLs: 2}
+	urls := testSitemapURLs(5)
+
+	n, err := xe.Pages(urls)
+	is.NoErr(err)
+	is.Equal(n, 3)
+
+	var b bytes.Buffer
+	is.NoErr(xe.EncodeIndex(&b, urls, func(page int) string { return fmt.Sprintf("https://example.com/sitemap?page=%d", page) }))
+	var idx testSitemapIndex
+	is.NoErr(xml.Unmarshal(b.Bytes(), &idx))
+	is.Equal(len(idx.Sitemaps), 3)
+	is.Equal(idx.Sitemaps[2].Loc, "https://example.com/sitemap?page=3")
+
+	for page, expected := range map[int]int{1: 2, 2: 2, 3: 1} {
+		b.Reset()
+		is.NoErr(xe.EncodePage(&b, urls, page))
+		var us testURLSet
+		is.NoErr(xml.Unmarshal(b.Bytes(), &us))
+		is.Equal(len(us.URLs), expected)
+	}
+	is.True(xe.EncodePage(&b, urls, 4) != nil)
+
+	n, err = xe.Pages(nil)
+	is.NoErr(err)
+	is.Equal(n, 1)
+}
+
+func readTestFile(t *testing.T, p string, gz bool) []byte {
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if !gz {
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://example.com/ </loc><lastmod>2022-01-03</lastmod></url>
+  <url><loc>https://example.com/a?b=1&amp;c=2</loc></url>
+  <url><loc></loc></url>
+</urlset>`
+	index := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/sitemap-1.xml</loc></sitemap>
+  <sitemap><loc>https://example.com/sitemap-2.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte(urlset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		name             string
+		content          []byte
+		expectedURLs     []string
+		expectedSitemaps []string
+	}{
+		{"urlset", []byte(urlset), []string{"https://example.com/", "https://example.com/a?b=1&c=2"}, nil},
+		{"gzip urlset", gz.Bytes(), []string{"https://example.com/", "https://example.com/a?b=1&c=2"}, nil},
+		{"sitemap index", []byte(index), nil, []string{"https://example.com/sitemap-1.xml", "https://example.com/sitemap-2.xml.gz"}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			is := is.New(t)
+			urls, sitemaps, err := ParseSitemap(bytes.NewReader(d.content))
+			is.NoErr(err)
+			is.Equal(urls, d.expectedURLs)
+			is.Equal(sitemaps, d.expectedSitemaps)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package sitemap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Error classes recorded against URLs which could not be crawled successfully.
+const (
+	ErrorClassHTTPStatus = "http-status"
+	ErrorClassTimeout    = "timeout"
+	ErrorClassCancelled  = "cancelled"
+	ErrorClassDNS        = "dns"
+	ErrorClassTLS        = "tls"
+	ErrorClassConnection = "connection"
+	ErrorClassRead       = "read"
+	ErrorClassParse      = "parse"
+	ErrorClassRequest    = "request"
+
+	ErrorClassRedirectLoop     = "redirect-loop"
+	ErrorClassTooManyRedirects = "too-many-redirects"
+)
+
+// URLStatus describes the outcome of fetching a crawled URL. StatusCode, FinalURL and ContentType are only set when a
+// response was received, and ErrorClass is only set when the URL could not be crawled successfully. Attempts is only
+// set when the URL was requested more than once, and the status is that of the final attempt. Redirects lists each
+// redirect followed on the way to FinalURL. Truncated is set when the page was longer than the maximum body size, so
+// links after the limit were not found.
+type URLStatus struct {
+	StatusCode  int        `json:",omitempty"`
+	FinalURL    string     `json:",omitempty"`
+	ContentType string     `json:",omitempty"`
+	DurationMs  int64      `json:",omitempty"`
+	ErrorClass  string     `json:",omitempty"`
+	Attempts    int        `json:",omitempty"`
+	Redirects   []Redirect `json:",omitempty"`
+	Truncated   bool       `json:",omitempty"`
+}
+
+// OK returns true if the URL was fetched and parsed without error.
+func (s URLStatus) OK() bool {
+	return s.ErrorClass == ""
+}
+
+// Indexable returns true if the URL responded with a 2xx status and was crawled without error, so that it may be
+// listed in an XML sitemap.
+func (s URLStatus) Indexable() bool {
+	return s.StatusCode >= 200 && s.StatusCode < 300 && s.ErrorClass == ""
+}
+
+// errReadBody is returned when the response body for a URL cannot be read.
+var errReadBody = errors.New("error reading response body")
+
+// statusError is returned when a URL responds with an HTTP status code other than 200 OK.
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received HTTP response code %d for site %s", e.code, e.url)
+}
+
+// classifyError returns the error class for an error encountered while fetching a URL.
+func classifyError(err error) string {
+	var se *statusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &se):
+		return ErrorClassHTTPStatus
+	case errors.Is(err, errRedirectLoop):
+		return ErrorClassRedirectLoop
+	case errors.Is(err, errTooManyRedirects):
+		return ErrorClassTooManyRedirects
+	case errors.Is(err, errReadBody):
+		return ErrorClassRead
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname), errors.As(err, &invalidCert),
+		errors.As(err, &recordHeader):
+		return ErrorClassTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &opErr):
+		return ErrorClassConnection
+	default:
+		return ErrorClassRequest
+	}
+}